@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	ACCESS_LOG_TEXT     string = "text"
+	ACCESS_LOG_JSON     string = "json"
+	ACCESS_LOG_COMMON   string = "common"
+	ACCESS_LOG_COMBINED string = "combined"
+	ACCESS_LOG_STDOUT   string = "stdout"
+	CLF_TIME_FORMAT     string = "02/Jan/2006:15:04:05 -0700"
+)
+
+var (
+	ACCESS_LOG_FORMATS []string = []string{ACCESS_LOG_TEXT, ACCESS_LOG_JSON, ACCESS_LOG_COMMON, ACCESS_LOG_COMBINED}
+)
+
+// AccessLogArgs is the configuration file form of the access log.  An empty
+// Path disables the log unless verbose is set, when it is written to stdout.
+// A file is rotated when it would grow beyond MaxSize, keeping MaxBackups of
+// the previous files as path.1, path.2 and so on.
+type AccessLogArgs struct {
+	Path       string `json:"path,omitempty"`
+	Format     string `json:"format,omitempty"`
+	MaxSize    string `json:"maxsize,omitempty"`
+	MaxBackups int    `json:"maxbackups,omitempty"`
+}
+
+type accessLogEntry struct {
+	Time     time.Time `json:"time"`
+	ID       string    `json:"id"`
+	Remote   string    `json:"remote"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Endpoint string    `json:"endpoint"`
+	Fault    string    `json:"fault"`
+	Code     int       `json:"code"`
+	Bytes    int       `json:"bytes"`
+	Duration string    `json:"duration"`
+	Sleep    string    `json:"sleep"`
+}
+
+// AccessLog writes a line for each request in one of the ACCESS_LOG_FORMATS.
+type AccessLog struct {
+	mutex  sync.Mutex
+	Format string
+	writer io.Writer
+}
+
+func (instance *AccessLog) Observe(record *RecordedRequest) {
+	line := FormatAccessLog(instance.Format, record)
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	io.WriteString(instance.writer, line+"\n")
+}
+
+// FormatAccessLog formats the record as a single line.  The text format keeps
+// the columns of the original verbose output and adds the fields after them.
+func FormatAccessLog(format string, record *RecordedRequest) string {
+	switch format {
+	case ACCESS_LOG_JSON:
+		data, _ := json.Marshal(accessLogEntry{
+			Time:     record.Time,
+			ID:       record.ID,
+			Remote:   record.Remote,
+			Method:   record.Method,
+			Path:     record.Path,
+			Query:    record.Query,
+			Endpoint: record.Endpoint,
+			Fault:    record.Fault,
+			Code:     record.Code,
+			Bytes:    record.Bytes,
+			Duration: record.Elapsed.String(),
+			Sleep:    record.Slept.String(),
+		})
+		return string(data)
+	case ACCESS_LOG_COMMON, ACCESS_LOG_COMBINED:
+		host, _, err := net.SplitHostPort(record.Remote)
+		if err != nil {
+			host = record.Remote
+		}
+		target := record.Path
+		if record.Query != "" {
+			target += "?" + record.Query
+		}
+		size := "-"
+		if record.Bytes > 0 {
+			size = fmt.Sprintf("%d", record.Bytes)
+		}
+		line := fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s`, clfValue(host), record.Time.Format(CLF_TIME_FORMAT),
+			record.Method, target, record.Proto, record.Code, size)
+		if format == ACCESS_LOG_COMBINED {
+			line += fmt.Sprintf(` "%s" "%s"`, clfValue(record.Headers.Get("Referer")), clfValue(record.Headers.Get("User-Agent")))
+		}
+		return line
+	default:
+		return fmt.Sprintf("%-15s%-4d%-5s%s fault=%s sleep=%s bytes=%d remote=%s id=%s",
+			record.Elapsed, record.Code, record.Method, record.Path, record.Fault, record.Slept, record.Bytes, record.Remote, record.ID)
+	}
+}
+
+func clfValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Replace(value, `"`, `\"`, -1)
+}
+
+// NewAccessLog creates the AccessLog described by args, or nil when it is
+// disabled.
+func NewAccessLog(args AccessLogArgs) (*AccessLog, error) {
+	if args.Path == "" {
+		return nil, nil
+	}
+	if err := validateAccessLog(args); err != nil {
+		return nil, err
+	}
+	format := args.Format
+	if format == "" {
+		format = ACCESS_LOG_TEXT
+	}
+	if args.Path == ACCESS_LOG_STDOUT {
+		return &AccessLog{Format: format, writer: os.Stdout}, nil
+	}
+	file, err := NewRotatingFile(args.Path, parseSize(args.MaxSize), args.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLog{Format: format, writer: file}, nil
+}
+
+func validateAccessLog(args AccessLogArgs) error {
+	found := args.Format == ""
+	for _, format := range ACCESS_LOG_FORMATS {
+		found = found || format == args.Format
+	}
+	if !found {
+		return fmt.Errorf("accesslog: format %q is not one of %v", args.Format, ACCESS_LOG_FORMATS)
+	}
+	if args.MaxSize != "" {
+		if _, err := humanize.ParseBytes(args.MaxSize); err != nil {
+			return fmt.Errorf("accesslog: cannot parse size from %q", args.MaxSize)
+		}
+	}
+	if args.MaxBackups < 0 {
+		return fmt.Errorf("accesslog: maxbackups cannot be negative")
+	}
+	return nil
+}
+
+// RotatingFile appends to a file, renaming it to path.1 and starting a new one
+// when a write would take it beyond MaxSize.  A MaxSize of 0 never rotates.
+type RotatingFile struct {
+	mutex      sync.Mutex
+	Path       string
+	MaxSize    uint64
+	MaxBackups int
+	file       *os.File
+	size       uint64
+}
+
+func (instance *RotatingFile) Write(data []byte) (int, error) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if instance.MaxSize > 0 && instance.size > 0 && instance.size+uint64(len(data)) > instance.MaxSize {
+		if err := instance.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	written, err := instance.file.Write(data)
+	instance.size += uint64(written)
+	return written, err
+}
+
+func (instance *RotatingFile) rotate() error {
+	instance.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", instance.Path, instance.MaxBackups))
+	for i := instance.MaxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", instance.Path, i), fmt.Sprintf("%s.%d", instance.Path, i+1))
+	}
+	if instance.MaxBackups > 0 {
+		os.Rename(instance.Path, instance.Path+".1")
+	} else {
+		os.Remove(instance.Path)
+	}
+	return instance.open()
+}
+
+func (instance *RotatingFile) open() error {
+	file, err := os.OpenFile(instance.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	instance.file = file
+	instance.size = uint64(info.Size())
+	return nil
+}
+
+func (instance *RotatingFile) Close() error {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.file.Close()
+}
+
+func NewRotatingFile(path string, maxSize uint64, maxBackups int) (*RotatingFile, error) {
+	file := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
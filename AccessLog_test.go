@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessLog", func() {
+
+	var record *RecordedRequest
+
+	BeforeEach(func() {
+		record = &RecordedRequest{
+			ID:       "abc",
+			Time:     time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+			Remote:   "10.0.0.1:1234",
+			Method:   "GET",
+			Path:     "/wait",
+			Query:    "a=b",
+			Proto:    "HTTP/1.1",
+			Headers:  http.Header{"User-Agent": []string{"curl"}},
+			Endpoint: "/wait",
+			Fault:    FAULT_WAIT,
+			Code:     200,
+			Bytes:    11,
+			Elapsed:  110 * time.Millisecond,
+			Slept:    100 * time.Millisecond,
+		}
+	})
+
+	It("formats the text line with the original columns first", func() {
+		Expect(FormatAccessLog(ACCESS_LOG_TEXT, record)).To(Equal(
+			"110ms          200 GET  /wait fault=wait sleep=100ms bytes=11 remote=10.0.0.1:1234 id=abc"))
+	})
+
+	It("formats a JSON line", func() {
+		entry := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(FormatAccessLog(ACCESS_LOG_JSON, record)), &entry)).To(BeNil())
+		Expect(entry["fault"]).To(Equal("wait"))
+		Expect(entry["sleep"]).To(Equal("100ms"))
+		Expect(entry["bytes"]).To(BeNumerically("==", 11))
+		Expect(entry["id"]).To(Equal("abc"))
+	})
+
+	It("formats the Common and Combined Log Formats", func() {
+		Expect(FormatAccessLog(ACCESS_LOG_COMMON, record)).To(Equal(
+			`10.0.0.1 - - [02/Jan/2016:03:04:05 +0000] "GET /wait?a=b HTTP/1.1" 200 11`))
+		Expect(FormatAccessLog(ACCESS_LOG_COMBINED, record)).To(Equal(
+			`10.0.0.1 - - [02/Jan/2016:03:04:05 +0000] "GET /wait?a=b HTTP/1.1" 200 11 "-" "curl"`))
+	})
+
+	It("rejects an unknown format", func() {
+		_, err := NewAccessLog(AccessLogArgs{Path: ACCESS_LOG_STDOUT, Format: "xml"})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("is told the sleep, bytes and request ID by the Router", func() {
+		buffer := &bytes.Buffer{}
+		accessLog := &AccessLog{Format: ACCESS_LOG_JSON, writer: buffer}
+		snoozer := NewFakeSnoozer()
+		snoozer.SleepFor(10 * time.Millisecond)
+		router := NewRouter(NewLiveProfile(Profile{Snoozer: snoozer}), accessLog)
+		router.HandleFunc("/wait", NewDefultHttpHandler(router.profile).Wait)
+		r := newRequest("GET", "/wait")
+		r.Header.Set(REQUEST_ID_HEADER, "abc")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, r)
+		Expect(response.Header().Get(REQUEST_ID_HEADER)).To(Equal("abc"))
+		entry := accessLogEntry{}
+		Expect(json.Unmarshal(buffer.Bytes(), &entry)).To(BeNil())
+		Expect(entry.ID).To(Equal("abc"))
+		Expect(entry.Fault).To(Equal(FAULT_WAIT))
+		Expect(parseTime(entry.Sleep) >= 10*time.Millisecond).To(BeTrue())
+		Expect(entry.Bytes).To(Equal(response.Body.Len()))
+	})
+
+	It("writes a line for a request which hangs once the client gives up", func() {
+		buffer := &bytes.Buffer{}
+		accessLog := &AccessLog{Format: ACCESS_LOG_JSON, writer: buffer}
+		router := NewRouter(NewLiveProfile(Profile{}), accessLog)
+		router.HandleFunc("/hang", NewDefultHttpHandler(router.profile).Hang)
+		server := httptest.NewServer(router)
+		defer server.Close()
+		client := &http.Client{Timeout: 50 * time.Millisecond}
+		_, err := client.Get(server.URL + "/hang")
+		Expect(err).ToNot(BeNil())
+		Eventually(func() string {
+			accessLog.mutex.Lock()
+			defer accessLog.mutex.Unlock()
+			return buffer.String()
+		}).Should(ContainSubstring(`"fault":"hang"`))
+	})
+
+	It("makes a startup error of an access log which cannot be opened", func() {
+		config := Configuration{accessLog: AccessLogArgs{Path: "/nonexistent/access.log"}}
+		serverFactory := ServerFactory{Config: config}
+		_, err := serverFactory.CreateServer()
+		Expect(err).ToNot(BeNil())
+	})
+
+	Describe("RotatingFile", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "enanos")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("rotates the file when it would grow beyond the maximum size", func() {
+			path := filepath.Join(dir, "access.log")
+			file, err := NewRotatingFile(path, 10, 1)
+			Expect(err).To(BeNil())
+			file.Write([]byte("12345678\n"))
+			file.Write([]byte("abcdefgh\n"))
+			file.Write([]byte("ABCDEFGH\n"))
+			file.Close()
+			current, _ := ioutil.ReadFile(path)
+			Expect(string(current)).To(Equal("ABCDEFGH\n"))
+			previous, _ := ioutil.ReadFile(path + ".1")
+			Expect(string(previous)).To(Equal("abcdefgh\n"))
+			_, err = os.Stat(path + ".2")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
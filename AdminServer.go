@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ADMIN_CONFIG_PATH     string = "/__admin/config"
+	ADMIN_REQUESTS_PATH   string = "/__admin/requests"
+	ADMIN_METRICS_PATH    string = "/metrics"
+	ADMIN_SCENARIOS_PATH  string = "/__admin/scenarios"
+	ADMIN_TLS_CA_PATH     string = "/__admin/tls/ca.pem"
+	ADMIN_TLS_CLIENT_PATH string = "/__admin/tls/client.pem"
+)
+
+// AdminServer hosts the admin API used to change the behaviour of the other
+// servers while they are running.
+type AdminServer struct {
+	Config    Configuration
+	Profile   *LiveProfile
+	Recorder  *RequestRecorder
+	Metrics   *Metrics
+	Authority *CertificateAuthority
+	Server    *HTTPServer
+}
+
+func (instance *AdminServer) Start() {
+	config := instance.Config
+	if config.adminPort == 0 {
+		return
+	}
+	instance.Server = NewHTTPServer(config.adminPort, config.host)
+	instance.Server.HandleFunc(ADMIN_CONFIG_PATH, instance.Configuration)
+	instance.Server.HandleFunc(ADMIN_REQUESTS_PATH, instance.Requests)
+	instance.Server.HandleFunc(ADMIN_METRICS_PATH, instance.Scrape)
+	instance.Server.HandleFunc(ADMIN_SCENARIOS_PATH, instance.Scenarios)
+	instance.Server.HandleFunc(ADMIN_SCENARIOS_PATH+"/", instance.Scenarios)
+	instance.Server.HandleFunc(ADMIN_TLS_CA_PATH, instance.CertificateAuthority)
+	instance.Server.HandleFunc(ADMIN_TLS_CLIENT_PATH, instance.ClientCertificate)
+	if err := instance.Server.Start(); err != nil {
+		fmt.Println(fmt.Sprintf("Cannot start the admin server: %v", err))
+	}
+}
+
+func (instance *AdminServer) Stop() {
+	if instance.Server != nil {
+		instance.Server.Stop()
+	}
+}
+
+// Configuration returns the live configuration for GET, replaces it for PUT
+// and updates only the supplied fields for PATCH.
+func (instance *AdminServer) Configuration(w http.ResponseWriter, r *http.Request) {
+	current := instance.Profile.Current().Config.Args()
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, current)
+	case "PUT":
+		args := CommandLineArgs{
+			Port:       current.Port,
+			Host:       current.Host,
+			Verbose:    current.Verbose,
+			JitterTime: current.JitterTime,
+			AdminPort:  current.AdminPort,
+			History:    current.History,
+			AccessLog:  current.AccessLog,
+			TLS:        current.TLS,
+			GRPC:       GRPCArgs{Port: current.GRPC.Port, Descriptors: current.GRPC.Descriptors},
+		}
+		instance.update(w, r, current, args)
+	case "PATCH":
+		instance.update(w, r, current, current)
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Requests returns the recorded requests which match the filter in the query
+// string for GET and forgets them all for DELETE.
+func (instance *AdminServer) Requests(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		filter, err := NewRequestFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot read the filter: %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, instance.Recorder.Requests(filter))
+	case "DELETE":
+		instance.Recorder.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Scrape returns the metrics in the Prometheus text format.
+func (instance *AdminServer) Scrape(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", METRICS_MIME_TYPE)
+	instance.Metrics.Write(w)
+}
+
+// Scenarios returns the state of every scenario for GET on the collection.  For
+// a single scenario, /__admin/scenarios/<name>, GET returns its state, PUT
+// with {"state":"<state>"} moves it to that state and DELETE moves it back to
+// its first state.
+func (instance *AdminServer) Scenarios(w http.ResponseWriter, r *http.Request) {
+	config := instance.Profile.Current().Config
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, ADMIN_SCENARIOS_PATH), "/")
+	if name == "" {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		statuses := []ScenarioStatus{}
+		for _, scenario := range config.scenarios {
+			statuses = append(statuses, scenario.Status())
+		}
+		writeJSON(w, http.StatusOK, statuses)
+		return
+	}
+	scenario := config.scenario(name)
+	if scenario == nil {
+		http.Error(w, fmt.Sprintf("scenario %q is not configured", name), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		status := ScenarioStatus{}
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil || status.State == "" {
+			http.Error(w, "cannot read the state, expected {\"state\":\"<state>\"}", http.StatusBadRequest)
+			return
+		}
+		if err := scenario.MoveTo(status.State); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "DELETE":
+		scenario.MoveTo("")
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, scenario.Status())
+}
+
+// CertificateAuthority returns the certificate of the CA generated for the
+// HTTPS listener, for clients to trust.
+func (instance *AdminServer) CertificateAuthority(w http.ResponseWriter, r *http.Request) {
+	if instance.Authority == nil {
+		http.Error(w, "the HTTPS listener is not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", PEM_MIME_TYPE)
+	w.Write(instance.Authority.PEM())
+}
+
+// ClientCertificate issues a new client certificate and key, signed by the
+// generated CA, for testing mutual TLS.
+func (instance *AdminServer) ClientCertificate(w http.ResponseWriter, r *http.Request) {
+	if instance.Authority == nil {
+		http.Error(w, "the HTTPS listener is not enabled", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	certificate, err := instance.Authority.Issue([]string{"enanos-client"}, now.Add(-time.Hour), now.AddDate(0, 0, 7), x509.ExtKeyUsageClientAuth)
+	if err == nil {
+		var data []byte
+		if data, err = encodeCertificate(certificate); err == nil {
+			w.Header().Set("Content-Type", PEM_MIME_TYPE)
+			w.Write(data)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("cannot issue a client certificate: %v", err), http.StatusInternalServerError)
+}
+
+func (instance *AdminServer) update(w http.ResponseWriter, r *http.Request, current CommandLineArgs, args CommandLineArgs) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot read the configuration: %v", err), http.StatusBadRequest)
+		return
+	}
+	//JSON arrays are decoded into the elements already in a slice, leaving the
+	//fields they do not supply, so the supplied lists start from empty
+	supplied := map[string]json.RawMessage{}
+	json.Unmarshal(body, &supplied)
+	if _, ok := supplied["headers"]; ok {
+		args.Headers = nil
+	}
+	if _, ok := supplied["routes"]; ok {
+		args.Routes = nil
+	}
+	if _, ok := supplied["chaos"]; ok {
+		args.Chaos = nil
+	}
+	if _, ok := supplied["scenarios"]; ok {
+		args.Scenarios = nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&args); err != nil {
+		http.Error(w, fmt.Sprintf("cannot read the configuration: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := checkListenerArgs(current, args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reader := NewArgsConfigurationReader(&args)
+	if err := reader.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	config := reader.Read()
+	config.keepRoutes(instance.Profile.Current().Config)
+	config.keepScenarios(instance.Profile.Current().Config)
+	config.keepFixtures(instance.Profile.Current().Config)
+	instance.Profile.Swap(NewProfile(config))
+	writeJSON(w, http.StatusOK, config.Args())
+}
+
+// checkListenerArgs rejects changes to the settings which are only read when
+// the servers start.
+func checkListenerArgs(current CommandLineArgs, args CommandLineArgs) error {
+	if current.Port != args.Port ||
+		current.Host != args.Host ||
+		current.Verbose != args.Verbose ||
+		parseTime(current.JitterTime) != parseTime(args.JitterTime) ||
+		current.AdminPort != args.AdminPort ||
+		current.History != args.History ||
+		current.AccessLog != args.AccessLog ||
+		current.TLS.Port != args.TLS.Port ||
+		current.TLS.Cert != args.TLS.Cert ||
+		current.TLS.Key != args.TLS.Key ||
+		current.TLS.ClientCA != args.TLS.ClientCA ||
+		current.GRPC.Port != args.GRPC.Port ||
+		strings.Join(current.GRPC.Descriptors, ",") != strings.Join(args.GRPC.Descriptors, ",") {
+		return fmt.Errorf("port, host, verbose, jittertime, adminport, history, accesslog, the tls port, cert, key and clientca and the grpc port and descriptors cannot be changed at runtime")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
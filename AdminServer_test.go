@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AdminServer", func() {
+
+	var profile *LiveProfile
+	var adminServer *AdminServer
+
+	send := func(method string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, ADMIN_CONFIG_PATH, bytes.NewBufferString(body))
+		adminServer.Configuration(recorder, request)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		args := CommandLineArgs{
+			Port:    8080,
+			Host:    "localhost",
+			Content: "boom",
+			MinWait: "1s",
+			MaxWait: "2s",
+			MinSize: "1KB",
+			MaxSize: "2KB",
+		}
+		config := NewArgsConfigurationReader(&args).Read()
+		profile = NewLiveProfile(NewProfile(config))
+		adminServer = &AdminServer{Config: config, Profile: profile}
+	})
+
+	It("GET returns the current configuration", func() {
+		recorder := send("GET", "")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		args := CommandLineArgs{}
+		json.Unmarshal(recorder.Body.Bytes(), &args)
+		Expect(args.Content).To(Equal("boom"))
+		Expect(args.MaxWait).To(Equal("2s"))
+	})
+
+	It("PATCH only changes the supplied fields", func() {
+		recorder := send("PATCH", `{"maxwait":"5s"}`)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(profile.Current().Config.maxWait).To(Equal(5 * time.Second))
+		Expect(profile.Current().Config.content).To(Equal("boom"))
+	})
+
+	It("PUT replaces the configuration", func() {
+		recorder := send("PUT", `{"content":"bang","maxsize":"5B"}`)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(profile.Current().Config.content).To(Equal("bang"))
+		Expect(profile.Current().Config.maxWait).To(Equal(time.Duration(0)))
+		Expect(profile.Current().ResponseBodyGenerator.Generate()).To(HaveLen(5))
+	})
+
+	It("rejects invalid values without changing the configuration", func() {
+		recorder := send("PATCH", `{"maxwait":"soon"}`)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(profile.Current().Config.maxWait).To(Equal(2 * time.Second))
+	})
+
+	It("PATCH replaces the supplied lists rather than merging into them", func() {
+		Expect(send("PATCH", `{"routes":[{"path":"/health","behaviours":[{"status":204}]}]}`).Code).To(Equal(http.StatusOK))
+		recorder := send("PATCH", `{"routes":[{"path":"/orders","behaviours":[{"fault":"success"}]},{"path":"/health","behaviours":[{"status":204}]}]}`)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		routes := profile.Current().Config.Args().Routes
+		Expect(routes).To(HaveLen(2))
+		Expect(routes[0].Behaviours).To(Equal([]BehaviourArgs{{Fault: FAULT_SUCCESS}}))
+	})
+
+	It("keeps the attempts counted by unchanged routes", func() {
+		Expect(send("PATCH", `{"routes":[{"path":"/orders","behaviours":[{"retry":{"failures":1}}]}]}`).Code).To(Equal(http.StatusOK))
+		router := NewRouter(profile)
+		serve := func() int {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, newRequest("GET", "/orders"))
+			return recorder.Code
+		}
+		Expect(serve()).To(BeNumerically(">=", 500))
+		Expect(send("PATCH", `{"maxwait":"5s"}`).Code).To(Equal(http.StatusOK))
+		Expect(serve()).To(Equal(http.StatusOK))
+	})
+
+	It("keeps the rate limits of a route whose other behaviours change", func() {
+		Expect(send("PATCH", `{"routes":[{"path":"/orders","behaviours":[{"ratelimit":{"limit":1,"window":"1m"}}]}]}`).Code).To(Equal(http.StatusOK))
+		router := NewRouter(profile)
+		serve := func() int {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, newRequest("GET", "/orders"))
+			return recorder.Code
+		}
+		Expect(serve()).To(Equal(http.StatusOK))
+		Expect(send("PATCH", `{"maxwait":"5s"}`).Code).To(Equal(http.StatusOK))
+		Expect(serve()).To(Equal(http.StatusTooManyRequests))
+		Expect(send("PATCH", `{"routes":[{"path":"/orders","behaviours":[{"ratelimit":{"limit":1,"window":"1m"}},{"status":201}]}]}`).Code).To(Equal(http.StatusOK))
+		Expect(serve()).To(Equal(http.StatusTooManyRequests))
+		Expect(send("PATCH", `{"routes":[{"path":"/orders","behaviours":[{"ratelimit":{"limit":2,"window":"1m"}}]}]}`).Code).To(Equal(http.StatusOK))
+		Expect(serve()).To(Equal(http.StatusOK))
+	})
+
+	It("rejects changes to the listener settings", func() {
+		recorder := send("PATCH", `{"port":9000}`)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects unsupported methods", func() {
+		recorder := send("DELETE", "")
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf16"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	BODY_FORMAT_TEXT     string = "text"
+	BODY_FORMAT_JSON     string = "json"
+	BODY_FORMAT_XML      string = "xml"
+	BODY_FORMAT_HTML     string = "html"
+	BODY_FORMAT_CSV      string = "csv"
+	BODY_FORMAT_PROTOBUF string = "protobuf"
+
+	CORRUPT_NONE                string = "none"
+	CORRUPT_TRUNCATED           string = "truncated"
+	CORRUPT_WRONG_ENCODING      string = "wrong_encoding"
+	CORRUPT_MISMATCHED_BRACKETS string = "mismatched_brackets"
+	CORRUPT_BOM                 string = "bom"
+	CORRUPT_INVALID_UTF8        string = "invalid_utf8"
+)
+
+var (
+	BODY_FORMATS       []string          = []string{BODY_FORMAT_TEXT, BODY_FORMAT_JSON, BODY_FORMAT_XML, BODY_FORMAT_HTML, BODY_FORMAT_CSV, BODY_FORMAT_PROTOBUF}
+	CORRUPTIONS        []string          = []string{CORRUPT_NONE, CORRUPT_TRUNCATED, CORRUPT_WRONG_ENCODING, CORRUPT_MISMATCHED_BRACKETS, CORRUPT_BOM, CORRUPT_INVALID_UTF8}
+	BODY_CONTENT_TYPES map[string]string = map[string]string{
+		BODY_FORMAT_TEXT:     "text/plain; charset=utf-8",
+		BODY_FORMAT_JSON:     "application/json",
+		BODY_FORMAT_XML:      "application/xml",
+		BODY_FORMAT_HTML:     "text/html; charset=utf-8",
+		BODY_FORMAT_CSV:      "text/csv",
+		BODY_FORMAT_PROTOBUF: "application/x-protobuf",
+	}
+	UTF8_BOM []byte = []byte{0xEF, 0xBB, 0xBF}
+)
+
+// BodyArgs is the configuration file form of the body of the content_size
+// endpoint.  Without a format it follows the Content-Type of the response.
+type BodyArgs struct {
+	Format  string `json:"format,omitempty"`
+	Corrupt string `json:"corrupt,omitempty"`
+}
+
+func validateBody(args BodyArgs) error {
+	if args.Format != "" && !ContainsString(BODY_FORMATS, args.Format) {
+		return fmt.Errorf("body: format %q is not one of %v", args.Format, BODY_FORMATS)
+	}
+	if args.Corrupt != "" && !ContainsString(CORRUPTIONS, args.Corrupt) {
+		return fmt.Errorf("body: corrupt %q is not one of %v", args.Corrupt, CORRUPTIONS)
+	}
+	return nil
+}
+
+// FormatResponseBodyGenerator is implemented by the generators which can
+// generate their body in one of the BODY_FORMATS.
+type FormatResponseBodyGenerator interface {
+	GenerateFormat(format string) string
+}
+
+// generateBody generates a body in the format when the generator can and a
+// plain body otherwise.
+func generateBody(generator ResponseBodyGenerator, format string) string {
+	if formatter, ok := generator.(FormatResponseBodyGenerator); ok {
+		return formatter.GenerateFormat(format)
+	}
+	return generator.Generate()
+}
+
+// generateContent generates the body of the content_size endpoint and size
+// behaviour in the format of the args, or else of the Content-Type, and then
+// corrupts it as the args say.
+func generateContent(generator ResponseBodyGenerator, args BodyArgs, contentType string) []byte {
+	format := args.Format
+	if format == "" {
+		format = contentTypeFormat(contentType)
+	}
+	return CorruptBody([]byte(generateBody(generator, format)), args.Corrupt)
+}
+
+// contentTypeFormat returns the format of the media type of a Content-Type.
+func contentTypeFormat(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return BODY_FORMAT_TEXT
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return BODY_FORMAT_JSON
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return BODY_FORMAT_HTML
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return BODY_FORMAT_XML
+	case mediaType == "text/csv":
+		return BODY_FORMAT_CSV
+	case strings.Contains(mediaType, "protobuf"):
+		return BODY_FORMAT_PROTOBUF
+	}
+	return BODY_FORMAT_TEXT
+}
+
+// GenerateBody generates a body of the format which is exactly size bytes.
+// Bodies too small to be valid in the format are plain text.
+func GenerateBody(format string, size int) []byte {
+	var body []byte
+	switch format {
+	case BODY_FORMAT_JSON:
+		body = fillRecords(size, "[", ",", "]", " ", func(i int) string {
+			return fmt.Sprintf(`{"id":%d,"name":"enanos %d"}`, i, i)
+		})
+	case BODY_FORMAT_XML:
+		body = fillRecords(size, `<?xml version="1.0" encoding="UTF-8"?><items>`, "", "</items>", " ", func(i int) string {
+			return fmt.Sprintf(`<item id="%d">enanos %d</item>`, i, i)
+		})
+	case BODY_FORMAT_HTML:
+		body = fillRecords(size, "<!DOCTYPE html><html><head><title>enanos</title></head><body>", "", "</body></html>", " ", func(i int) string {
+			return fmt.Sprintf("<p>enanos %d</p>", i)
+		})
+	case BODY_FORMAT_CSV:
+		body = fillRecords(size, "id,name\n", "", "", "-", func(i int) string {
+			return fmt.Sprintf("%d,enanos %d\n", i, i)
+		})
+		if body != nil {
+			//Pad the last field rather than adding a short row
+			rows := bytes.TrimRight(body, "-")
+			padding := body[len(rows):]
+			body = append(append(append([]byte{}, rows[:len(rows)-1]...), padding...), '\n')
+		}
+	case BODY_FORMAT_PROTOBUF:
+		body = generateProtobuf(size)
+	}
+	if body == nil {
+		body = bytes.Repeat([]byte("-"), size)
+	}
+	return body
+}
+
+// fillRecords adds records between the start and end for as long as they fit
+// in the size and then pads up to it.
+func fillRecords(size int, start string, separator string, end string, padding string, record func(int) string) []byte {
+	if len(start)+len(end) > size {
+		return nil
+	}
+	var body bytes.Buffer
+	body.WriteString(start)
+	for i := 1; ; i++ {
+		next := record(i)
+		if i > 1 {
+			next = separator + next
+		}
+		if body.Len()+len(next)+len(end) > size {
+			break
+		}
+		body.WriteString(next)
+	}
+	body.WriteString(strings.Repeat(padding, size-body.Len()-len(end)))
+	body.WriteString(end)
+	return body.Bytes()
+}
+
+// generateProtobuf fills a bytes field, adding a varint field when no length
+// of the bytes field makes the message exactly the size.
+func generateProtobuf(size int) []byte {
+	for _, prefix := range [][]byte{{}, protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 0)} {
+		target := size - len(prefix)
+		for length := target - 2; length >= 0 && length >= target-11; length-- {
+			if 1+protowire.SizeVarint(uint64(length))+length == target {
+				data := bytes.Repeat([]byte("enanos "), length/7+1)[:length]
+				return protowire.AppendBytes(protowire.AppendTag(prefix, 1, protowire.BytesType), data)
+			}
+		}
+	}
+	return nil
+}
+
+// CorruptBody corrupts a body in one of the CORRUPTIONS.
+func CorruptBody(body []byte, corruption string) []byte {
+	switch corruption {
+	case CORRUPT_TRUNCATED:
+		return body[:len(body)/2]
+	case CORRUPT_WRONG_ENCODING:
+		var encoded bytes.Buffer
+		for _, unit := range utf16.Encode([]rune(string(body))) {
+			encoded.Write([]byte{byte(unit), byte(unit >> 8)})
+		}
+		return encoded.Bytes()
+	case CORRUPT_MISMATCHED_BRACKETS:
+		corrupted := append([]byte{}, body...)
+		index := bytes.LastIndexAny(corrupted, "}])>")
+		if index < 0 {
+			return append(corrupted, '}')
+		}
+		corrupted[index] = map[byte]byte{'}': ']', ']': '}', ')': ']', '>': ')'}[corrupted[index]]
+		return corrupted
+	case CORRUPT_BOM:
+		return append(append([]byte{}, UTF8_BOM...), body...)
+	case CORRUPT_INVALID_UTF8:
+		corrupted := append([]byte{}, body...)
+		if len(corrupted) == 0 {
+			return []byte{0xFF}
+		}
+		corrupted[len(corrupted)/2] = 0xFF
+		return corrupted
+	}
+	return body
+}
+
+// CorruptBehaviour corrupts the body of the exchange.
+type CorruptBehaviour struct {
+	Corruption string
+}
+
+func (instance *CorruptBehaviour) Apply(exchange *Exchange) {
+	exchange.Body = CorruptBody(exchange.Body, instance.Corruption)
+}
+
+func NewCorruptBehaviour(corruption string) (*CorruptBehaviour, error) {
+	if !ContainsString(CORRUPTIONS, corruption) {
+		return nil, fmt.Errorf("corrupt: %q is not one of %v", corruption, CORRUPTIONS)
+	}
+	return &CorruptBehaviour{corruption}, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var _ = Describe("Body", func() {
+
+	validXML := func(body []byte) bool {
+		decoder := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			if _, err := decoder.Token(); err != nil {
+				return err == io.EOF
+			}
+		}
+	}
+
+	validCSV := func(body []byte) bool {
+		_, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		return err == nil
+	}
+
+	validProtobuf := func(body []byte) bool {
+		for len(body) > 0 {
+			number, kind, length := protowire.ConsumeTag(body)
+			if length < 0 {
+				return false
+			}
+			body = body[length:]
+			length = protowire.ConsumeFieldValue(number, kind, body)
+			if length < 0 {
+				return false
+			}
+			body = body[length:]
+		}
+		return true
+	}
+
+	for _, size := range []int{150, 1000, 4099, 70000} {
+		size := size
+		It(fmt.Sprintf("generates valid bodies of %d bytes", size), func() {
+			for format, valid := range map[string]func([]byte) bool{
+				BODY_FORMAT_JSON:     json.Valid,
+				BODY_FORMAT_XML:      validXML,
+				BODY_FORMAT_HTML:     validXML,
+				BODY_FORMAT_CSV:      validCSV,
+				BODY_FORMAT_PROTOBUF: validProtobuf,
+			} {
+				body := GenerateBody(format, size)
+				Expect(len(body)).To(Equal(size), format)
+				Expect(valid(body)).To(BeTrue(), format)
+			}
+		})
+	}
+
+	It("generates protobuf of every size", func() {
+		for size := 2; size < 300; size++ {
+			body := GenerateBody(BODY_FORMAT_PROTOBUF, size)
+			Expect(len(body)).To(Equal(size))
+			Expect(validProtobuf(body)).To(BeTrue())
+		}
+	})
+
+	It("picks the format from the Content-Type", func() {
+		Expect(contentTypeFormat("application/json; charset=utf-8")).To(Equal(BODY_FORMAT_JSON))
+		Expect(contentTypeFormat("application/problem+json")).To(Equal(BODY_FORMAT_JSON))
+		Expect(contentTypeFormat("text/xml")).To(Equal(BODY_FORMAT_XML))
+		Expect(contentTypeFormat("text/html")).To(Equal(BODY_FORMAT_HTML))
+		Expect(contentTypeFormat("application/x-protobuf")).To(Equal(BODY_FORMAT_PROTOBUF))
+		Expect(contentTypeFormat("")).To(Equal(BODY_FORMAT_TEXT))
+	})
+
+	It("corrupts bodies", func() {
+		body := GenerateBody(BODY_FORMAT_JSON, 100)
+		Expect(CorruptBody(body, CORRUPT_TRUNCATED)).To(Equal(body[:50]))
+		Expect(json.Valid(CorruptBody(body, CORRUPT_MISMATCHED_BRACKETS))).To(BeFalse())
+		Expect(CorruptBody(body, CORRUPT_BOM)).To(Equal(append(UTF8_BOM, body...)))
+		Expect(utf8.Valid(CorruptBody(body, CORRUPT_INVALID_UTF8))).To(BeFalse())
+		Expect(len(CorruptBody(body, CORRUPT_WRONG_ENCODING))).To(Equal(200))
+		Expect(CorruptBody(body, CORRUPT_NONE)).To(Equal(body))
+		Expect(body).To(Equal(GenerateBody(BODY_FORMAT_JSON, 100)))
+	})
+
+	It("generates the content_size body in the format", func() {
+		generator := NewMaxResponseBodyGenerator(200)
+		Expect(json.Valid([]byte(generateBody(generator, BODY_FORMAT_JSON)))).To(BeTrue())
+		Expect(generateBody(NewFakeResponseBodyGenerator(), BODY_FORMAT_JSON)).To(Equal(""))
+	})
+
+	It("generates the size behaviour body in the configured format and corruption", func() {
+		behaviour, _ := NewBehaviour(BehaviourArgs{Size: "200B"})
+		config := Configuration{body: BodyArgs{Format: BODY_FORMAT_JSON}}
+		exchange := NewExchange(httptest.NewRecorder(), newRequest("GET", "/"), nil, Profile{Config: config})
+		behaviour.Apply(exchange)
+		Expect(exchange.Body).To(HaveLen(200))
+		Expect(json.Valid(exchange.Body)).To(BeTrue())
+		config.body.Corrupt = CORRUPT_BOM
+		exchange = NewExchange(httptest.NewRecorder(), newRequest("GET", "/"), nil, Profile{Config: config})
+		behaviour.Apply(exchange)
+		Expect(exchange.Body[:3]).To(Equal(UTF8_BOM))
+	})
+
+	It("generates the content_size fault and slow_body bodies in the format of the Content-Type", func() {
+		profile := Profile{ResponseBodyGenerator: NewMaxResponseBodyGenerator(64)}
+		behaviour, _ := NewBehaviour(BehaviourArgs{Fault: FAULT_CONTENT_SIZE})
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("Content-Type", "application/json")
+		exchange := NewExchange(recorder, newRequest("GET", "/"), nil, profile)
+		behaviour.Apply(exchange)
+		Expect(exchange.Body).To(HaveLen(64))
+		Expect(json.Valid(exchange.Body)).To(BeTrue())
+		profile.Config.headers = []string{"Content-Type:application/json"}
+		profile.Config.throttle, _ = NewThrottle(ThrottleArgs{Rate: "1MB"}, NewRealRandom())
+		recorder = httptest.NewRecorder()
+		NewDefultHttpHandler(NewLiveProfile(profile)).Slow_Body(recorder, newRequest("GET", "/slow_body"))
+		Expect(recorder.Body.Len()).To(Equal(64))
+		Expect(json.Valid(recorder.Body.Bytes())).To(BeTrue())
+	})
+
+	It("rejects an unknown format or corruption", func() {
+		Expect(validateBody(BodyArgs{Format: "yaml"})).ToNot(BeNil())
+		_, err := NewCorruptBehaviour("broken")
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+
+	"github.com/guzzlerio/enanos/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// jsonFields lists the JSON fields of the type, nested ones by a dot separated
+// path, along with the kind of value each holds.
+func jsonFields(value reflect.Type, prefix string, fields map[string]reflect.Kind) map[string]reflect.Kind {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Slice || value.Kind() == reflect.Map {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct || value.PkgPath() == "time" {
+		fields[prefix] = value.Kind()
+		return fields
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch {
+		case name == "-" || field.PkgPath != "":
+		case field.Anonymous && name == "":
+			jsonFields(field.Type, prefix, fields)
+		default:
+			jsonFields(field.Type, prefix+"."+name, fields)
+		}
+	}
+	return fields
+}
+
+var _ = Describe("Client", func() {
+
+	var server *httptest.Server
+	var admin *httptest.Server
+	var enanos *client.Client
+
+	get := func(method string, path string) int {
+		request, _ := http.NewRequest(method, server.URL+path, nil)
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	BeforeEach(func() {
+		config := NewArgsConfigurationReader(&CommandLineArgs{Host: "localhost", MaxWait: "2s", Routes: []RouteArgs{
+			{Path: "/health", Behaviours: []BehaviourArgs{{Status: 204}}},
+		}}).Read()
+		profile := NewLiveProfile(NewProfile(config))
+		recorder := NewRequestRecorder(100)
+		adminServer := &AdminServer{Config: config, Profile: profile, Recorder: recorder, Metrics: NewMetrics()}
+		mux := http.NewServeMux()
+		mux.HandleFunc(ADMIN_CONFIG_PATH, adminServer.Configuration)
+		mux.HandleFunc(ADMIN_REQUESTS_PATH, adminServer.Requests)
+		mux.HandleFunc(ADMIN_SCENARIOS_PATH, adminServer.Scenarios)
+		mux.HandleFunc(ADMIN_SCENARIOS_PATH+"/", adminServer.Scenarios)
+		admin = httptest.NewServer(mux)
+		router := NewRouter(profile, recorder)
+		for key, value := range endpoints(NewDefultHttpHandler(profile)) {
+			router.HandleFunc(key, value)
+		}
+		server = httptest.NewServer(router)
+		enanos = client.New(admin.URL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		admin.Close()
+	})
+
+	It("installs routes which fail a number of times through the admin API and resets them", func() {
+		Expect(enanos.Route("/orders").Methods("POST").FailWith(503).Times(3).Install()).To(BeNil())
+		Expect(enanos.Route("/health").WhenHeader("X-Tenant", "acme").Status(418).Install()).To(BeNil())
+		codes := []int{}
+		for i := 0; i < 4; i++ {
+			codes = append(codes, get("POST", "/orders"))
+		}
+		Expect(codes).To(Equal([]int{503, 503, 503, 200}))
+		Expect(get("GET", "/health")).To(Equal(204))
+		status, err := enanos.Scenario("client:POST /orders")
+		Expect(err).To(BeNil())
+		Expect(status.State).To(Equal(client.STATE_PASSED))
+
+		Expect(enanos.Chaos(client.Outcome{Weight: 1, Behaviour: client.Behaviour{Status: 418}})).To(BeNil())
+		Expect(enanos.Patch(map[string]interface{}{"maxwait": "5s"})).To(BeNil())
+		Expect(get("GET", "/chaos")).To(Equal(418))
+		Expect(enanos.Reset()).To(BeNil())
+		config, err := enanos.Config()
+		Expect(err).To(BeNil())
+		Expect(config["maxwait"]).To(Equal("2s"))
+		Expect(config).ToNot(HaveKey("chaos"))
+		Expect(config["scenarios"]).To(BeNil())
+		Expect(config["routes"]).To(HaveLen(1))
+		Expect(get("POST", "/orders")).To(Equal(http.StatusNotFound))
+		requests, err := enanos.Requests(client.RequestFilter{})
+		Expect(err).To(BeNil())
+		Expect(requests).To(HaveLen(1))
+	})
+
+	It("returns the errors of the admin API", func() {
+		err := enanos.Route("/orders").Fault("nothing").Install()
+		Expect(err).To(BeAssignableToTypeOf(&client.Error{}))
+		Expect(err.(*client.Error).Code).To(Equal(http.StatusBadRequest))
+		Expect(enanos.MoveScenario("missing", "failing")).ToNot(BeNil())
+	})
+
+	It("mirrors every field of the configuration and the admin API", func() {
+		for _, types := range [][2]interface{}{
+			{client.Route{}, RouteArgs{}},
+			{client.Scenario{}, ScenarioArgs{}},
+			{client.Outcome{}, OutcomeArgs{}},
+			{client.Request{}, RecordedRequest{}},
+			{client.ScenarioStatus{}, ScenarioStatus{}},
+		} {
+			mirror := reflect.TypeOf(types[0])
+			expected := jsonFields(reflect.TypeOf(types[1]), "", map[string]reflect.Kind{})
+			Expect(jsonFields(mirror, "", map[string]reflect.Kind{})).To(Equal(expected), mirror.String())
+		}
+	})
+})
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type RealClock struct{}
+
+func (instance *RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func NewRealClock() *RealClock {
+	return &RealClock{}
+}
+
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (instance *FakeClock) Now() time.Time {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.now
+}
+
+func (instance *FakeClock) Advance(duration time.Duration) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.now = instance.now.Add(duration)
+}
+
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
@@ -5,24 +5,54 @@ import (
 	"github.com/dustin/go-humanize"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 	"time"
 )
 
+// DEFAULT_MIN_WAIT is the minwait of the uniform and max sleeps when none is
+// set.
+const DEFAULT_MIN_WAIT time.Duration = time.Second
+
 type CommandLineArgs struct {
-	Port       int
-	Host       string
-	Verbose    bool
-	Content    string
-	DeadTime   string
-	MinWait    string
-	MaxWait    string
-	RandomWait bool
-	MinSize    string
-	MaxSize    string
-	RandomSize bool
-	Config     string
-	Headers    []string
-    JitterTime string
+	Port       int           `json:"port"`
+	Host       string        `json:"host"`
+	Verbose    bool          `json:"verbose"`
+	Content    string        `json:"content"`
+	DeadTime   string        `json:"deadtime"`
+	MinWait    string        `json:"minwait"`
+	MaxWait    string        `json:"maxwait"`
+	RandomWait bool          `json:"randomwait"`
+	MinSize    string        `json:"minsize"`
+	MaxSize    string        `json:"maxsize"`
+	RandomSize bool          `json:"randomsize"`
+	Config     string        `json:"-"`
+	Headers    []string      `json:"headers"`
+	Templates  bool          `json:"templates"`
+	JitterTime string        `json:"jittertime"`
+	AdminPort  int           `json:"adminport"`
+	Routes     []RouteArgs   `json:"routes"`
+	Chaos      []OutcomeArgs `json:"chaos,omitempty"`
+	Upstream   string        `json:"upstream"`
+	Proxy      ProxyArgs     `json:"proxy"`
+	Throttle   ThrottleArgs  `json:"throttle"`
+	Latency    LatencyArgs   `json:"latency"`
+	Overrides  OverrideArgs  `json:"overrides"`
+	History    int           `json:"history"`
+	AccessLog  AccessLogArgs `json:"accesslog"`
+	Retry      RetryArgs     `json:"retry"`
+	TLS        TLSArgs       `json:"tls"`
+	HTTP2      HTTP2Args     `json:"http2"`
+	GRPC       GRPCArgs      `json:"grpc"`
+	WebSocket  WebSocketArgs `json:"websocket"`
+	Stream     StreamArgs    `json:"stream"`
+	Body       BodyArgs      `json:"body"`
+	Fixtures   FixtureArgs   `json:"fixtures"`
+	OpenAPI    OpenAPIArgs   `json:"openapi"`
+
+	Scenarios []ScenarioArgs `json:"scenarios,omitempty"`
 }
 
 type ConfigurationReader interface {
@@ -51,18 +81,235 @@ func (instance *ArgsConfigurationReader) Read() Configuration {
 	config.verbose = instance.args.Verbose
 	config.content = instance.args.Content
 	config.headers = instance.args.Headers
+	config.templates = instance.args.Templates
 	config.deadTime = parseTime(instance.args.DeadTime)
-    config.jitterTime = parseTime(instance.args.JitterTime)
-	config.minWait = parseTime(instance.args.MinWait)
+	config.jitterTime = parseTime(instance.args.JitterTime)
+	config.minWait = minWait(*instance.args)
+	config.minWaitSet = instance.args.MinWait != ""
 	config.maxWait = parseTime(instance.args.MaxWait)
 	config.randomWait = instance.args.RandomWait
 	config.minSize = parseSize(instance.args.MinSize)
 	config.maxSize = parseSize(instance.args.MaxSize)
 	config.randomSize = instance.args.RandomSize
+	config.adminPort = instance.args.AdminPort
+	config.latency = instance.args.Latency
+	config.overrides = instance.args.Overrides
+	config.history = instance.args.History
+	config.accessLog = instance.args.AccessLog
+	config.retry = instance.args.Retry
+	config.tls = instance.args.TLS
+	config.http2 = instance.args.HTTP2
+	config.grpc = instance.args.GRPC
+	config.websocket = instance.args.WebSocket
+	config.stream = instance.args.Stream
+	config.body = instance.args.Body
+	if instance.args.Fixtures.Mode != "" {
+		//Unreadable fixtures are reported by Validate
+		config.fixtures, _ = NewFixtures(instance.args.Fixtures)
+	}
+	if instance.args.OpenAPI.Spec != "" {
+		//An invalid spec is reported by Validate
+		config.openapi, _ = NewOpenAPI(instance.args.OpenAPI)
+	}
+	for _, routeArgs := range instance.args.Routes {
+		route, err := NewRoute(routeArgs)
+		if err != nil {
+			//Invalid routes are reported by Validate
+			continue
+		}
+		config.routes = append(config.routes, route)
+	}
+	sortRoutes(config.routes)
+	config.orderRoutes()
+	for _, scenarioArgs := range instance.args.Scenarios {
+		scenario, err := NewScenario(scenarioArgs, NewRealClock())
+		if err != nil {
+			//Invalid scenarios are reported by Validate
+			continue
+		}
+		config.scenarios = append(config.scenarios, scenario)
+	}
+	if instance.args.Upstream != "" {
+		//An invalid upstream is reported by Validate
+		config.upstream, _ = parseUpstream(instance.args.Upstream)
+	}
+	config.proxy = instance.args.Proxy
+	//An invalid throttle is reported by Validate
+	config.throttle, _ = NewThrottle(instance.args.Throttle, NewRealRandom())
+	if instance.args.Chaos != nil {
+		//An invalid mix is reported by Validate
+		config.chaos, _ = NewMixBehaviour(instance.args.Chaos, NewRealRandom())
+	}
 	return config
 }
 
+// Validate checks that the values which Read silently ignores when they cannot
+// be parsed are well formed and consistent with each other.
+func (instance *ArgsConfigurationReader) Validate() error {
+	args := instance.args
+	durations := map[string]string{
+		"deadtime":           args.DeadTime,
+		"jittertime":         args.JitterTime,
+		"minwait":            args.MinWait,
+		"maxwait":            args.MaxWait,
+		"overrides.maxdelay": args.Overrides.MaxDelay,
+	}
+	for name, value := range durations {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%s: cannot parse time from %q", name, value)
+		}
+	}
+	sizes := map[string]string{
+		"minsize":           args.MinSize,
+		"maxsize":           args.MaxSize,
+		"overrides.maxsize": args.Overrides.MaxSize,
+	}
+	for name, value := range sizes {
+		if value == "" {
+			continue
+		}
+		if _, err := humanize.ParseBytes(value); err != nil {
+			return fmt.Errorf("%s: cannot parse size from %q", name, value)
+		}
+	}
+	for _, header := range args.Headers {
+		if !strings.Contains(header, ":") {
+			return fmt.Errorf("headers: %q is not in the format Key:Value", header)
+		}
+		if !args.Templates {
+			continue
+		}
+		if err := validateTemplates(header); err != nil {
+			return fmt.Errorf("headers: %v", err)
+		}
+	}
+	if args.Templates {
+		if err := validateTemplates(args.Content); err != nil {
+			return fmt.Errorf("content: %v", err)
+		}
+	}
+	for _, routeArgs := range args.Routes {
+		if _, err := NewRoute(routeArgs); err != nil {
+			return fmt.Errorf("routes: %v", err)
+		}
+	}
+	scenarios := map[string]bool{}
+	for _, scenarioArgs := range args.Scenarios {
+		if _, err := NewScenario(scenarioArgs, NewRealClock()); err != nil {
+			return fmt.Errorf("scenarios: %v", err)
+		}
+		if scenarios[scenarioArgs.Name] {
+			return fmt.Errorf("scenarios: %q is defined more than once", scenarioArgs.Name)
+		}
+		scenarios[scenarioArgs.Name] = true
+	}
+	for _, name := range usedScenarios(args) {
+		if !scenarios[name] {
+			return fmt.Errorf("scenarios: %q is used but not defined", name)
+		}
+	}
+	if args.Upstream != "" {
+		if _, err := parseUpstream(args.Upstream); err != nil {
+			return err
+		}
+	}
+	if err := validateProxy(args.Proxy); err != nil {
+		return err
+	}
+	for _, routeArgs := range args.Routes {
+		if routeUses(routeArgs, func(args BehaviourArgs) bool { return args.Proxy }) && args.Upstream == "" {
+			return fmt.Errorf("routes: route %q uses proxy but no upstream is configured", routeArgs.Path)
+		}
+		if routeUses(routeArgs, func(args BehaviourArgs) bool { return args.Replay }) && args.Fixtures.Mode != FIXTURE_MODE_REPLAY {
+			return fmt.Errorf("routes: route %q uses replay but no fixtures are replayed", routeArgs.Path)
+		}
+	}
+	if _, err := NewThrottle(args.Throttle, NewRealRandom()); err != nil {
+		return err
+	}
+	if args.Chaos != nil {
+		if _, err := NewMixBehaviour(args.Chaos, NewRealRandom()); err != nil {
+			return fmt.Errorf("chaos: %v", err)
+		}
+	}
+	switch args.Latency.Distribution {
+	case "", DISTRIBUTION_MAX:
+	case DISTRIBUTION_UNIFORM:
+		if parseTime(args.MaxWait) <= minWait(*args) {
+			return fmt.Errorf("maxwait must be greater than minwait for the uniform distribution")
+		}
+	default:
+		if _, err := NewDistributionSnoozer(args.Latency, minWait(*args), parseTime(args.MaxWait), NewRealRandom()); err != nil {
+			return fmt.Errorf("latency: %v", err)
+		}
+	}
+	if _, err := NewRetryBehaviour(args.Retry, nil); err != nil {
+		return err
+	}
+	if err := validateTLS(args.TLS); err != nil {
+		return err
+	}
+	if err := validateHTTP2(args.HTTP2); err != nil {
+		return err
+	}
+	if err := validateGRPC(args.GRPC); err != nil {
+		return err
+	}
+	if err := validateWebSocket(args.WebSocket); err != nil {
+		return err
+	}
+	if _, err := NewStreamBehaviour(args.Stream, nil); err != nil {
+		return err
+	}
+	if err := validateBody(args.Body); err != nil {
+		return err
+	}
+	if err := validateFixtures(args.Fixtures, args.Upstream); err != nil {
+		return err
+	}
+	if args.OpenAPI.Spec != "" {
+		if _, err := NewOpenAPI(args.OpenAPI); err != nil {
+			return err
+		}
+	} else if len(args.OpenAPI.Operations) > 0 {
+		return fmt.Errorf("openapi: operations are configured but no spec is")
+	}
+	if err := validateAccessLog(args.AccessLog); err != nil {
+		return err
+	}
+	if args.History < 0 {
+		return fmt.Errorf("history cannot be negative")
+	}
+	if args.RandomWait && parseTime(args.MaxWait) <= minWait(*args) {
+		return fmt.Errorf("maxwait must be greater than minwait when randomwait is set")
+	}
+	if args.RandomSize && parseSize(args.MaxSize) <= parseSize(args.MinSize) {
+		return fmt.Errorf("maxsize must be greater than minsize when randomsize is set")
+	}
+	return nil
+}
+
+// minWait returns the minwait, which when it is not set is DEFAULT_MIN_WAIT
+// for the uniform and max sleeps and 0 for the other distributions, so that
+// they are not clamped unless asked to be.
+func minWait(args CommandLineArgs) time.Duration {
+	if args.MinWait != "" {
+		return parseTime(args.MinWait)
+	}
+	switch args.Latency.Distribution {
+	case "", DISTRIBUTION_UNIFORM, DISTRIBUTION_MAX:
+		return DEFAULT_MIN_WAIT
+	}
+	return 0
+}
+
 func parseTime(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
 	parsedDeadTime, err := time.ParseDuration(value)
 	if err != nil {
 		//This should be A) tested and B) use panic and correctly propogate errors
@@ -72,6 +319,9 @@ func parseTime(value string) time.Duration {
 }
 
 func parseSize(value string) uint64 {
+	if value == "" {
+		return 0
+	}
 	parsedValue, err := humanize.ParseBytes(value)
 	if err != nil {
 		//This should be A) tested and B) use panic and correctly propogate errors
@@ -80,6 +330,54 @@ func parseSize(value string) uint64 {
 	return parsedValue
 }
 
+func parseUpstream(value string) (*url.URL, error) {
+	upstream, err := url.Parse(value)
+	if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("upstream: %q is not an absolute http or https URL", value)
+	}
+	return upstream, nil
+}
+
+// routeUses returns whether any of the behaviours or outcomes of the route
+// are used.
+func routeUses(args RouteArgs, used func(BehaviourArgs) bool) bool {
+	for _, behaviourArgs := range args.Behaviours {
+		if used(behaviourArgs) {
+			return true
+		}
+	}
+	for _, outcomeArgs := range args.Mix {
+		if used(outcomeArgs.BehaviourArgs) {
+			return true
+		}
+	}
+	return false
+}
+
+// usedScenarios returns the names of the scenarios used by the routes and
+// chaos.
+func usedScenarios(args *CommandLineArgs) []string {
+	names := []string{}
+	for _, routeArgs := range args.Routes {
+		for _, behaviourArgs := range routeArgs.Behaviours {
+			names = append(names, behaviourArgs.Scenario)
+		}
+		for _, outcomeArgs := range routeArgs.Mix {
+			names = append(names, outcomeArgs.Scenario)
+		}
+	}
+	for _, outcomeArgs := range args.Chaos {
+		names = append(names, outcomeArgs.Scenario)
+	}
+	used := []string{}
+	for _, name := range names {
+		if name != "" {
+			used = append(used, name)
+		}
+	}
+	return used
+}
+
 func NewArgsConfigurationReader(args *CommandLineArgs) *ArgsConfigurationReader {
 	return &ArgsConfigurationReader{args, 5 * time.Second}
 }
@@ -92,10 +390,213 @@ type Configuration struct {
 	headers    []string
 	deadTime   time.Duration
 	minWait    time.Duration
+	minWaitSet bool
 	maxWait    time.Duration
 	randomWait bool
 	minSize    uint64
 	maxSize    uint64
 	randomSize bool
-    jitterTime time.Duration
+	jitterTime time.Duration
+	adminPort  int
+	routes     []*Route
+	ordered    []*Route
+	chaos      *MixBehaviour
+	upstream   *url.URL
+	proxy      ProxyArgs
+	throttle   *Throttle
+	latency    LatencyArgs
+	overrides  OverrideArgs
+	history    int
+	accessLog  AccessLogArgs
+	retry      RetryArgs
+	tls        TLSArgs
+	http2      HTTP2Args
+	grpc       GRPCArgs
+	websocket  WebSocketArgs
+	stream     StreamArgs
+	body       BodyArgs
+	templates  bool
+	fixtures   *Fixtures
+	openapi    *OpenAPI
+	scenarios  []*Scenario
+}
+
+// Args converts the configuration back into the form it is read from so that
+// it can be serialised, e.g. by the admin API.
+func (instance Configuration) Args() CommandLineArgs {
+	routeArgs := []RouteArgs{}
+	for _, route := range instance.routes {
+		routeArgs = append(routeArgs, route.Args)
+	}
+	upstream := ""
+	if instance.upstream != nil {
+		upstream = instance.upstream.String()
+	}
+	throttleArgs := ThrottleArgs{}
+	if instance.throttle != nil {
+		throttleArgs = instance.throttle.Args
+	}
+	var chaosArgs []OutcomeArgs
+	if instance.chaos != nil {
+		chaosArgs = instance.chaos.Args
+	}
+	fixtureArgs := FixtureArgs{}
+	if instance.fixtures != nil {
+		fixtureArgs = instance.fixtures.Args
+	}
+	openAPIArgs := OpenAPIArgs{}
+	if instance.openapi != nil {
+		openAPIArgs = instance.openapi.Args
+	}
+	//An unset minwait stays unset so that it still depends on the distribution
+	minWaitArgs := ""
+	if instance.minWaitSet {
+		minWaitArgs = instance.minWait.String()
+	}
+	var scenarioArgs []ScenarioArgs
+	for _, scenario := range instance.scenarios {
+		scenarioArgs = append(scenarioArgs, scenario.Args)
+	}
+	return CommandLineArgs{
+		Port:       instance.port,
+		Host:       instance.host,
+		Verbose:    instance.verbose,
+		Content:    instance.content,
+		DeadTime:   instance.deadTime.String(),
+		MinWait:    minWaitArgs,
+		MaxWait:    instance.maxWait.String(),
+		RandomWait: instance.randomWait,
+		MinSize:    fmt.Sprintf("%dB", instance.minSize),
+		MaxSize:    fmt.Sprintf("%dB", instance.maxSize),
+		RandomSize: instance.randomSize,
+		Headers:    instance.headers,
+		Templates:  instance.templates,
+		JitterTime: instance.jitterTime.String(),
+		AdminPort:  instance.adminPort,
+		Routes:     routeArgs,
+		Chaos:      chaosArgs,
+		Upstream:   upstream,
+		Proxy:      instance.proxy,
+		Throttle:   throttleArgs,
+		Latency:    instance.latency,
+		Overrides:  instance.overrides,
+		History:    instance.history,
+		AccessLog:  instance.accessLog,
+		Retry:      instance.retry,
+		TLS:        instance.tls,
+		HTTP2:      instance.http2,
+		GRPC:       instance.grpc,
+		WebSocket:  instance.websocket,
+		Stream:     instance.stream,
+		Body:       instance.body,
+		Fixtures:   fixtureArgs,
+		OpenAPI:    openAPIArgs,
+		Scenarios:  scenarioArgs,
+	}
+}
+
+// orderRoutes merges the configured routes and those of the OpenAPI spec by
+// priority, with the configured routes first among those of the same priority.
+func (instance *Configuration) orderRoutes() {
+	instance.ordered = instance.routes
+	if instance.openapi != nil {
+		instance.ordered = append(append([]*Route{}, instance.routes...), instance.openapi.Routes...)
+		sortRoutes(instance.ordered)
+	}
+}
+
+// allRoutes returns the routes in the order they are matched.  A
+// configuration which was not read is ordered each time it is asked.
+func (instance Configuration) allRoutes() []*Route {
+	if instance.ordered == nil {
+		instance.orderRoutes()
+	}
+	return instance.ordered
+}
+
+func (instance Configuration) scenario(name string) *Scenario {
+	for _, scenario := range instance.scenarios {
+		if scenario.Args.Name == name {
+			return scenario
+		}
+	}
+	return nil
+}
+
+// keepRoutes reuses the routes and chaos mix of the previous configuration
+// which have not changed, so that updating the configuration does not forget
+// the attempts counted by their retry behaviours.  When only the behaviours
+// of a route have changed its unchanged rate limits are kept, so that the
+// clients of the route stay limited.
+func (instance *Configuration) keepRoutes(previous Configuration) {
+	for i, route := range instance.routes {
+		for _, kept := range previous.routes {
+			if reflect.DeepEqual(kept.Args, route.Args) {
+				instance.routes[i] = kept
+				break
+			}
+			if sameRoute(kept.Args, route.Args) {
+				keepRateLimits(route, kept)
+			}
+		}
+	}
+	if instance.chaos != nil && previous.chaos != nil && reflect.DeepEqual(instance.chaos.Args, previous.chaos.Args) {
+		instance.chaos = previous.chaos
+	}
+	instance.orderRoutes()
+}
+
+// render renders the text as a template for the request when templates are
+// switched on, and otherwise returns it as it is.
+func (instance *Configuration) render(text string, r *http.Request, params map[string]string) string {
+	if !instance.templates {
+		return text
+	}
+	return renderTemplate(text, r, params)
+}
+
+// keepFixtures reuses the fixtures of the previous configuration when they
+// are for the same file, so that fixtures which are not yet written survive,
+// and otherwise writes them.
+func (instance *Configuration) keepFixtures(previous Configuration) {
+	if instance.fixtures != nil && previous.fixtures != nil && instance.fixtures.Args == previous.fixtures.Args {
+		instance.fixtures = previous.fixtures
+		return
+	}
+	if err := previous.fixtures.Flush(); err != nil {
+		fmt.Println(fmt.Sprintf("Cannot save the fixtures: %v", err))
+	}
+}
+
+// sameRoute reports whether the routes match the same requests.
+func sameRoute(route RouteArgs, other RouteArgs) bool {
+	return route.Path == other.Path &&
+		route.Priority == other.Priority &&
+		reflect.DeepEqual(route.Methods, other.Methods) &&
+		reflect.DeepEqual(route.Match, other.Match)
+}
+
+// keepRateLimits reuses the rate limits of the kept route which are in the
+// same place in its chain with the same arguments.
+func keepRateLimits(route *Route, kept *Route) {
+	for i, behaviour := range route.Behaviours {
+		limit, ok := behaviour.(*RateLimitBehaviour)
+		if !ok || i >= len(kept.Behaviours) {
+			continue
+		}
+		if keptLimit, ok := kept.Behaviours[i].(*RateLimitBehaviour); ok && keptLimit.Args == limit.Args {
+			route.Behaviours[i] = keptLimit
+		}
+	}
+}
+
+// keepScenarios reuses the scenarios of the previous configuration which have
+// not changed, so that updating the configuration does not reset them.
+func (instance *Configuration) keepScenarios(previous Configuration) {
+	for i, scenario := range instance.scenarios {
+		kept := previous.scenario(scenario.Args.Name)
+		if kept != nil && reflect.DeepEqual(kept.Args, scenario.Args) {
+			instance.scenarios[i] = kept
+		}
+	}
 }
@@ -38,7 +38,22 @@ randomwait: true
 minsize: 1KB
 maxsize: 2KB
 randomsize: true
-headers: ["Age:1","Content-type:text/plain"]`
+headers: ["Age:1","Content-type:text/plain"]
+routes:
+  - path: /api/v1/orders/{id}
+    methods: [GET]
+    behaviours:
+      - status: 503
+      - delay: 1s
+chaos:
+  - weight: 9
+    status: 200
+  - weight: 1
+    fault: dead
+latency:
+  distribution: pareto
+  scale: 10ms
+  shape: 1.5`
 				file, err = ioutil.TempFile("", "enanos")
 				file.WriteString(data)
 				file.Close()
@@ -90,6 +105,20 @@ headers: ["Age:1","Content-type:text/plain"]`
 			It("randomSize", func() {
 				Expect(config.randomSize).To(Equal(true))
 			})
+			It("routes", func() {
+				Expect(config.routes).To(HaveLen(1))
+				Expect(config.routes[0].Args.Path).To(Equal("/api/v1/orders/{id}"))
+				Expect(config.routes[0].Methods).To(Equal([]string{"GET"}))
+				Expect(config.routes[0].Behaviours).To(HaveLen(2))
+			})
+			It("chaos", func() {
+				Expect(config.chaos.Outcomes).To(HaveLen(2))
+				Expect(config.chaos.Args[1].Weight).To(Equal(1))
+				Expect(config.chaos.Args[1].Fault).To(Equal(FAULT_DEAD))
+			})
+			It("latency", func() {
+				Expect(config.latency).To(Equal(LatencyArgs{Distribution: DISTRIBUTION_PARETO, Scale: "10ms", Shape: 1.5}))
+			})
 		})
 
 	})
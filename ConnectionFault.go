@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// hijack takes over the connection of the exchange, clearing any deadlines
+// the server has set on it.  When the connection cannot be hijacked, e.g. for
+// HTTP/2, the handler is aborted instead which resets the stream.
+func hijack(exchange *Exchange) (net.Conn, *bufio.ReadWriter) {
+	exchange.Handled = true
+	if hijacker, ok := exchange.Writer.(http.Hijacker); ok {
+		conn, buffer, err := hijacker.Hijack()
+		if err == nil {
+			conn.SetDeadline(time.Time{})
+			return conn, buffer
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// killConnection closes the underlying connection without writing a response.
+func killConnection(exchange *Exchange) {
+	conn, _ := hijack(exchange)
+	conn.Close()
+}
+
+// resetConnection closes the connection with a TCP RST rather than a FIN.
+func resetConnection(exchange *Exchange) {
+	conn, _ := hijack(exchange)
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// closeAfterPartialHeaders writes the status line and part of the headers
+// before closing the connection.
+func closeAfterPartialHeaders(exchange *Exchange) {
+	conn, buffer := hijack(exchange)
+	defer conn.Close()
+	exchange.Header().Set("Content-Length", strconv.Itoa(len(exchange.Body)))
+	head := responseHead(exchange)
+	buffer.WriteString(head[:len(head)/2])
+	buffer.Flush()
+}
+
+// closeMidBody writes the headers, declaring the full length of the body, and
+// half of the body before closing the connection.
+func closeMidBody(exchange *Exchange) {
+	conn, buffer := hijack(exchange)
+	defer conn.Close()
+	exchange.Header().Set("Content-Length", strconv.Itoa(len(exchange.Body)))
+	buffer.WriteString(responseHead(exchange))
+	buffer.Write(exchange.Body[:len(exchange.Body)/2])
+	buffer.Flush()
+}
+
+// hangConnection accepts the request but never responds, holding the
+// connection open until the client gives up.
+func hangConnection(exchange *Exchange) {
+	conn, buffer := hijack(exchange)
+	defer conn.Close()
+	io.Copy(ioutil.Discard, buffer)
+}
+
+// respondWithoutContentLength writes the whole response without a
+// Content-Length and then keeps the connection open, so the client cannot
+// tell that the body has finished until it gives up.
+func respondWithoutContentLength(exchange *Exchange) {
+	conn, buffer := hijack(exchange)
+	defer conn.Close()
+	exchange.Header().Del("Content-Length")
+	buffer.WriteString(responseHead(exchange))
+	buffer.Write(exchange.Body)
+	buffer.Flush()
+	io.Copy(ioutil.Discard, buffer)
+}
+
+func responseHead(exchange *Exchange) string {
+	head := fmt.Sprintf("HTTP/1.1 %d %s\r\n", exchange.Code, http.StatusText(exchange.Code))
+	for key, values := range exchange.Header() {
+		for _, value := range values {
+			head += fmt.Sprintf("%s: %s\r\n", key, value)
+		}
+	}
+	return head + "\r\n"
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DISTRIBUTION_UNIFORM     string = "uniform"
+	DISTRIBUTION_MAX         string = "max"
+	DISTRIBUTION_NORMAL      string = "normal"
+	DISTRIBUTION_LOGNORMAL   string = "lognormal"
+	DISTRIBUTION_EXPONENTIAL string = "exponential"
+	DISTRIBUTION_PARETO      string = "pareto"
+	DISTRIBUTION_PERCENTILES string = "percentiles"
+)
+
+// LatencyArgs is the configuration file form of a distribution Snoozer.
+// Which of the fields are used depends on the distribution:
+//
+//	normal, lognormal - mean and stddev
+//	exponential       - mean
+//	pareto            - scale, the minimum sleep, and shape
+//	percentiles       - a list of percentile:duration e.g. p50:100ms, p99:1s
+type LatencyArgs struct {
+	Distribution string   `json:"distribution,omitempty"`
+	Mean         string   `json:"mean,omitempty"`
+	StdDev       string   `json:"stddev,omitempty"`
+	Scale        string   `json:"scale,omitempty"`
+	Shape        float64  `json:"shape,omitempty"`
+	Percentiles  []string `json:"percentiles,omitempty"`
+}
+
+// DurationSampler draws a single duration from a distribution.
+type DurationSampler interface {
+	Sample() time.Duration
+}
+
+// DistributionSnoozer sleeps for a duration drawn from a DurationSampler,
+// clamped between Min and Max so a long tail cannot sleep forever.  A Max of
+// zero leaves the duration unbounded.
+type DistributionSnoozer struct {
+	Sampler DurationSampler
+	Min     time.Duration
+	Max     time.Duration
+}
+
+func (instance *DistributionSnoozer) Duration() time.Duration {
+	duration := instance.Sampler.Sample()
+	if duration < instance.Min {
+		duration = instance.Min
+	}
+	if instance.Max > 0 && duration > instance.Max {
+		duration = instance.Max
+	}
+	return duration
+}
+
+func (instance *DistributionSnoozer) Snooze() {
+	time.Sleep(instance.Duration())
+}
+
+type NormalSampler struct {
+	Mean   time.Duration
+	StdDev time.Duration
+	random Random
+}
+
+func (instance *NormalSampler) Sample() time.Duration {
+	return instance.Mean + time.Duration(instance.random.NormFloat64()*float64(instance.StdDev))
+}
+
+// LogNormalSampler is parameterised by the mean and standard deviation of the
+// durations it produces rather than of the underlying normal distribution.
+type LogNormalSampler struct {
+	mu     float64
+	sigma  float64
+	random Random
+}
+
+func (instance *LogNormalSampler) Sample() time.Duration {
+	return time.Duration(math.Exp(instance.mu + instance.sigma*instance.random.NormFloat64()))
+}
+
+func NewLogNormalSampler(mean time.Duration, stdDev time.Duration, random Random) *LogNormalSampler {
+	variance := math.Log(1 + math.Pow(float64(stdDev)/float64(mean), 2))
+	return &LogNormalSampler{
+		mu:     math.Log(float64(mean)) - variance/2,
+		sigma:  math.Sqrt(variance),
+		random: random,
+	}
+}
+
+type ExponentialSampler struct {
+	Mean   time.Duration
+	random Random
+}
+
+func (instance *ExponentialSampler) Sample() time.Duration {
+	return time.Duration(instance.random.ExpFloat64() * float64(instance.Mean))
+}
+
+type ParetoSampler struct {
+	Scale  time.Duration
+	Shape  float64
+	random Random
+}
+
+func (instance *ParetoSampler) Sample() time.Duration {
+	return time.Duration(float64(instance.Scale) / math.Pow(1-instance.random.Float64(), 1/instance.Shape))
+}
+
+type Percentile struct {
+	Quantile float64
+	Duration time.Duration
+}
+
+// PercentileSampler interpolates linearly between the durations of a table of
+// percentiles, starting from Min at the 0th percentile and ending at the last
+// percentile's duration, or Max when it is greater.
+type PercentileSampler struct {
+	Percentiles []Percentile
+	random      Random
+}
+
+func (instance *PercentileSampler) Sample() time.Duration {
+	quantile := instance.random.Float64()
+	previous := instance.Percentiles[0]
+	for _, percentile := range instance.Percentiles[1:] {
+		if quantile <= percentile.Quantile {
+			fraction := (quantile - previous.Quantile) / (percentile.Quantile - previous.Quantile)
+			return previous.Duration + time.Duration(fraction*float64(percentile.Duration-previous.Duration))
+		}
+		previous = percentile
+	}
+	return previous.Duration
+}
+
+func NewPercentileSampler(values []string, min time.Duration, max time.Duration, random Random) (*PercentileSampler, error) {
+	percentiles := []Percentile{}
+	for _, value := range values {
+		split := strings.SplitN(value, ":", 2)
+		if len(split) != 2 || !strings.HasPrefix(split[0], "p") {
+			return nil, fmt.Errorf("percentiles: %q is not in the format p50:100ms", value)
+		}
+		quantile, err := parseQuantile(split[0])
+		if err != nil {
+			return nil, err
+		}
+		duration, err := time.ParseDuration(split[1])
+		if err != nil {
+			return nil, fmt.Errorf("percentiles: cannot parse time from %q", split[1])
+		}
+		percentiles = append(percentiles, Percentile{quantile, duration})
+	}
+	if len(percentiles) == 0 {
+		return nil, fmt.Errorf("percentiles: at least one percentile is required")
+	}
+	sort.Slice(percentiles, func(i, j int) bool {
+		return percentiles[i].Quantile < percentiles[j].Quantile
+	})
+	for i := 1; i < len(percentiles); i++ {
+		if percentiles[i].Quantile == percentiles[i-1].Quantile {
+			return nil, fmt.Errorf("percentiles: the %vth percentile is given more than once", percentiles[i].Quantile*100)
+		}
+		if percentiles[i].Duration < percentiles[i-1].Duration {
+			return nil, fmt.Errorf("percentiles: durations must increase with the percentile")
+		}
+	}
+	last := percentiles[len(percentiles)-1].Duration
+	if max > last {
+		last = max
+	}
+	percentiles = append([]Percentile{{0, min}}, percentiles...)
+	percentiles = append(percentiles, Percentile{1, last})
+	return &PercentileSampler{percentiles, random}, nil
+}
+
+// parseQuantile reads a percentile such as p5, p50, p99.9 or its short form
+// p999, which has the decimal point after the first two digits, as a quantile
+// between 0 and 1.  p0 and p100 are rejected as the sampler already starts at
+// the minimum and ends at the maximum.
+func parseQuantile(value string) (float64, error) {
+	digits := strings.TrimPrefix(value, "p")
+	if len(digits) > 2 && !strings.Contains(digits, ".") {
+		if !strings.HasPrefix(digits, "99") {
+			return 0, fmt.Errorf("percentiles: %q is not a percentile such as p5, p50, p99, p99.9 or p999", value)
+		}
+		digits = digits[:2] + "." + digits[2:]
+	}
+	percent, err := strconv.ParseFloat(digits, 64)
+	if err != nil || !(percent > 0 && percent < 100) {
+		return 0, fmt.Errorf("percentiles: %q is not a percentile such as p5, p50, p99, p99.9 or p999", value)
+	}
+	return percent / 100, nil
+}
+
+// NewDistributionSnoozer creates the Snoozer described by args, clamping the
+// sleeps between min and max.
+func NewDistributionSnoozer(args LatencyArgs, min time.Duration, max time.Duration, random Random) (*DistributionSnoozer, error) {
+	durations := map[string]time.Duration{}
+	for name, value := range map[string]string{"mean": args.Mean, "stddev": args.StdDev, "scale": args.Scale} {
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot parse time from %q", name, value)
+		}
+		durations[name] = duration
+	}
+	var sampler DurationSampler
+	switch args.Distribution {
+	case DISTRIBUTION_NORMAL:
+		sampler = &NormalSampler{durations["mean"], durations["stddev"], random}
+	case DISTRIBUTION_LOGNORMAL:
+		if durations["mean"] <= 0 {
+			return nil, fmt.Errorf("mean must be greater than 0 for the lognormal distribution")
+		}
+		sampler = NewLogNormalSampler(durations["mean"], durations["stddev"], random)
+	case DISTRIBUTION_EXPONENTIAL:
+		sampler = &ExponentialSampler{durations["mean"], random}
+	case DISTRIBUTION_PARETO:
+		if durations["scale"] <= 0 || args.Shape <= 0 {
+			return nil, fmt.Errorf("scale and shape must be greater than 0 for the pareto distribution")
+		}
+		sampler = &ParetoSampler{durations["scale"], args.Shape, random}
+	case DISTRIBUTION_PERCENTILES:
+		percentileSampler, err := NewPercentileSampler(args.Percentiles, min, max, random)
+		if err != nil {
+			return nil, err
+		}
+		sampler = percentileSampler
+	default:
+		return nil, fmt.Errorf("distribution: %q is not one of uniform, max, normal, lognormal, exponential, pareto or percentiles", args.Distribution)
+	}
+	return &DistributionSnoozer{sampler, min, max}, nil
+}
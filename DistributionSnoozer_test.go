@@ -0,0 +1,112 @@
+package main
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DistributionSnoozer", func() {
+
+	var random *FakeRandom
+
+	BeforeEach(func() {
+		random = NewFakeRandom()
+	})
+
+	create := func(args LatencyArgs, min time.Duration, max time.Duration) *DistributionSnoozer {
+		snoozer, err := NewDistributionSnoozer(args, min, max, random)
+		Expect(err).To(BeNil())
+		return snoozer
+	}
+
+	It("normal adds the standard deviations to the mean", func() {
+		random.ForFloatUse(2)
+		snoozer := create(LatencyArgs{Distribution: DISTRIBUTION_NORMAL, Mean: "100ms", StdDev: "10ms"}, 0, 0)
+		Expect(snoozer.Duration()).To(Equal(120 * time.Millisecond))
+	})
+
+	It("lognormal returns the median when the draw is zero", func() {
+		random.ForFloatUse(0)
+		snoozer := create(LatencyArgs{Distribution: DISTRIBUTION_LOGNORMAL, Mean: "100ms", StdDev: "0s"}, 0, 0)
+		Expect(snoozer.Duration()).To(BeNumerically("~", 100*time.Millisecond, time.Microsecond))
+	})
+
+	It("exponential scales the draw by the mean", func() {
+		random.ForFloatUse(1.5)
+		snoozer := create(LatencyArgs{Distribution: DISTRIBUTION_EXPONENTIAL, Mean: "100ms"}, 0, 0)
+		Expect(snoozer.Duration()).To(Equal(150 * time.Millisecond))
+	})
+
+	It("pareto never returns less than the scale", func() {
+		random.ForFloatUse(0)
+		snoozer := create(LatencyArgs{Distribution: DISTRIBUTION_PARETO, Scale: "50ms", Shape: 1.5}, 0, 0)
+		Expect(snoozer.Duration()).To(Equal(50 * time.Millisecond))
+	})
+
+	It("pareto has a long tail", func() {
+		random.ForFloatUse(0.99)
+		snoozer := create(LatencyArgs{Distribution: DISTRIBUTION_PARETO, Scale: "50ms", Shape: 1}, 0, 0)
+		Expect(snoozer.Duration()).To(BeNumerically("~", 5*time.Second, time.Millisecond))
+	})
+
+	Describe("percentiles", func() {
+		var snoozer *DistributionSnoozer
+
+		BeforeEach(func() {
+			snoozer = create(LatencyArgs{
+				Distribution: DISTRIBUTION_PERCENTILES,
+				Percentiles:  []string{"p90:300ms", "p50:100ms", "p99:1s"},
+			}, 0, 0)
+		})
+
+		It("returns the duration of a percentile", func() {
+			random.ForFloatUse(0.9)
+			Expect(snoozer.Duration()).To(Equal(300 * time.Millisecond))
+		})
+
+		It("interpolates between percentiles", func() {
+			random.ForFloatUse(0.7)
+			Expect(snoozer.Duration()).To(BeNumerically("~", 200*time.Millisecond, time.Microsecond))
+		})
+
+		It("reads the percentiles as percents", func() {
+			for value, quantile := range map[string]float64{"p5": 0.05, "p50": 0.5, "p99.9": 0.999, "p999": 0.999, "p9999": 0.9999} {
+				Expect(parseQuantile(value)).To(BeNumerically("~", quantile, 1e-9))
+			}
+			for _, value := range []string{"p0", "p100", "p500", "p", "pNaN"} {
+				_, err := parseQuantile(value)
+				Expect(err).ToNot(BeNil())
+			}
+		})
+
+		It("rejects durations which decrease", func() {
+			_, err := NewDistributionSnoozer(LatencyArgs{
+				Distribution: DISTRIBUTION_PERCENTILES,
+				Percentiles:  []string{"p50:1s", "p90:100ms"},
+			}, 0, 0, random)
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	It("clamps the duration between min and max", func() {
+		random.ForFloatUse(100)
+		snoozer := create(LatencyArgs{Distribution: DISTRIBUTION_EXPONENTIAL, Mean: "100ms"}, 0, time.Second)
+		Expect(snoozer.Duration()).To(Equal(time.Second))
+		random.ForFloatUse(-100)
+		snoozer = create(LatencyArgs{Distribution: DISTRIBUTION_NORMAL, Mean: "100ms", StdDev: "10ms"}, 50*time.Millisecond, time.Second)
+		Expect(snoozer.Duration()).To(Equal(50 * time.Millisecond))
+	})
+
+	It("defaults minwait to 0 for the distributions", func() {
+		Expect(minWait(CommandLineArgs{})).To(Equal(DEFAULT_MIN_WAIT))
+		Expect(minWait(CommandLineArgs{Latency: LatencyArgs{Distribution: DISTRIBUTION_PERCENTILES}})).To(Equal(time.Duration(0)))
+		Expect(minWait(CommandLineArgs{MinWait: "5ms", Latency: LatencyArgs{Distribution: DISTRIBUTION_PERCENTILES}})).To(Equal(5 * time.Millisecond))
+	})
+
+	It("rejects an unknown distribution", func() {
+		_, err := NewDistributionSnoozer(LatencyArgs{Distribution: "gamma"}, 0, 0, random)
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	FAULT_SUCCESS      string = "success"
+	FAULT_SERVER_ERROR string = "server_error"
+	FAULT_CLIENT_ERROR string = "client_error"
+	FAULT_REDIRECT     string = "redirect"
+	FAULT_WAIT         string = "wait"
+	FAULT_CONTENT_SIZE string = "content_size"
+	FAULT_DEAD         string = "dead"
+	FAULT_NONE         string = "none"
+
+	FAULT_CONNECTION_RESET  string = "connection_reset"
+	FAULT_PARTIAL_HEADERS   string = "partial_headers"
+	FAULT_PARTIAL_BODY      string = "partial_body"
+	FAULT_HANG              string = "hang"
+	FAULT_NO_CONTENT_LENGTH string = "no_content_length"
+)
+
+var (
+	FAULTS []string = []string{FAULT_SUCCESS, FAULT_SERVER_ERROR, FAULT_CLIENT_ERROR, FAULT_REDIRECT, FAULT_WAIT, FAULT_CONTENT_SIZE, FAULT_DEAD, FAULT_NONE,
+		FAULT_CONNECTION_RESET, FAULT_PARTIAL_HEADERS, FAULT_PARTIAL_BODY, FAULT_HANG, FAULT_NO_CONTENT_LENGTH}
+)
+
+// FaultBehaviour reproduces one of the built in endpoints using the live
+// profile, so that routes and mixes behave exactly like the endpoint would.
+type FaultBehaviour struct {
+	Fault string
+}
+
+func (instance *FaultBehaviour) Apply(exchange *Exchange) {
+	recordFault(exchange.Request, instance.Fault)
+	profile := exchange.Profile
+	switch instance.Fault {
+	case FAULT_SUCCESS:
+		exchange.Code = http.StatusOK
+		exchange.Body = []byte(profile.Config.render(profile.Config.content, exchange.Request, exchange.Params))
+	case FAULT_SERVER_ERROR:
+		exchange.Code = profile.ResponseCodeGenerator.GenerateServerErrorCode()
+	case FAULT_CLIENT_ERROR:
+		exchange.Code = profile.ResponseCodeGenerator.GenerateClientErrorCode()
+	case FAULT_REDIRECT:
+		exchange.Code = profile.ResponseCodeGenerator.GenerateRedirectionCode()
+		if exchange.Code == 301 || exchange.Code == 302 || exchange.Code == 303 || exchange.Code == 307 {
+			if exchange.Header().Get("location") == "" {
+				exchange.Header().Set("location", exchange.Request.URL.Path)
+			}
+		}
+	case FAULT_WAIT:
+		snoozeFor(exchange.Request, profile.Snoozer)
+		exchange.Code = http.StatusOK
+		exchange.Body = []byte(profile.Config.render(profile.Config.content, exchange.Request, exchange.Params))
+	case FAULT_CONTENT_SIZE:
+		exchange.Code = http.StatusOK
+		exchange.Body = generateContent(profile.ResponseBodyGenerator, profile.Config.body, exchange.Header().Get("Content-Type"))
+	case FAULT_DEAD:
+		killConnection(exchange)
+	case FAULT_NONE:
+		//Leaves the response as it is, e.g. as returned by the upstream
+	case FAULT_CONNECTION_RESET:
+		resetConnection(exchange)
+	case FAULT_PARTIAL_HEADERS:
+		closeAfterPartialHeaders(exchange)
+	case FAULT_PARTIAL_BODY:
+		if len(exchange.Body) == 0 {
+			exchange.Body = []byte(profile.Config.render(profile.Config.content, exchange.Request, exchange.Params))
+		}
+		closeMidBody(exchange)
+	case FAULT_HANG:
+		hangConnection(exchange)
+	case FAULT_NO_CONTENT_LENGTH:
+		if len(exchange.Body) == 0 {
+			exchange.Body = []byte(profile.Config.render(profile.Config.content, exchange.Request, exchange.Params))
+		}
+		respondWithoutContentLength(exchange)
+	}
+}
+
+func NewFaultBehaviour(fault string) (*FaultBehaviour, error) {
+	for _, item := range FAULTS {
+		if item == fault {
+			return &FaultBehaviour{fault}, nil
+		}
+	}
+	return nil, fmt.Errorf("fault: %q is not one of %v", fault, FAULTS)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	FIXTURE_MODE_RECORD string = "record"
+	FIXTURE_MODE_REPLAY string = "replay"
+
+	FIXTURE_ENCODING_BASE64 string = "base64"
+
+	ENDPOINT_FIXTURE string = "fixture"
+
+	// FIXTURE_FLUSH_DELAY is how long after a fixture is recorded the file is
+	// written, so that a burst of requests only writes it once.
+	FIXTURE_FLUSH_DELAY time.Duration = time.Second
+)
+
+var (
+	FIXTURE_MODES []string = []string{FIXTURE_MODE_RECORD, FIXTURE_MODE_REPLAY}
+)
+
+// FixtureArgs is the configuration file form of the fixtures.  In record mode
+// each response fetched from the upstream is saved to the file at the path and
+// in replay mode the saved responses are served in place of the upstream.
+type FixtureArgs struct {
+	Path string `json:"path,omitempty"`
+	Mode string `json:"mode,omitempty"`
+}
+
+func validateFixtures(args FixtureArgs, upstream string) error {
+	if args.Mode == "" {
+		return nil
+	}
+	if !ContainsString(FIXTURE_MODES, args.Mode) {
+		return fmt.Errorf("fixtures: mode %q is not one of %v", args.Mode, FIXTURE_MODES)
+	}
+	if args.Path == "" {
+		return fmt.Errorf("fixtures: a path is required to %s", args.Mode)
+	}
+	if args.Mode == FIXTURE_MODE_RECORD && upstream == "" {
+		return fmt.Errorf("fixtures: recording requires an upstream")
+	}
+	_, err := NewFixtures(args)
+	return err
+}
+
+// Fixture is a response recorded from the upstream along with the request it
+// answered.  A body which is not valid UTF-8 is saved in base64.
+type Fixture struct {
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Query    string      `json:"query,omitempty"`
+	Status   int         `json:"status"`
+	Headers  http.Header `json:"headers,omitempty"`
+	Body     string      `json:"body"`
+	Encoding string      `json:"encoding,omitempty"`
+}
+
+func (instance Fixture) Content() ([]byte, error) {
+	if instance.Encoding == FIXTURE_ENCODING_BASE64 {
+		return base64.StdEncoding.DecodeString(instance.Body)
+	}
+	return []byte(instance.Body), nil
+}
+
+func (instance Fixture) matches(r *http.Request, query bool) bool {
+	return instance.Method == r.Method &&
+		instance.Path == r.URL.Path &&
+		(!query || instance.Query == r.URL.RawQuery)
+}
+
+// NewFixture records the response to the request.
+func NewFixture(r *http.Request, status int, headers http.Header, body []byte) Fixture {
+	fixture := Fixture{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Status:  status,
+		Headers: http.Header{},
+		Body:    string(body),
+	}
+	copyHeaders(fixture.Headers, headers)
+	fixture.Headers.Del("Content-Length")
+	if !utf8.Valid(body) {
+		fixture.Body = base64.StdEncoding.EncodeToString(body)
+		fixture.Encoding = FIXTURE_ENCODING_BASE64
+	}
+	return fixture
+}
+
+// Fixtures holds the fixtures of the file at the path of the args.  The file
+// is JSON, or YAML when written by hand, with a list of fixtures.
+type Fixtures struct {
+	Args      FixtureArgs
+	mutex     sync.Mutex
+	writing   sync.Mutex
+	fixtures  []Fixture
+	scheduled bool
+}
+
+func (instance *Fixtures) Recording() bool {
+	return instance != nil && instance.Args.Mode == FIXTURE_MODE_RECORD
+}
+
+func (instance *Fixtures) Replaying() bool {
+	return instance != nil && instance.Args.Mode == FIXTURE_MODE_REPLAY
+}
+
+// Match returns the fixture for the method, path and query of the request,
+// falling back to one for the method and path with any query.
+func (instance *Fixtures) Match(r *http.Request) *Fixture {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	for _, query := range []bool{true, false} {
+		for i := range instance.fixtures {
+			if instance.fixtures[i].matches(r, query) {
+				fixture := instance.fixtures[i]
+				return &fixture
+			}
+		}
+	}
+	return nil
+}
+
+// Record saves the fixture, replacing any recorded for the same request, and
+// writes the file FIXTURE_FLUSH_DELAY later unless it is flushed before then.
+func (instance *Fixtures) Record(fixture Fixture) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	replaced := false
+	for i, recorded := range instance.fixtures {
+		if recorded.Method == fixture.Method && recorded.Path == fixture.Path && recorded.Query == fixture.Query {
+			instance.fixtures[i] = fixture
+			replaced = true
+		}
+	}
+	if !replaced {
+		instance.fixtures = append(instance.fixtures, fixture)
+	}
+	if !instance.scheduled {
+		instance.scheduled = true
+		time.AfterFunc(FIXTURE_FLUSH_DELAY, func() {
+			if err := instance.Flush(); err != nil {
+				fmt.Println(fmt.Sprintf("Cannot save the fixtures: %v", err))
+			}
+		})
+	}
+}
+
+// Flush writes the file when fixtures have been recorded since it was last
+// written.  It does nothing on nil Fixtures.
+func (instance *Fixtures) Flush() error {
+	if instance == nil {
+		return nil
+	}
+	instance.writing.Lock()
+	defer instance.writing.Unlock()
+	instance.mutex.Lock()
+	if !instance.scheduled {
+		instance.mutex.Unlock()
+		return nil
+	}
+	instance.scheduled = false
+	data, err := json.MarshalIndent(instance.fixtures, "", "  ")
+	instance.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(instance.Args.Path, data, 0644)
+}
+
+// NewFixtures reads the fixtures from the file at the path.  A file which does
+// not exist yet is only allowed when recording.
+func NewFixtures(args FixtureArgs) (*Fixtures, error) {
+	fixtures := &Fixtures{Args: args}
+	data, err := ioutil.ReadFile(args.Path)
+	if os.IsNotExist(err) && args.Mode == FIXTURE_MODE_RECORD {
+		return fixtures, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fixtures: cannot read %q: %v", args.Path, err)
+	}
+	if json.Unmarshal(data, &fixtures.fixtures) != nil {
+		if err := yaml.Unmarshal(data, &fixtures.fixtures); err != nil {
+			return nil, fmt.Errorf("fixtures: cannot parse %q: %v", args.Path, err)
+		}
+	}
+	for _, fixture := range fixtures.fixtures {
+		if _, err := fixture.Content(); err != nil {
+			return nil, fmt.Errorf("fixtures: the body of %s %s is not base64", fixture.Method, fixture.Path)
+		}
+	}
+	return fixtures, nil
+}
+
+// ReplayBehaviour responds with the fixture recorded for the request, so that
+// later behaviours are applied on top of a realistic response.
+type ReplayBehaviour struct{}
+
+func (instance *ReplayBehaviour) Apply(exchange *Exchange) {
+	fixtures := exchange.Profile.Config.fixtures
+	if !fixtures.Replaying() {
+		exchange.Code = http.StatusNotFound
+		exchange.Body = []byte("no fixtures are being replayed")
+		return
+	}
+	fixture := fixtures.Match(exchange.Request)
+	if fixture == nil {
+		exchange.Code = http.StatusNotFound
+		exchange.Body = []byte(fmt.Sprintf("no fixture is recorded for %s %s", exchange.Request.Method, exchange.Request.URL.Path))
+		return
+	}
+	//Fixtures are checked when they are read
+	body, _ := fixture.Content()
+	copyHeaders(exchange.Header(), fixture.Headers)
+	exchange.Code = fixture.Status
+	exchange.Body = body
+}
+
+func NewReplayBehaviour() *ReplayBehaviour {
+	return &ReplayBehaviour{}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fixtures", func() {
+
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "enanos")
+		path = filepath.Join(dir, "fixtures.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	load := func(mode string) Configuration {
+		fixtures, err := NewFixtures(FixtureArgs{Path: path, Mode: mode})
+		Expect(err).To(BeNil())
+		return Configuration{fixtures: fixtures}
+	}
+
+	serve := func(router *Router, method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, newRequest(method, path))
+		return recorder
+	}
+
+	It("records the responses of the upstream and replays them", func() {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"order":"` + r.URL.Path + "?" + r.URL.RawQuery + `"}`))
+		}))
+		config := load(FIXTURE_MODE_RECORD)
+		config.upstream, _ = url.Parse(upstream.URL)
+		recorder := serve(NewRouter(NewLiveProfile(Profile{Config: config})), "GET", "/orders/1?expand=true")
+		Expect(recorder.Code).To(Equal(http.StatusCreated))
+		upstream.Close()
+		Expect(config.fixtures.Flush()).To(BeNil())
+
+		router := NewRouter(NewLiveProfile(Profile{Config: load(FIXTURE_MODE_REPLAY)}))
+		recorder = serve(router, "GET", "/orders/1?expand=true")
+		Expect(recorder.Code).To(Equal(http.StatusCreated))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Body.String()).To(Equal(`{"order":"/orders/1?expand=true"}`))
+		Expect(serve(router, "GET", "/orders/1").Body.String()).To(Equal(`{"order":"/orders/1?expand=true"}`))
+		Expect(serve(router, "POST", "/orders/1").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("saves bodies which are not UTF-8 in base64", func() {
+		fixtures := load(FIXTURE_MODE_RECORD).fixtures
+		body := []byte{0xFF, 0x00, 0xFE}
+		fixtures.Record(NewFixture(newRequest("GET", "/image"), 200, http.Header{}, body))
+		Expect(fixtures.Flush()).To(BeNil())
+		fixture := load(FIXTURE_MODE_REPLAY).fixtures.Match(newRequest("GET", "/image"))
+		Expect(fixture.Encoding).To(Equal(FIXTURE_ENCODING_BASE64))
+		content, err := fixture.Content()
+		Expect(err).To(BeNil())
+		Expect(content).To(Equal(body))
+	})
+
+	It("writes the file once the recorded fixtures have settled", func() {
+		fixtures := load(FIXTURE_MODE_RECORD).fixtures
+		fixtures.Record(NewFixture(newRequest("GET", "/orders/1"), 200, http.Header{}, []byte("1")))
+		fixtures.Record(NewFixture(newRequest("GET", "/orders/2"), 200, http.Header{}, []byte("2")))
+		_, err := os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Eventually(func() int {
+			written, _ := NewFixtures(FixtureArgs{Path: path, Mode: FIXTURE_MODE_REPLAY})
+			if written == nil {
+				return 0
+			}
+			return len(written.fixtures)
+		}, 3*FIXTURE_FLUSH_DELAY).Should(Equal(2))
+	})
+
+	It("applies later behaviours on top of the fixture", func() {
+		ioutil.WriteFile(path, []byte("- method: GET\n  path: /orders/1\n  status: 200\n  headers: {X-Order: [\"1\"]}\n  body: '0123456789'\n"), 0644)
+		route, err := NewRoute(RouteArgs{
+			Path:       "/orders/{id}",
+			Behaviours: []BehaviourArgs{{Replay: true}, {Truncate: "50%"}, {Status: 503}},
+		})
+		Expect(err).To(BeNil())
+		recorder := httptest.NewRecorder()
+		route.Serve(recorder, newRequest("GET", "/orders/1"), nil, Profile{Config: load(FIXTURE_MODE_REPLAY)})
+		Expect(recorder.Code).To(Equal(503))
+		Expect(recorder.Header().Get("X-Order")).To(Equal("1"))
+		Expect(recorder.Body.String()).To(Equal("01234"))
+	})
+
+	It("rejects invalid fixtures", func() {
+		Expect(validateFixtures(FixtureArgs{Path: path, Mode: "playback"}, "")).ToNot(BeNil())
+		Expect(validateFixtures(FixtureArgs{Path: path, Mode: FIXTURE_MODE_RECORD}, "")).ToNot(BeNil())
+		Expect(validateFixtures(FixtureArgs{Path: path, Mode: FIXTURE_MODE_REPLAY}, "")).ToNot(BeNil())
+		Expect(validateFixtures(FixtureArgs{Path: path, Mode: FIXTURE_MODE_RECORD}, "http://localhost:9000")).To(BeNil())
+		ioutil.WriteFile(path, []byte(`[{"method":"GET","path":"/","body":"!","encoding":"base64"}]`), 0644)
+		Expect(validateFixtures(FixtureArgs{Path: path, Mode: FIXTURE_MODE_REPLAY}, "")).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	reflection "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	GRPC_FAULT_SUCCESS      string = "success"
+	GRPC_FAULT_SERVER_ERROR string = "server_error"
+	GRPC_FAULT_WAIT         string = "wait"
+	GRPC_FAULT_DEFINED      string = "defined"
+	GRPC_FAULT_CONTENT_SIZE string = "content_size"
+	GRPC_FAULT_INTERRUPT    string = "interrupt"
+
+	GRPC_SERVICE         string = "enanos.Enanos"
+	GRPC_CONTENT_TYPE    string = "application/grpc"
+	GRPC_STATUS_HEADER   string = "Grpc-Status"
+	GRPC_MESSAGE_HEADER  string = "Grpc-Message"
+	GRPC_CODE_METADATA   string = "X-Enanos-Grpc-Code"
+	GRPC_REFLECTION      string = "grpc.reflection.v1.ServerReflection"
+	GRPC_REFLECTION_V1A  string = "grpc.reflection.v1alpha.ServerReflection"
+	GRPC_REFLECTION_INFO string = "ServerReflectionInfo"
+
+	DEFAULT_GRPC_MESSAGES int = 1
+
+	//The largest field number, which no real message is likely to use, so that
+	//the body of content_size is skipped as an unknown field by any message type
+	grpcFillerField protowire.Number = protowire.MaxValidNumber
+)
+
+var (
+	GRPC_FAULTS []string = []string{GRPC_FAULT_SUCCESS, GRPC_FAULT_SERVER_ERROR, GRPC_FAULT_WAIT, GRPC_FAULT_DEFINED,
+		GRPC_FAULT_CONTENT_SIZE, GRPC_FAULT_INTERRUPT}
+	GRPC_METHODS map[string]string = map[string]string{
+		"Success":     GRPC_FAULT_SUCCESS,
+		"ServerError": GRPC_FAULT_SERVER_ERROR,
+		"Wait":        GRPC_FAULT_WAIT,
+		"Defined":     GRPC_FAULT_DEFINED,
+		"ContentSize": GRPC_FAULT_CONTENT_SIZE,
+		"Interrupt":   GRPC_FAULT_INTERRUPT,
+	}
+	//The gRPC codes for the HTTP codes of the ResponseCodeGenerator
+	GRPC_SERVER_ERROR_CODES map[int]codes.Code = map[int]codes.Code{
+		500: codes.Internal,
+		501: codes.Unimplemented,
+		502: codes.Unavailable,
+		503: codes.Unavailable,
+		504: codes.DeadlineExceeded,
+		505: codes.Unimplemented,
+	}
+)
+
+// GRPCArgs is the configuration file form of the gRPC listener, which is
+// disabled when the port is 0.  Besides the generic enanos.Enanos service it
+// serves the services of the descriptor sets, e.g. from protoc
+// --include_imports --descriptor_set_out, with the fault of the first method
+// entry which matches and otherwise the default fault.  The descriptors are
+// only read when enanos starts.
+type GRPCArgs struct {
+	Port        int              `json:"port"`
+	Descriptors []string         `json:"descriptors,omitempty"`
+	Fault       string           `json:"fault,omitempty"`
+	Code        string           `json:"code,omitempty"`
+	Messages    int              `json:"messages,omitempty"`
+	Methods     []GRPCMethodArgs `json:"methods,omitempty"`
+}
+
+// GRPCMethodArgs is the fault of a method, given as package.Service/Method,
+// or of every method of a service, given as package.Service.
+type GRPCMethodArgs struct {
+	Method   string `json:"method"`
+	Fault    string `json:"fault"`
+	Code     string `json:"code,omitempty"`
+	Messages int    `json:"messages,omitempty"`
+}
+
+func validateGRPC(args GRPCArgs) error {
+	if args.Fault != "" && !ContainsString(GRPC_FAULTS, args.Fault) {
+		return fmt.Errorf("grpc: fault %q is not one of %v", args.Fault, GRPC_FAULTS)
+	}
+	if args.Code != "" {
+		if _, err := parseGRPCCode(args.Code); err != nil {
+			return fmt.Errorf("grpc: %v", err)
+		}
+	}
+	if args.Messages < 0 {
+		return fmt.Errorf("grpc: messages cannot be negative")
+	}
+	for _, method := range args.Methods {
+		if method.Method == "" {
+			return fmt.Errorf("grpc: every method needs a method")
+		}
+		if !ContainsString(GRPC_FAULTS, method.Fault) {
+			return fmt.Errorf("grpc: method %s fault %q is not one of %v", method.Method, method.Fault, GRPC_FAULTS)
+		}
+		if method.Code != "" {
+			if _, err := parseGRPCCode(method.Code); err != nil {
+				return fmt.Errorf("grpc: method %s %v", method.Method, err)
+			}
+		}
+		if method.Messages < 0 {
+			return fmt.Errorf("grpc: method %s messages cannot be negative", method.Method)
+		}
+	}
+	if _, err := NewGRPCServices(args.Descriptors); err != nil {
+		return fmt.Errorf("grpc: %v", err)
+	}
+	return nil
+}
+
+// method returns the fault of the method, named as package.Service/Method.
+func (instance GRPCArgs) method(name string) GRPCMethodArgs {
+	service := strings.SplitN(name, "/", 2)[0]
+	for _, method := range instance.Methods {
+		if method.Method == name || method.Method == service {
+			return instance.withDefaults(method)
+		}
+	}
+	method := GRPCMethodArgs{Method: name, Fault: instance.Fault}
+	if service == GRPC_SERVICE {
+		method.Fault = GRPC_METHODS[strings.TrimPrefix(name, service+"/")]
+	}
+	return instance.withDefaults(method)
+}
+
+func (instance GRPCArgs) withDefaults(method GRPCMethodArgs) GRPCMethodArgs {
+	if method.Fault == "" {
+		method.Fault = GRPC_FAULT_SUCCESS
+	}
+	if method.Code == "" {
+		method.Code = instance.Code
+	}
+	if method.Messages == 0 {
+		method.Messages = instance.Messages
+	}
+	if method.Messages == 0 {
+		method.Messages = DEFAULT_GRPC_MESSAGES
+	}
+	return method
+}
+
+// parseGRPCCode reads a status code by name, e.g. UNAVAILABLE, or number.
+func parseGRPCCode(value string) (codes.Code, error) {
+	var code codes.Code
+	if number, err := strconv.ParseUint(value, 10, 32); err == nil {
+		if number > uint64(codes.Unauthenticated) {
+			return 0, fmt.Errorf("code %q is not a valid gRPC status code", value)
+		}
+		return codes.Code(number), nil
+	}
+	if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(value)))); err != nil {
+		return 0, fmt.Errorf("code %q is not a valid gRPC status code", value)
+	}
+	return code, nil
+}
+
+// GRPCServices holds the descriptors of the services served over gRPC, which
+// are also what the reflection service describes.
+type GRPCServices struct {
+	Files    *protoregistry.Files
+	Services []protoreflect.ServiceDescriptor
+}
+
+// Methods returns the path of every method of the services.
+func (instance *GRPCServices) Methods() []string {
+	paths := []string{}
+	for _, service := range instance.Services {
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			paths = append(paths, fmt.Sprintf("/%s/%s", service.FullName(), methods.Get(i).Name()))
+		}
+	}
+	return paths
+}
+
+func (instance *GRPCServices) register(file *descriptorpb.FileDescriptorProto) error {
+	if _, err := instance.Files.FindFileByPath(file.GetName()); err == nil {
+		return nil
+	}
+	descriptor, err := protodesc.NewFile(file, instance.Files)
+	if err != nil {
+		return err
+	}
+	if err := instance.Files.RegisterFile(descriptor); err != nil {
+		return err
+	}
+	services := descriptor.Services()
+	for i := 0; i < services.Len(); i++ {
+		instance.Services = append(instance.Services, services.Get(i))
+	}
+	return nil
+}
+
+// NewGRPCServices creates the generic service and reads the services of the
+// descriptor set files.
+func NewGRPCServices(descriptors []string) (*GRPCServices, error) {
+	services := &GRPCServices{Files: &protoregistry.Files{}}
+	if err := services.register(enanosFile()); err != nil {
+		return nil, err
+	}
+	for _, path := range descriptors {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the descriptor set %s: %v", path, err)
+		}
+		set := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(data, set); err != nil {
+			return nil, fmt.Errorf("cannot parse the descriptor set %s: %v", path, err)
+		}
+		for _, file := range set.File {
+			if err := services.register(file); err != nil {
+				return nil, fmt.Errorf("cannot load %s from the descriptor set %s: %v", file.GetName(), path, err)
+			}
+		}
+	}
+	return services, nil
+}
+
+// enanosFile describes the generic service, whose methods take and return a
+// message with the body as its first field.
+func enanosFile() *descriptorpb.FileDescriptorProto {
+	message := ".enanos.Message"
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("enanos.proto"),
+		Package: proto.String("enanos"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Message"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("body"),
+				JsonName: proto.String("body"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum(),
+			}},
+		}},
+	}
+	service := &descriptorpb.ServiceDescriptorProto{Name: proto.String("Enanos")}
+	for _, name := range []string{"Success", "ServerError", "Wait", "Defined", "ContentSize", "Interrupt"} {
+		service.Method = append(service.Method, &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(message),
+			OutputType:      proto.String(message),
+			ServerStreaming: proto.Bool(name == "Interrupt"),
+		})
+	}
+	file.Service = []*descriptorpb.ServiceDescriptorProto{service}
+	return file
+}
+
+// GRPCStream reads and writes the length prefixed messages of a gRPC call
+// over its HTTP/2 request and response.
+type GRPCStream struct {
+	writer  http.ResponseWriter
+	request *http.Request
+	started bool
+}
+
+// Recv reads the next message, returning io.EOF once the client has finished
+// sending.
+func (instance *GRPCStream) Recv() ([]byte, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(instance.request.Body, prefix); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("the message prefix was cut short")
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, fmt.Errorf("compressed messages are not supported")
+	}
+	message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(instance.request.Body, message); err != nil {
+		return nil, fmt.Errorf("the message was cut short")
+	}
+	return message, nil
+}
+
+// Send writes a message and flushes it to the client.
+func (instance *GRPCStream) Send(message []byte) error {
+	instance.start()
+	prefix := make([]byte, 5)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	if _, err := instance.writer.Write(append(prefix, message...)); err != nil {
+		return err
+	}
+	if flusher, ok := instance.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Finish ends the call with the status, in the trailers once a message has
+// been sent and otherwise in a trailers only response.
+func (instance *GRPCStream) Finish(code codes.Code, message string) {
+	prefix := ""
+	if instance.started {
+		prefix = http.TrailerPrefix
+	}
+	instance.writer.Header().Set(prefix+GRPC_STATUS_HEADER, strconv.Itoa(int(code)))
+	if message != "" {
+		instance.writer.Header().Set(prefix+GRPC_MESSAGE_HEADER, message)
+	}
+	instance.start()
+}
+
+func (instance *GRPCStream) start() {
+	if instance.started {
+		return
+	}
+	instance.started = true
+	instance.writer.Header().Set("Content-Type", GRPC_CONTENT_TYPE)
+	instance.writer.WriteHeader(http.StatusOK)
+}
+
+func NewGRPCStream(w http.ResponseWriter, r *http.Request) *GRPCStream {
+	return &GRPCStream{writer: w, request: r}
+}
+
+// GRPCHandler serves the methods of the gRPC services with the faults of the
+// live configuration, and the reflection service which describes them.
+type GRPCHandler struct {
+	profile  *LiveProfile
+	services *GRPCServices
+}
+
+// Serve mirrors /success, /server_error, /wait, /defined and /content_size
+// for a gRPC method, or sends some messages and then resets the stream.
+func (instance *GRPCHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), GRPC_CONTENT_TYPE) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	generic := strings.HasPrefix(name, GRPC_SERVICE+"/")
+	method := profile.Config.grpc.method(name)
+	recordFault(r, method.Fault)
+	stream := NewGRPCStream(w, r)
+	if _, err := stream.Recv(); err != nil && err != io.EOF {
+		stream.Finish(codes.Internal, err.Error())
+		return
+	}
+	switch method.Fault {
+	case GRPC_FAULT_SERVER_ERROR:
+		code, ok := GRPC_SERVER_ERROR_CODES[profile.ResponseCodeGenerator.GenerateServerErrorCode()]
+		if !ok {
+			code = codes.Internal
+		}
+		stream.Finish(code, "")
+	case GRPC_FAULT_DEFINED:
+		value := r.Header.Get(GRPC_CODE_METADATA)
+		if value == "" {
+			value = method.Code
+		}
+		code, err := parseGRPCCode(value)
+		if value == "" || err != nil {
+			stream.Finish(codes.InvalidArgument, "a valid code must be configured or sent as "+strings.ToLower(GRPC_CODE_METADATA))
+			return
+		}
+		stream.Finish(code, "")
+	case GRPC_FAULT_CONTENT_SIZE:
+		stream.Send(grpcMessage(generic, profile.ResponseBodyGenerator.Generate()))
+		stream.Finish(codes.OK, "")
+	case GRPC_FAULT_INTERRUPT:
+		for i := 0; i < method.Messages; i++ {
+			stream.Send(grpcMessage(generic, profile.Config.render(profile.Config.content, r, nil)))
+		}
+		panic(http.ErrAbortHandler)
+	default:
+		if method.Fault == GRPC_FAULT_WAIT {
+			snoozeFor(r, profile.Snoozer)
+		}
+		stream.Send(grpcMessage(generic, profile.Config.render(profile.Config.content, r, nil)))
+		stream.Finish(codes.OK, "")
+	}
+}
+
+// grpcMessage encodes the body as the first field of the generic message and
+// otherwise as an unknown field, so that any message type can decode it.
+func grpcMessage(generic bool, body string) []byte {
+	if !generic && body == "" {
+		return []byte{}
+	}
+	field := grpcFillerField
+	if generic {
+		field = 1
+	}
+	return protowire.AppendString(protowire.AppendTag(nil, field, protowire.BytesType), body)
+}
+
+// Reflect answers the requests of the reflection service until the client
+// has finished sending them.
+func (instance *GRPCHandler) Reflect(w http.ResponseWriter, r *http.Request) {
+	recordFault(r, "reflection")
+	stream := NewGRPCStream(w, r)
+	for {
+		data, err := stream.Recv()
+		if err == io.EOF {
+			stream.Finish(codes.OK, "")
+			return
+		}
+		request := &reflection.ServerReflectionRequest{}
+		if err == nil {
+			err = proto.Unmarshal(data, request)
+		}
+		if err != nil {
+			stream.Finish(codes.Internal, err.Error())
+			return
+		}
+		response, _ := proto.Marshal(instance.reflect(request))
+		if err := stream.Send(response); err != nil {
+			return
+		}
+	}
+}
+
+func (instance *GRPCHandler) reflect(request *reflection.ServerReflectionRequest) *reflection.ServerReflectionResponse {
+	response := &reflection.ServerReflectionResponse{ValidHost: request.Host, OriginalRequest: request}
+	var file protoreflect.FileDescriptor
+	var err error
+	switch original := request.MessageRequest.(type) {
+	case *reflection.ServerReflectionRequest_ListServices:
+		list := &reflection.ListServiceResponse{}
+		for _, service := range instance.services.Services {
+			list.Service = append(list.Service, &reflection.ServiceResponse{Name: string(service.FullName())})
+		}
+		list.Service = append(list.Service, &reflection.ServiceResponse{Name: GRPC_REFLECTION})
+		response.MessageResponse = &reflection.ServerReflectionResponse_ListServicesResponse{ListServicesResponse: list}
+		return response
+	case *reflection.ServerReflectionRequest_FileByFilename:
+		file, err = instance.services.Files.FindFileByPath(original.FileByFilename)
+	case *reflection.ServerReflectionRequest_FileContainingSymbol:
+		var descriptor protoreflect.Descriptor
+		descriptor, err = instance.services.Files.FindDescriptorByName(protoreflect.FullName(original.FileContainingSymbol))
+		if err == nil {
+			file = descriptor.ParentFile()
+		}
+	default:
+		err = fmt.Errorf("extensions are not supported")
+	}
+	if err != nil {
+		response.MessageResponse = &reflection.ServerReflectionResponse_ErrorResponse{ErrorResponse: &reflection.ErrorResponse{
+			ErrorCode:    int32(codes.NotFound),
+			ErrorMessage: err.Error(),
+		}}
+		return response
+	}
+	response.MessageResponse = &reflection.ServerReflectionResponse_FileDescriptorResponse{FileDescriptorResponse: &reflection.FileDescriptorResponse{
+		FileDescriptorProto: encodeFiles(file, map[string]bool{}),
+	}}
+	return response
+}
+
+// encodeFiles encodes the file and the files it imports, once each.
+func encodeFiles(file protoreflect.FileDescriptor, seen map[string]bool) [][]byte {
+	if seen[file.Path()] {
+		return nil
+	}
+	seen[file.Path()] = true
+	data, _ := proto.Marshal(protodesc.ToFileDescriptorProto(file))
+	encoded := [][]byte{data}
+	imports := file.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		encoded = append(encoded, encodeFiles(imports.Get(i).FileDescriptor, seen)...)
+	}
+	return encoded
+}
+
+func NewGRPCHandler(profile *LiveProfile, services *GRPCServices) *GRPCHandler {
+	return &GRPCHandler{
+		profile:  profile,
+		services: services,
+	}
+}
+
+// GRPCServer serves the gRPC services over h2c on their own port.
+type GRPCServer struct {
+	Config    Configuration
+	Profile   *LiveProfile
+	Observers []RequestObserver
+	Server    *HTTPServer
+}
+
+func (instance *GRPCServer) Start() {
+	config := instance.Config
+	if config.grpc.Port == 0 {
+		return
+	}
+	services, err := NewGRPCServices(config.grpc.Descriptors)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("Cannot start the gRPC server: %v", err))
+		return
+	}
+	instance.Server = NewHTTPServer(config.grpc.Port, config.host)
+	instance.Server.HTTP2 = NewHTTP2Server(instance.Profile)
+
+	handler := NewGRPCHandler(instance.Profile, services)
+	//HTTP routes, such as a /* fallback, would answer gRPC calls without a
+	//grpc-status
+	router := NewEndpointRouter(instance.Profile, instance.Observers...)
+	for _, path := range services.Methods() {
+		router.HandleFunc(path, handler.Serve)
+	}
+	for _, service := range []string{GRPC_REFLECTION, GRPC_REFLECTION_V1A} {
+		router.HandleFunc(fmt.Sprintf("/%s/%s", service, GRPC_REFLECTION_INFO), handler.Reflect)
+	}
+	instance.Server.Handle("/", router)
+
+	if err := instance.Server.Start(); err != nil {
+		fmt.Println(fmt.Sprintf("Cannot start the gRPC server: %v", err))
+	}
+}
+
+func (instance *GRPCServer) Stop() {
+	if instance.Server != nil {
+		instance.Server.Stop()
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflection "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var _ = Describe("GRPC", func() {
+
+	const GRPC_PORT int = 8445
+
+	var profile *LiveProfile
+	var recorder *RequestRecorder
+	var server *GRPCServer
+	var conn *grpc.ClientConn
+	var descriptors string
+
+	useGRPC := func(args GRPCArgs) {
+		config := Configuration{}
+		config.content = "hello"
+		config.grpc = args
+		generator := NewFakeResponseCodeGenerator()
+		generator.Use(503)
+		profile.Swap(Profile{
+			Config:                config,
+			ResponseBodyGenerator: NewMaxResponseBodyGenerator(64),
+			ResponseCodeGenerator: generator,
+			Snoozer:               &MaxSnoozer{Max: 100 * time.Millisecond},
+		})
+	}
+
+	call := func(ctx context.Context, method string) (*wrapperspb.BytesValue, error) {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		reply := &wrapperspb.BytesValue{}
+		err := conn.Invoke(ctx, method, &wrapperspb.BytesValue{}, reply)
+		return reply, err
+	}
+
+	writeDescriptors := func() string {
+		file := &descriptorpb.FileDescriptorProto{
+			Name:        proto.String("shop.proto"),
+			Package:     proto.String("shop"),
+			Syntax:      proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Order")}},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("Orders"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String("Create"),
+					InputType:  proto.String(".shop.Order"),
+					OutputType: proto.String(".shop.Order"),
+				}},
+			}},
+		}
+		data, _ := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+		output, _ := ioutil.TempFile("", "enanos-grpc")
+		output.Write(data)
+		output.Close()
+		return output.Name()
+	}
+
+	BeforeEach(func() {
+		descriptors = writeDescriptors()
+		config := Configuration{}
+		config.host = "localhost"
+		config.grpc = GRPCArgs{Port: GRPC_PORT, Descriptors: []string{descriptors}}
+		profile = NewLiveProfile(Profile{Config: config})
+		useGRPC(GRPCArgs{})
+		recorder = NewRequestRecorder(10)
+		server = &GRPCServer{Config: config, Profile: profile, Observers: []RequestObserver{recorder}}
+		server.Start()
+		conn, _ = grpc.NewClient(fmt.Sprintf("localhost:%d", GRPC_PORT), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Stop()
+		os.Remove(descriptors)
+	})
+
+	It("returns the content from Success", func() {
+		reply, err := call(context.Background(), "/enanos.Enanos/Success")
+		Expect(err).To(BeNil())
+		Expect(string(reply.Value)).To(Equal("hello"))
+	})
+
+	It("serves the gRPC methods ahead of a catch-all route", func() {
+		fallback, err := NewRoute(RouteArgs{Path: "/*", Priority: -1, Behaviours: []BehaviourArgs{{Status: 418}}})
+		Expect(err).To(BeNil())
+		current := profile.Current()
+		current.Config.routes = []*Route{fallback}
+		profile.Swap(current)
+		reply, err := call(context.Background(), "/enanos.Enanos/Success")
+		Expect(err).To(BeNil())
+		Expect(string(reply.Value)).To(Equal("hello"))
+		_, err = call(context.Background(), "/enanos.Enanos/ServerError")
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	})
+
+	It("returns the gRPC code for the server error from ServerError", func() {
+		_, err := call(context.Background(), "/enanos.Enanos/ServerError")
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	})
+
+	It("snoozes before replying from Wait", func() {
+		start := time.Now()
+		_, err := call(context.Background(), "/enanos.Enanos/Wait")
+		Expect(err).To(BeNil())
+		Expect(time.Since(start) >= 100*time.Millisecond).To(BeTrue())
+	})
+
+	It("returns the code from the metadata or configuration from Defined", func() {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-enanos-grpc-code", "NOT_FOUND")
+		_, err := call(ctx, "/enanos.Enanos/Defined")
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		_, err = call(context.Background(), "/enanos.Enanos/Defined")
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		useGRPC(GRPCArgs{Code: "14"})
+		_, err = call(context.Background(), "/enanos.Enanos/Defined")
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	})
+
+	It("returns a generated body from ContentSize", func() {
+		reply, err := call(context.Background(), "/enanos.Enanos/ContentSize")
+		Expect(err).To(BeNil())
+		Expect(len(reply.Value)).To(Equal(64))
+	})
+
+	It("resets the stream after the messages from Interrupt", func() {
+		useGRPC(GRPCArgs{Messages: 2})
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/enanos.Enanos/Interrupt")
+		Expect(err).To(BeNil())
+		stream.SendMsg(&wrapperspb.BytesValue{})
+		stream.CloseSend()
+		received := 0
+		for {
+			if err = stream.RecvMsg(&wrapperspb.BytesValue{}); err != nil {
+				break
+			}
+			received++
+		}
+		Expect(received).To(Equal(2))
+		Expect(status.Code(err)).To(Equal(codes.Internal))
+		Eventually(func() []RecordedRequest {
+			return recorder.Requests(RequestFilter{Fault: GRPC_FAULT_INTERRUPT})
+		}).Should(ConsistOf(HaveField("Pending", false)))
+	})
+
+	It("serves the methods of the descriptor sets with the configured faults", func() {
+		_, err := call(context.Background(), "/shop.Orders/Create")
+		Expect(err).To(BeNil())
+		useGRPC(GRPCArgs{Methods: []GRPCMethodArgs{{Method: "shop.Orders", Fault: GRPC_FAULT_DEFINED, Code: "RESOURCE_EXHAUSTED"}}})
+		_, err = call(context.Background(), "/shop.Orders/Create")
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		useGRPC(GRPCArgs{Methods: []GRPCMethodArgs{{Method: "shop.Orders/Create", Fault: GRPC_FAULT_CONTENT_SIZE}}})
+		_, err = call(context.Background(), "/shop.Orders/Create")
+		Expect(err).To(BeNil())
+	})
+
+	It("does not serve unknown methods", func() {
+		_, err := call(context.Background(), "/shop.Orders/Delete")
+		Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+	})
+
+	It("lists and describes the services through reflection", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		stream, err := reflection.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		Expect(err).To(BeNil())
+		stream.Send(&reflection.ServerReflectionRequest{MessageRequest: &reflection.ServerReflectionRequest_ListServices{}})
+		response, err := stream.Recv()
+		Expect(err).To(BeNil())
+		names := []string{}
+		for _, service := range response.GetListServicesResponse().Service {
+			names = append(names, service.Name)
+		}
+		Expect(names).To(ConsistOf(GRPC_SERVICE, "shop.Orders", GRPC_REFLECTION))
+		stream.Send(&reflection.ServerReflectionRequest{MessageRequest: &reflection.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "shop.Orders"}})
+		response, err = stream.Recv()
+		Expect(err).To(BeNil())
+		file := &descriptorpb.FileDescriptorProto{}
+		proto.Unmarshal(response.GetFileDescriptorResponse().FileDescriptorProto[0], file)
+		Expect(file.GetName()).To(Equal("shop.proto"))
+		stream.CloseSend()
+	})
+
+	It("rejects an unknown fault or code", func() {
+		Expect(validateGRPC(GRPCArgs{Fault: "broken"})).ToNot(BeNil())
+		Expect(validateGRPC(GRPCArgs{Code: "BROKEN"})).ToNot(BeNil())
+		Expect(validateGRPC(GRPCArgs{Descriptors: []string{"missing.pb"}})).ToNot(BeNil())
+	})
+})
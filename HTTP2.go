@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	HTTP2_FAULT_NONE           string = "none"
+	HTTP2_FAULT_RST_STREAM     string = "rst_stream"
+	HTTP2_FAULT_GOAWAY         string = "goaway"
+	HTTP2_FAULT_TINY_WINDOW    string = "tiny_window"
+	HTTP2_FAULT_SETTINGS_DELAY string = "settings_delay"
+
+	HTTP2_DEFAULT_WINDOW int    = 16
+	HTTP2_DEFAULT_DELAY  string = "1s"
+
+	http2MaxFrameSize    uint32 = 1<<24 - 1
+	http2MinMaxFrameSize int    = 1 << 14
+)
+
+var (
+	HTTP2_FAULTS []string = []string{HTTP2_FAULT_NONE, HTTP2_FAULT_RST_STREAM, HTTP2_FAULT_GOAWAY, HTTP2_FAULT_TINY_WINDOW, HTTP2_FAULT_SETTINGS_DELAY}
+)
+
+// HTTP2Args is the configuration file form of the faults injected into every
+// HTTP/2 connection.  The error code is the name or number of the code sent
+// with rst_stream, by default INTERNAL_ERROR, or goaway, by default NO_ERROR.
+// The window is the size in bytes of the stream flow-control window advertised
+// for tiny_window, while the connection window keeps its default, and the
+// delay is how long each SETTINGS frame is held back for settings_delay.
+type HTTP2Args struct {
+	Fault     string `json:"fault,omitempty"`
+	ErrorCode string `json:"errorcode,omitempty"`
+	Window    int    `json:"window,omitempty"`
+	Delay     string `json:"delay,omitempty"`
+}
+
+func validateHTTP2(args HTTP2Args) error {
+	if args.Fault != "" && !ContainsString(HTTP2_FAULTS, args.Fault) {
+		return fmt.Errorf("http2: fault %q is not one of %v", args.Fault, HTTP2_FAULTS)
+	}
+	if args.ErrorCode != "" {
+		if _, err := parseErrCode(args.ErrorCode); err != nil {
+			return fmt.Errorf("http2: %v", err)
+		}
+	}
+	if args.Window < 0 {
+		return fmt.Errorf("http2: window cannot be negative")
+	}
+	if args.Delay != "" {
+		if _, err := time.ParseDuration(args.Delay); err != nil {
+			return fmt.Errorf("http2: delay: cannot parse time from %q", args.Delay)
+		}
+	}
+	return nil
+}
+
+// parseErrCode reads an HTTP/2 error code from its name, e.g. REFUSED_STREAM,
+// or its number.
+func parseErrCode(value string) (http2.ErrCode, error) {
+	if number, err := strconv.ParseUint(value, 0, 32); err == nil {
+		return http2.ErrCode(number), nil
+	}
+	for code := http2.ErrCodeNo; code <= http2.ErrCodeHTTP11Required; code++ {
+		if strings.EqualFold(code.String(), value) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not an HTTP/2 error code", value)
+}
+
+// HTTP2Server serves HTTP/2 connections, negotiated over TLS or sent as h2c
+// with prior knowledge, with the faults from the live configuration.
+type HTTP2Server struct {
+	Profile *LiveProfile
+}
+
+// ServeConn serves one HTTP/2 connection until it is closed.
+func (instance *HTTP2Server) ServeConn(conn net.Conn, handler http.Handler) {
+	args := instance.Profile.Current().Config.http2
+	conn.SetDeadline(time.Time{})
+	server := &http2.Server{}
+	switch args.Fault {
+	case HTTP2_FAULT_TINY_WINDOW:
+		window := args.Window
+		if window == 0 {
+			window = HTTP2_DEFAULT_WINDOW
+		}
+		//x/net does not allow a connection window below the 65535 bytes
+		//every connection starts with, so only the streams' windows shrink
+		server.MaxUploadBufferPerStream = int32(window)
+	case HTTP2_FAULT_RST_STREAM, HTTP2_FAULT_GOAWAY, HTTP2_FAULT_SETTINGS_DELAY:
+		client, upstream := net.Pipe()
+		go NewHTTP2Relay(args, conn, upstream).Run()
+		conn = client
+	}
+	server.ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+}
+
+// ServeTLS is the TLSNextProto for h2.
+func (instance *HTTP2Server) ServeTLS(server *http.Server, conn *tls.Conn, handler http.Handler) {
+	instance.ServeConn(conn, handler)
+}
+
+// H2C takes over the connections which start with the HTTP/2 client preface
+// and passes every other request on to the handler.
+func (instance *HTTP2Server) H2C(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PRI" || r.RequestURI != "*" || r.ProtoMajor != 2 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "h2c is not supported", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		conn, buffer, err := hijacker.Hijack()
+		if err != nil {
+			return
+		}
+		//The request line and blank line of the preface have been read
+		rest := make([]byte, len("SM\r\n\r\n"))
+		if _, err := io.ReadFull(buffer, rest); err != nil || string(rest) != "SM\r\n\r\n" {
+			conn.Close()
+			return
+		}
+		instance.ServeConn(&prefacedConn{conn, io.MultiReader(strings.NewReader(http2.ClientPreface), buffer)}, handler)
+	})
+}
+
+func NewHTTP2Server(profile *LiveProfile) *HTTP2Server {
+	return &HTTP2Server{profile}
+}
+
+// prefacedConn replays the client preface, and anything else already read
+// from the connection, before reading from the connection itself.
+type prefacedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (instance *prefacedConn) Read(data []byte) (int, error) {
+	return instance.reader.Read(data)
+}
+
+// lockedWriter stops the frames written by the relay interleaving with the
+// frames it copies from the client.
+type lockedWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (instance *lockedWriter) Write(data []byte) (int, error) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.writer.Write(data)
+}
+
+// HTTP2Relay sits between the client and the server of an HTTP/2 connection.
+// It copies the frames from the client as they are and re-encodes the frames
+// from the server, changing them to inject the fault.  The headers are decoded
+// and encoded again so that dropping a response does not corrupt the HPACK
+// state of the client.
+type HTTP2Relay struct {
+	Args     HTTP2Args
+	code     http2.ErrCode
+	delay    time.Duration
+	client   net.Conn
+	server   net.Conn
+	upstream *lockedWriter
+	framer   *http2.Framer
+	encoder  *hpack.Encoder
+	block    bytes.Buffer
+	reset    map[uint32]bool
+}
+
+// Run relays the connection until either side closes it.
+func (instance *HTTP2Relay) Run() {
+	defer instance.close()
+	go func() {
+		defer instance.close()
+		instance.copyFromClient()
+	}()
+	for {
+		frame, err := instance.framer.ReadFrame()
+		if err != nil {
+			return
+		}
+		if err := instance.relay(frame); err != nil {
+			return
+		}
+	}
+}
+
+func (instance *HTTP2Relay) close() {
+	instance.client.Close()
+	instance.server.Close()
+}
+
+// copyFromClient copies the preface and then one whole frame at a time.
+func (instance *HTTP2Relay) copyFromClient() {
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(instance.client, preface); err != nil {
+		return
+	}
+	if _, err := instance.upstream.Write(preface); err != nil {
+		return
+	}
+	header := make([]byte, 9)
+	for {
+		if _, err := io.ReadFull(instance.client, header); err != nil {
+			return
+		}
+		length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+		frame := make([]byte, 9+length)
+		copy(frame, header)
+		if _, err := io.ReadFull(instance.client, frame[9:]); err != nil {
+			return
+		}
+		if _, err := instance.upstream.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// relay writes a frame from the server to the client.
+func (instance *HTTP2Relay) relay(frame http2.Frame) error {
+	streamID := frame.Header().StreamID
+	if instance.reset[streamID] {
+		if data, ok := frame.(*http2.DataFrame); ok && data.Length > 0 {
+			//Give the server back the window it spent on the dropped data
+			return http2.NewFramer(instance.upstream, nil).WriteWindowUpdate(0, data.Length)
+		}
+		return nil
+	}
+	switch frame := frame.(type) {
+	case *http2.MetaHeadersFrame:
+		return instance.headers(frame)
+	case *http2.DataFrame:
+		return instance.framer.WriteData(streamID, frame.StreamEnded(), frame.Data())
+	case *http2.SettingsFrame:
+		if instance.Args.Fault == HTTP2_FAULT_SETTINGS_DELAY {
+			time.Sleep(instance.delay)
+		}
+		if frame.IsAck() {
+			return instance.framer.WriteSettingsAck()
+		}
+		settings := []http2.Setting{}
+		frame.ForeachSetting(func(setting http2.Setting) error {
+			settings = append(settings, setting)
+			return nil
+		})
+		return instance.framer.WriteSettings(settings...)
+	case *http2.PingFrame:
+		return instance.framer.WritePing(frame.IsAck(), frame.Data)
+	case *http2.WindowUpdateFrame:
+		return instance.framer.WriteWindowUpdate(streamID, frame.Increment)
+	case *http2.RSTStreamFrame:
+		return instance.framer.WriteRSTStream(streamID, frame.ErrCode)
+	case *http2.GoAwayFrame:
+		return instance.framer.WriteGoAway(frame.LastStreamID, frame.ErrCode, frame.DebugData())
+	case *http2.PriorityFrame:
+		return instance.framer.WritePriority(streamID, frame.PriorityParam)
+	case *http2.UnknownFrame:
+		return instance.framer.WriteRawFrame(frame.Type, frame.Flags, streamID, frame.Payload())
+	}
+	return nil
+}
+
+// headers injects the rst_stream and goaway faults in place of, or after, the
+// headers of each response.
+func (instance *HTTP2Relay) headers(frame *http2.MetaHeadersFrame) error {
+	streamID := frame.Header().StreamID
+	if instance.Args.Fault == HTTP2_FAULT_RST_STREAM {
+		instance.reset[streamID] = true
+		http2.NewFramer(instance.upstream, nil).WriteRSTStream(streamID, http2.ErrCodeCancel)
+		return instance.framer.WriteRSTStream(streamID, instance.code)
+	}
+	instance.block.Reset()
+	for _, field := range frame.Fields {
+		instance.encoder.WriteField(field)
+	}
+	block := instance.block.Bytes()
+	first := block
+	if len(first) > http2MinMaxFrameSize {
+		first = first[:http2MinMaxFrameSize]
+	}
+	block = block[len(first):]
+	err := instance.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: first,
+		EndStream:     frame.StreamEnded(),
+		EndHeaders:    len(block) == 0,
+	})
+	for err == nil && len(block) > 0 {
+		next := block
+		if len(next) > http2MinMaxFrameSize {
+			next = next[:http2MinMaxFrameSize]
+		}
+		block = block[len(next):]
+		err = instance.framer.WriteContinuation(streamID, len(block) == 0, next)
+	}
+	if err != nil {
+		return err
+	}
+	if instance.Args.Fault == HTTP2_FAULT_GOAWAY {
+		instance.framer.WriteGoAway(streamID, instance.code, nil)
+		return io.EOF
+	}
+	return nil
+}
+
+func NewHTTP2Relay(args HTTP2Args, client net.Conn, server net.Conn) *HTTP2Relay {
+	relay := &HTTP2Relay{
+		Args:     args,
+		code:     http2.ErrCodeInternal,
+		delay:    parseTime(HTTP2_DEFAULT_DELAY),
+		client:   client,
+		server:   server,
+		upstream: &lockedWriter{writer: server},
+		reset:    map[uint32]bool{},
+	}
+	if args.Fault == HTTP2_FAULT_GOAWAY {
+		relay.code = http2.ErrCodeNo
+	}
+	if args.ErrorCode != "" {
+		relay.code, _ = parseErrCode(args.ErrorCode)
+	}
+	if args.Delay != "" {
+		relay.delay = parseTime(args.Delay)
+	}
+	relay.framer = http2.NewFramer(client, server)
+	relay.framer.SetMaxReadFrameSize(http2MaxFrameSize)
+	relay.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	relay.encoder = hpack.NewEncoder(&relay.block)
+	return relay
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+)
+
+var _ = Describe("HTTP2", func() {
+
+	const H2C_PORT int = 8444
+
+	var profile *LiveProfile
+	var server *HTTPServer
+	var transport *http2.Transport
+
+	useHTTP2 := func(args HTTP2Args) {
+		config := Configuration{}
+		config.content = "hello"
+		config.http2 = args
+		profile.Swap(Profile{Config: config})
+	}
+
+	send := func(method string, body string) (*http.Response, error) {
+		client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+		request, _ := http.NewRequest(method, fmt.Sprintf("http://localhost:%d/success", H2C_PORT), strings.NewReader(body))
+		return client.Do(request)
+	}
+
+	BeforeEach(func() {
+		profile = NewLiveProfile(Profile{})
+		useHTTP2(HTTP2Args{})
+		server = NewHTTPServer(H2C_PORT, "localhost")
+		server.HTTP2 = NewHTTP2Server(profile)
+		router := NewRouter(profile)
+		for key, value := range endpoints(NewDefultHttpHandler(profile)) {
+			router.HandleFunc(key, value)
+		}
+		server.Handle("/", router)
+		server.Start()
+		transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network string, address string, config *tls.Config) (net.Conn, error) {
+				return net.Dial(network, address)
+			},
+		}
+	})
+
+	AfterEach(func() {
+		transport.CloseIdleConnections()
+		server.Stop()
+	})
+
+	It("serves the endpoints over h2c with prior knowledge", func() {
+		response, err := send("GET", "")
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		Expect(response.ProtoMajor).To(Equal(2))
+		Expect(string(body)).To(Equal("hello"))
+	})
+
+	It("still serves HTTP/1.1", func() {
+		response, err := http.Get(fmt.Sprintf("http://localhost:%d/success", H2C_PORT))
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		Expect(response.ProtoMajor).To(Equal(1))
+	})
+
+	It("resets each stream with the error code", func() {
+		useHTTP2(HTTP2Args{Fault: HTTP2_FAULT_RST_STREAM, ErrorCode: "ENHANCE_YOUR_CALM"})
+		_, err := send("GET", "")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("ENHANCE_YOUR_CALM"))
+	})
+
+	It("sends a GOAWAY after the response headers", func() {
+		useHTTP2(HTTP2Args{Fault: HTTP2_FAULT_GOAWAY})
+		response, err := send("GET", "")
+		if err == nil {
+			_, err = ioutil.ReadAll(response.Body)
+			response.Body.Close()
+		}
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("delays the SETTINGS frames", func() {
+		useHTTP2(HTTP2Args{Fault: HTTP2_FAULT_SETTINGS_DELAY, Delay: "200ms"})
+		start := time.Now()
+		response, err := send("GET", "")
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(time.Since(start) >= 200*time.Millisecond).To(BeTrue())
+	})
+
+	It("accepts uploads through a tiny flow-control window", func() {
+		useHTTP2(HTTP2Args{Fault: HTTP2_FAULT_TINY_WINDOW, Window: 8})
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", H2C_PORT))
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte(http2.ClientPreface))
+		framer := http2.NewFramer(conn, conn)
+		Expect(framer.WriteSettings()).To(BeNil())
+		frame, err := framer.ReadFrame()
+		Expect(err).To(BeNil())
+		settings, ok := frame.(*http2.SettingsFrame)
+		Expect(ok).To(BeTrue())
+		window, ok := settings.Value(http2.SettingInitialWindowSize)
+		Expect(ok).To(BeTrue())
+		Expect(window).To(Equal(uint32(8)))
+
+		//The window only applies once the client has the SETTINGS
+		response, err := send("GET", "")
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		response, err = send("POST", strings.Repeat("a", 256))
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("reads error codes by name or number", func() {
+		code, err := parseErrCode("refused_stream")
+		Expect(err).To(BeNil())
+		Expect(code).To(Equal(http2.ErrCodeRefusedStream))
+		code, _ = parseErrCode("8")
+		Expect(code).To(Equal(http2.ErrCodeCancel))
+		Expect(validateHTTP2(HTTP2Args{ErrorCode: "BROKEN"})).ToNot(BeNil())
+	})
+})
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 )
 
+// HttpResponseWriterRecorder notes the response code and the number of bytes
+// of the body written through it.
 type HttpResponseWriterRecorder struct {
 	Code   int
+	Bytes  int
 	writer http.ResponseWriter
 }
 
@@ -18,20 +22,33 @@ func (instance *HttpResponseWriterRecorder) Header() http.Header {
 }
 
 func (instance *HttpResponseWriterRecorder) Write(data []byte) (int, error) {
-	return instance.writer.Write(data)
+	if instance.Code == 0 {
+		instance.Code = http.StatusOK
+	}
+	written, err := instance.writer.Write(data)
+	instance.Bytes += written
+	return written, err
 }
 
 func (instance *HttpResponseWriterRecorder) WriteHeader(code int) {
-	instance.Code = code
+	if instance.Code == 0 {
+		instance.Code = code
+	}
 	instance.writer.WriteHeader(code)
 }
 
-func monitorTime(handler http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	writer := &HttpResponseWriterRecorder{0, w}
-	handler(writer, r)
-	elapsed := time.Since(start)
-	fmt.Println(fmt.Sprintf("%-15s%-4d%-5s%s", elapsed, writer.Code, r.Method, r.URL.Path))
+func (instance *HttpResponseWriterRecorder) Flush() {
+	if flusher, ok := instance.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (instance *HttpResponseWriterRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := instance.writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the connection cannot be hijacked")
+	}
+	return hijacker.Hijack()
 }
 
 type HttpHandler interface {
@@ -42,77 +59,68 @@ type HttpHandler interface {
 	Redirect(w http.ResponseWriter, r *http.Request)
 	Client_Error(w http.ResponseWriter, r *http.Request)
 	Defined(w http.ResponseWriter, r *http.Request)
-}
-
-type VerboseHttpHandler struct {
-	handler HttpHandler
-}
-
-func (instance *VerboseHttpHandler) Success(w http.ResponseWriter, r *http.Request) {
-	monitorTime(instance.handler.Success, w, r)
-}
-func (instance *VerboseHttpHandler) Server_Error(w http.ResponseWriter, r *http.Request) {
-	monitorTime(instance.handler.Server_Error, w, r)
-}
-func (instance *VerboseHttpHandler) Content_Size(w http.ResponseWriter, r *http.Request) {
-	monitorTime(instance.handler.Content_Size, w, r)
-}
-func (instance *VerboseHttpHandler) Wait(w http.ResponseWriter, r *http.Request) {
-	monitorTime(instance.handler.Wait, w, r)
-}
-func (instance *VerboseHttpHandler) Redirect(w http.ResponseWriter, r *http.Request) {
-	monitorTime(instance.handler.Redirect, w, r)
-}
-func (instance *VerboseHttpHandler) Client_Error(w http.ResponseWriter, r *http.Request) {
-	monitorTime(instance.handler.Client_Error, w, r)
-}
-func (instance *VerboseHttpHandler) Defined(w http.ResponseWriter, r *http.Request) {
-	monitorTime(instance.handler.Defined, w, r)
+	Chaos(w http.ResponseWriter, r *http.Request)
+	Connection_Reset(w http.ResponseWriter, r *http.Request)
+	Partial_Headers(w http.ResponseWriter, r *http.Request)
+	Partial_Body(w http.ResponseWriter, r *http.Request)
+	Hang(w http.ResponseWriter, r *http.Request)
+	No_Content_Length(w http.ResponseWriter, r *http.Request)
+	Slow_Body(w http.ResponseWriter, r *http.Request)
+	Fail_First(w http.ResponseWriter, r *http.Request)
+	WebSocket_Echo(w http.ResponseWriter, r *http.Request)
+	WebSocket_Script(w http.ResponseWriter, r *http.Request)
+	Stream(w http.ResponseWriter, r *http.Request)
 }
 
 type DefaultEnanosHttpHandlerFactory struct {
-	responseBodyGenerator ResponseBodyGenerator
-	responseCodeGenerator ResponseCodeGenerator
-	snoozer               Snoozer
-	config                Configuration
+	profile  *LiveProfile
+	attempts *AttemptTracker
+	random   Random
 }
 
-func setHeaders(w http.ResponseWriter, config Configuration) {
+// setHeaders sets the configured response headers, rendering any templates
+// in their values for the request.
+func setHeaders(w http.ResponseWriter, r *http.Request, config Configuration) {
 	for _, responseHeader := range config.headers {
-		split := strings.Split(responseHeader, ":")
-		w.Header().Set(split[0], split[1])
+		split := strings.SplitN(responseHeader, ":", 2)
+		w.Header().Set(split[0], config.render(split[1], r, nil))
 	}
 }
 
 func (instance *DefaultEnanosHttpHandlerFactory) Success(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w, instance.config)
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(instance.config.content))
+	w.Write([]byte(profile.Config.render(profile.Config.content, r, nil)))
 }
 
 func (instance *DefaultEnanosHttpHandlerFactory) Server_Error(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w, instance.config)
-	code := instance.responseCodeGenerator.GenerateServerErrorCode()
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	code := profile.ResponseCodeGenerator.GenerateServerErrorCode()
 	w.WriteHeader(code)
 }
 
 func (instance *DefaultEnanosHttpHandlerFactory) Content_Size(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w, instance.config)
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	data := generateContent(profile.ResponseBodyGenerator, profile.Config.body, w.Header().Get("Content-Type"))
 	w.WriteHeader(http.StatusOK)
-	data := instance.responseBodyGenerator.Generate()
-	w.Write([]byte(data))
+	w.Write(data)
 }
 
 func (instance *DefaultEnanosHttpHandlerFactory) Wait(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w, instance.config)
-	instance.snoozer.Snooze()
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	snoozeFor(r, profile.Snoozer)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(instance.config.content))
+	w.Write([]byte(profile.Config.render(profile.Config.content, r, nil)))
 }
 
 func (instance *DefaultEnanosHttpHandlerFactory) Redirect(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w, instance.config)
-	code := instance.responseCodeGenerator.GenerateRedirectionCode()
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	code := profile.ResponseCodeGenerator.GenerateRedirectionCode()
 	if code == 301 || code == 302 || code == 303 || code == 307 {
 		existingHeader := w.Header().Get("location")
 		if existingHeader == "" {
@@ -123,13 +131,15 @@ func (instance *DefaultEnanosHttpHandlerFactory) Redirect(w http.ResponseWriter,
 }
 
 func (instance *DefaultEnanosHttpHandlerFactory) Client_Error(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w, instance.config)
-	code := instance.responseCodeGenerator.GenerateClientErrorCode()
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	code := profile.ResponseCodeGenerator.GenerateClientErrorCode()
 	w.WriteHeader(code)
 }
 
 func (instance *DefaultEnanosHttpHandlerFactory) Defined(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w, instance.config)
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
 	code := r.URL.Query().Get("code")
 	if code != "" {
 		intCode, err := strconv.Atoi(code)
@@ -143,6 +153,142 @@ func (instance *DefaultEnanosHttpHandlerFactory) Defined(w http.ResponseWriter,
 	}
 }
 
-func NewDefultHttpHandler(responseBodyGenerator ResponseBodyGenerator, responseCodeGenerator ResponseCodeGenerator, snoozer Snoozer, config Configuration) *DefaultEnanosHttpHandlerFactory {
-	return &DefaultEnanosHttpHandlerFactory{responseBodyGenerator, responseCodeGenerator, snoozer, config}
+func (instance *DefaultEnanosHttpHandlerFactory) Chaos(w http.ResponseWriter, r *http.Request) {
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	if profile.Chaos == nil {
+		http.Error(w, "chaos is not configured", http.StatusInternalServerError)
+		return
+	}
+	exchange := NewExchange(w, r, nil, profile)
+	profile.Chaos.Apply(exchange)
+	exchange.Respond()
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) Connection_Reset(w http.ResponseWriter, r *http.Request) {
+	instance.fault(FAULT_CONNECTION_RESET, w, r)
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) Partial_Headers(w http.ResponseWriter, r *http.Request) {
+	instance.fault(FAULT_PARTIAL_HEADERS, w, r)
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) Partial_Body(w http.ResponseWriter, r *http.Request) {
+	instance.fault(FAULT_PARTIAL_BODY, w, r)
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) Hang(w http.ResponseWriter, r *http.Request) {
+	instance.fault(FAULT_HANG, w, r)
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) No_Content_Length(w http.ResponseWriter, r *http.Request) {
+	instance.fault(FAULT_NO_CONTENT_LENGTH, w, r)
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) Slow_Body(w http.ResponseWriter, r *http.Request) {
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	exchange := NewExchange(w, r, nil, profile)
+	exchange.Body = generateContent(profile.ResponseBodyGenerator, profile.Config.body, w.Header().Get("Content-Type"))
+	exchange.Throttle = profile.Config.throttle
+	if exchange.Throttle == nil || exchange.Throttle.Args == (ThrottleArgs{}) {
+		exchange.Throttle, _ = NewThrottle(DEFAULT_THROTTLE, NewRealRandom())
+	}
+	exchange.Respond()
+}
+
+// Fail_First fails the first attempts for each client and then succeeds.  The
+// failures, key, ttl and failure query parameters override the configuration.
+func (instance *DefaultEnanosHttpHandlerFactory) Fail_First(w http.ResponseWriter, r *http.Request) {
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	query := r.URL.Query()
+	args := RetryArgs{
+		Key:     query.Get("key"),
+		TTL:     query.Get("ttl"),
+		Failure: query.Get("failure"),
+	}
+	if failures := query.Get("failures"); failures != "" {
+		value, err := strconv.Atoi(failures)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failures: %q is not a number", failures), http.StatusBadRequest)
+			return
+		}
+		args.Failures = &value
+	}
+	behaviour, err := NewRetryBehaviour(args.withDefaults(profile.Config.retry), instance.attempts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exchange := NewExchange(w, r, nil, profile)
+	behaviour.Apply(exchange)
+	exchange.Respond()
+}
+
+// WebSocket_Echo upgrades the connection and sends back each message.  The
+// message, interval, drop, after, end, closecode and ignorepings query
+// parameters override the configuration.
+func (instance *DefaultEnanosHttpHandlerFactory) WebSocket_Echo(w http.ResponseWriter, r *http.Request) {
+	if session := instance.webSocket(w, r); session != nil {
+		session.Echo()
+	}
+}
+
+// WebSocket_Script upgrades the connection and sends the scripted messages,
+// by default the configured content, then closes it.
+func (instance *DefaultEnanosHttpHandlerFactory) WebSocket_Script(w http.ResponseWriter, r *http.Request) {
+	if session := instance.webSocket(w, r); session != nil {
+		messages := session.args.Messages
+		if len(messages) == 0 {
+			config := instance.profile.Current().Config
+			messages = []string{config.render(config.content, r, nil)}
+		}
+		session.Script(messages)
+	}
+}
+
+// Stream writes SSE or NDJSON events.  The format, interval, events,
+// duplicate, after, end and retry query parameters override the
+// configuration.
+func (instance *DefaultEnanosHttpHandlerFactory) Stream(w http.ResponseWriter, r *http.Request) {
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	args, err := parseStreamArgs(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	behaviour, err := NewStreamBehaviour(args.withDefaults(profile.Config.stream), instance.random)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	behaviour.Apply(NewExchange(w, r, nil, profile))
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) webSocket(w http.ResponseWriter, r *http.Request) *WebSocketSession {
+	profile := instance.profile.Current()
+	args, err := parseWebSocketArgs(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	conn, reader, ok := upgradeWebSocket(w, r)
+	if !ok {
+		return nil
+	}
+	return NewWebSocketSession(args.withDefaults(profile.Config.websocket), conn, reader, instance.random)
+}
+
+func (instance *DefaultEnanosHttpHandlerFactory) fault(fault string, w http.ResponseWriter, r *http.Request) {
+	profile := instance.profile.Current()
+	setHeaders(w, r, profile.Config)
+	exchange := NewExchange(w, r, nil, profile)
+	(&FaultBehaviour{fault}).Apply(exchange)
+	exchange.Respond()
+}
+
+func NewDefultHttpHandler(profile *LiveProfile) *DefaultEnanosHttpHandlerFactory {
+	return &DefaultEnanosHttpHandlerFactory{profile, NewAttemptTracker(NewRealClock()), NewRealRandom()}
 }
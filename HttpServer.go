@@ -1,56 +1,72 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"time"
-)
 
-func hello(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		w.WriteHeader(http.StatusCreated)
-	} else {
-		io.WriteString(w, "Hello world!")
-	}
-}
+	"golang.org/x/net/http2"
+)
 
-//HTTPServer ...
+// HTTPServer ...
 type HTTPServer struct {
 	Port     int
+	Host     string
 	listener net.Listener
 	server   *http.Server
 	mux      *http.ServeMux
+	// Wrap, when set, wraps the listener e.g. to serve TLS
+	Wrap func(net.Listener) net.Listener
+	// HTTP2, when set, serves h2 over TLS and h2c with prior knowledge
+	HTTP2 *HTTP2Server
 }
 
-//NewHTTPServer ...
-func NewHTTPServer(port int) *HTTPServer {
+// NewHTTPServer ...
+func NewHTTPServer(port int, host string) *HTTPServer {
 	return &HTTPServer{
 		Port: port,
+		Host: host,
+		mux:  http.NewServeMux(),
 	}
 }
 
-//Start ...
+// HandleFunc ...
+func (instance *HTTPServer) HandleFunc(pattern string, handler http.HandlerFunc) {
+	instance.mux.HandleFunc(pattern, handler)
+}
+
+// Handle ...
+func (instance *HTTPServer) Handle(pattern string, handler http.Handler) {
+	instance.mux.Handle(pattern, handler)
+}
+
+// Start ...
 func (instance *HTTPServer) Start() error {
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", instance.Port))
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", instance.Host, instance.Port))
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", hello)
+	var handler http.Handler = instance.mux
+	if instance.HTTP2 != nil {
+		handler = instance.HTTP2.H2C(handler)
+	}
 
 	s := &http.Server{
-		Addr:           ":8080",
-		Handler:        mux,
+		Handler:        handler,
 		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
+	if instance.HTTP2 != nil {
+		s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){http2.NextProtoTLS: instance.HTTP2.ServeTLS}
+	}
 
 	if err != nil {
 		return err
 	}
+	if instance.Wrap != nil {
+		l = instance.Wrap(l)
+	}
 	instance.listener = l
-	instance.mux = mux
 	instance.server = s
 
 	go func(listener net.Listener) {
@@ -60,7 +76,7 @@ func (instance *HTTPServer) Start() error {
 	return nil
 }
 
-//Stop ...
+// Stop ...
 func (instance *HTTPServer) Stop() {
 	if instance.listener != nil {
 		instance.listener.Close()
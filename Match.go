@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MatchArgs is the configuration file form of the conditions a request has
+// to meet, on top of the path and methods, for a route to match it.  The body
+// conditions are keyed by a dot separated path into the JSON body, e.g.
+// customer.tier or lines.0.sku, and the clients are IPs or CIDR ranges.
+type MatchArgs struct {
+	PathRegex string                   `json:"pathregex,omitempty"`
+	Query     map[string]ConditionArgs `json:"query,omitempty"`
+	Headers   map[string]ConditionArgs `json:"headers,omitempty"`
+	Cookies   map[string]ConditionArgs `json:"cookies,omitempty"`
+	Body      map[string]ConditionArgs `json:"body,omitempty"`
+	Clients   []string                 `json:"clients,omitempty"`
+}
+
+// ConditionArgs is a condition on a value of the request.  Every condition
+// other than absent requires the value to be present, so present on its own
+// only checks that it is.  A plain string is shorthand for equals.
+type ConditionArgs struct {
+	Equals   string `json:"equals,omitempty"`
+	Contains string `json:"contains,omitempty"`
+	Matches  string `json:"matches,omitempty"`
+	Present  bool   `json:"present,omitempty"`
+	Absent   bool   `json:"absent,omitempty"`
+}
+
+// conditionArgs has the fields of ConditionArgs without its unmarshalling
+type conditionArgs ConditionArgs
+
+func (instance *ConditionArgs) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &instance.Equals); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, (*conditionArgs)(instance))
+}
+
+func (instance *ConditionArgs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&instance.Equals); err == nil {
+		return nil
+	}
+	return unmarshal((*conditionArgs)(instance))
+}
+
+// MarshalJSON writes a condition which only has equals in its shorthand.
+func (instance ConditionArgs) MarshalJSON() ([]byte, error) {
+	if instance == (ConditionArgs{Equals: instance.Equals}) && instance.Equals != "" {
+		return json.Marshal(instance.Equals)
+	}
+	return json.Marshal(conditionArgs(instance))
+}
+
+// Condition is a compiled ConditionArgs.
+type Condition struct {
+	Args    ConditionArgs
+	matches *regexp.Regexp
+}
+
+func (instance *Condition) Match(value string, present bool) bool {
+	args := instance.Args
+	if args.Absent {
+		return !present
+	}
+	if !present {
+		return false
+	}
+	if args.Equals != "" && value != args.Equals {
+		return false
+	}
+	if args.Contains != "" && !strings.Contains(value, args.Contains) {
+		return false
+	}
+	return instance.matches == nil || instance.matches.MatchString(value)
+}
+
+func NewCondition(args ConditionArgs) (*Condition, error) {
+	if args.Absent && (args.Equals != "" || args.Contains != "" || args.Matches != "" || args.Present) {
+		return nil, fmt.Errorf("absent cannot be combined with other conditions")
+	}
+	condition := &Condition{Args: args}
+	if args.Matches != "" {
+		matches, err := regexp.Compile(args.Matches)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the regular expression %q: %v", args.Matches, err)
+		}
+		condition.matches = matches
+	}
+	return condition, nil
+}
+
+// Matcher is a compiled MatchArgs.
+type Matcher struct {
+	Args      MatchArgs
+	pathRegex *regexp.Regexp
+	query     map[string]*Condition
+	headers   map[string]*Condition
+	cookies   map[string]*Condition
+	body      map[string]*Condition
+	clients   []*net.IPNet
+}
+
+// Match reports whether the request meets every condition.
+func (instance *Matcher) Match(r *http.Request) bool {
+	if instance.pathRegex != nil && !instance.pathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	query := r.URL.Query()
+	for name, condition := range instance.query {
+		_, present := query[name]
+		if !condition.Match(query.Get(name), present) {
+			return false
+		}
+	}
+	for name, condition := range instance.headers {
+		_, present := r.Header[http.CanonicalHeaderKey(name)]
+		if !condition.Match(r.Header.Get(name), present) {
+			return false
+		}
+	}
+	for name, condition := range instance.cookies {
+		cookie, err := r.Cookie(name)
+		value := ""
+		if err == nil {
+			value = cookie.Value
+		}
+		if !condition.Match(value, err == nil) {
+			return false
+		}
+	}
+	if len(instance.body) > 0 {
+		body := readBody(r)
+		for path, condition := range instance.body {
+			if !condition.Match(jsonField(body, path)) {
+				return false
+			}
+		}
+	}
+	if len(instance.clients) > 0 && !instance.matchClient(r) {
+		return false
+	}
+	return true
+}
+
+func (instance *Matcher) matchClient(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, client := range instance.clients {
+		if ip != nil && client.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewMatcher(args MatchArgs) (*Matcher, error) {
+	matcher := &Matcher{Args: args}
+	if args.PathRegex != "" {
+		pathRegex, err := regexp.Compile(args.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("match: cannot parse the pathregex %q: %v", args.PathRegex, err)
+		}
+		matcher.pathRegex = pathRegex
+	}
+	var err error
+	if matcher.query, err = newConditions("query", args.Query); err != nil {
+		return nil, err
+	}
+	if matcher.headers, err = newConditions("headers", args.Headers); err != nil {
+		return nil, err
+	}
+	if matcher.cookies, err = newConditions("cookies", args.Cookies); err != nil {
+		return nil, err
+	}
+	if matcher.body, err = newConditions("body", args.Body); err != nil {
+		return nil, err
+	}
+	for _, client := range args.Clients {
+		if !strings.Contains(client, "/") {
+			if strings.Contains(client, ":") {
+				client += "/128"
+			} else {
+				client += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(client)
+		if err != nil {
+			return nil, fmt.Errorf("match: %q is not an IP or CIDR range", client)
+		}
+		matcher.clients = append(matcher.clients, network)
+	}
+	return matcher, nil
+}
+
+func newConditions(name string, args map[string]ConditionArgs) (map[string]*Condition, error) {
+	conditions := map[string]*Condition{}
+	for key, conditionArgs := range args {
+		condition, err := NewCondition(conditionArgs)
+		if err != nil {
+			return nil, fmt.Errorf("match: %s %q: %v", name, key, err)
+		}
+		conditions[key] = condition
+	}
+	return conditions, nil
+}
+
+// sortRoutes orders the routes by priority, highest first, keeping the order
+// they are declared in for routes with the same priority.
+func sortRoutes(routes []*Route) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Args.Priority > routes[j].Args.Priority
+	})
+}
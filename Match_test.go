@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Matcher", func() {
+
+	payment := func(tenant string, body string) *http.Request {
+		request := httptest.NewRequest("POST", "/payments?currency=GBP", strings.NewReader(body))
+		request.RemoteAddr = "10.1.2.3:5000"
+		if tenant != "" {
+			request.Header.Set("X-Tenant", tenant)
+		}
+		request.AddCookie(&http.Cookie{Name: "session", Value: "s-1"})
+		return request
+	}
+
+	matcher := func(args MatchArgs) *Matcher {
+		matcher, err := NewMatcher(args)
+		Expect(err).To(BeNil())
+		return matcher
+	}
+
+	It("matches headers, query parameters and cookies", func() {
+		Expect(matcher(MatchArgs{Headers: map[string]ConditionArgs{"x-tenant": {Equals: "acme"}}}).Match(payment("acme", ""))).To(BeTrue())
+		Expect(matcher(MatchArgs{Headers: map[string]ConditionArgs{"X-Tenant": {Equals: "acme"}}}).Match(payment("other", ""))).To(BeFalse())
+		Expect(matcher(MatchArgs{Headers: map[string]ConditionArgs{"X-Tenant": {Absent: true}}}).Match(payment("", ""))).To(BeTrue())
+		Expect(matcher(MatchArgs{Headers: map[string]ConditionArgs{"X-Tenant": {Present: true}}}).Match(payment("", ""))).To(BeFalse())
+		Expect(matcher(MatchArgs{Query: map[string]ConditionArgs{"currency": {Matches: "^(GBP|EUR)$"}}}).Match(payment("", ""))).To(BeTrue())
+		Expect(matcher(MatchArgs{Cookies: map[string]ConditionArgs{"session": {Contains: "s-"}}}).Match(payment("", ""))).To(BeTrue())
+		Expect(matcher(MatchArgs{Cookies: map[string]ConditionArgs{"basket": {Present: true}}}).Match(payment("", ""))).To(BeFalse())
+	})
+
+	It("matches fields of the JSON body and leaves the body to be read", func() {
+		request := payment("", `{"amount":1500,"customer":{"tier":"gold"},"lines":[{"sku":"A-1"}]}`)
+		args := MatchArgs{Body: map[string]ConditionArgs{
+			"customer.tier": {Equals: "gold"},
+			"amount":        {Matches: "^[0-9]{4,}$"},
+			"lines.0.sku":   {Equals: "A-1"},
+			"lines.1.sku":   {Absent: true},
+		}}
+		Expect(matcher(args).Match(request)).To(BeTrue())
+		Expect(readBody(request)).To(ContainSubstring("gold"))
+		large := strings.Repeat(" ", int(READ_BODY_LIMIT)) + `{"customer":{"tier":"gold"}}`
+		request = payment("", large)
+		Expect(matcher(MatchArgs{Body: map[string]ConditionArgs{"customer.tier": {Equals: "gold"}}}).Match(request)).To(BeFalse())
+		all, _ := ioutil.ReadAll(request.Body)
+		Expect(all).To(HaveLen(len(large)))
+		Expect(matcher(MatchArgs{Body: map[string]ConditionArgs{"customer.tier": {Equals: "gold"}}}).Match(payment("", "not json"))).To(BeFalse())
+	})
+
+	It("matches the path and the client", func() {
+		Expect(matcher(MatchArgs{PathRegex: "^/pay", Clients: []string{"10.0.0.0/8"}}).Match(payment("", ""))).To(BeTrue())
+		Expect(matcher(MatchArgs{Clients: []string{"10.1.2.4", "::1"}}).Match(payment("", ""))).To(BeFalse())
+		Expect(matcher(MatchArgs{PathRegex: "^/orders"}).Match(payment("", ""))).To(BeFalse())
+	})
+
+	It("reads a plain value as equals", func() {
+		args := MatchArgs{}
+		Expect(yaml.Unmarshal([]byte("headers:\n  X-Tenant: acme\n  X-Debug: {absent: true}\n"), &args)).To(BeNil())
+		Expect(args.Headers["X-Tenant"]).To(Equal(ConditionArgs{Equals: "acme"}))
+		Expect(args.Headers["X-Debug"]).To(Equal(ConditionArgs{Absent: true}))
+		data, _ := json.Marshal(args)
+		Expect(string(data)).To(Equal(`{"headers":{"X-Debug":{"absent":true},"X-Tenant":"acme"}}`))
+		decoded := MatchArgs{}
+		Expect(json.Unmarshal(data, &decoded)).To(BeNil())
+		Expect(decoded).To(Equal(args))
+	})
+
+	It("rejects invalid conditions", func() {
+		for _, args := range []MatchArgs{
+			{PathRegex: "("},
+			{Query: map[string]ConditionArgs{"q": {Matches: "["}}},
+			{Headers: map[string]ConditionArgs{"X-Tenant": {Absent: true, Equals: "acme"}}},
+			{Clients: []string{"10.0.0.0/99"}},
+		} {
+			_, err := NewMatcher(args)
+			Expect(err).ToNot(BeNil())
+		}
+	})
+
+	It("picks the route by priority and falls back", func() {
+		reader := NewArgsConfigurationReader(&CommandLineArgs{Routes: []RouteArgs{
+			{Path: "/*", Priority: -1, Behaviours: []BehaviourArgs{{Status: 200}}},
+			{Path: "/pay*", Methods: []string{"POST"}, Behaviours: []BehaviourArgs{{Status: 404}}},
+			{Path: "/payments", Methods: []string{"POST"}, Priority: 10, Match: &MatchArgs{Headers: map[string]ConditionArgs{"X-Tenant": {Equals: "acme"}}}, Behaviours: []BehaviourArgs{{Status: 503}}},
+		}})
+		Expect(reader.Validate()).To(BeNil())
+		router := NewRouter(NewLiveProfile(Profile{Config: reader.Read()}))
+		serve := func(request *http.Request) int {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+		Expect(serve(payment("acme", ""))).To(Equal(503))
+		Expect(serve(payment("other", ""))).To(Equal(404))
+		Expect(serve(newRequest("GET", "/orders"))).To(Equal(200))
+	})
+
+	It("applies the match of a route ending in *", func() {
+		route, err := NewRoute(RouteArgs{
+			Path:       "/payments/*",
+			Match:      &MatchArgs{Headers: map[string]ConditionArgs{"X-Tenant": {Equals: "acme"}}},
+			Behaviours: []BehaviourArgs{{Status: 503}},
+		})
+		Expect(err).To(BeNil())
+		request := newRequest("POST", "/payments/1/refunds")
+		_, ok := route.Match(request)
+		Expect(ok).To(BeFalse())
+		request.Header.Set("X-Tenant", "acme")
+		_, ok = route.Match(request)
+		Expect(ok).To(BeTrue())
+	})
+})
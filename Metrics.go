@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	METRIC_REQUESTS   string = "enanos_requests_total"
+	METRIC_DURATION   string = "enanos_request_duration_seconds"
+	METRIC_ALIVE      string = "enanos_alive"
+	METRIC_JITTER_UP  string = "enanos_jitter_up"
+	METRICS_MIME_TYPE string = "text/plain; version=0.0.4"
+)
+
+var (
+	METRIC_BUCKETS []float64         = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	METRIC_HELP    map[string]string = map[string]string{
+		METRIC_REQUESTS:  "The number of requests served by endpoint, method, code and fault.",
+		METRIC_DURATION:  "How long requests took to serve by endpoint, method, code and fault.",
+		METRIC_ALIVE:     "Whether the server is alive, 0 while dead_or_alive has killed it.",
+		METRIC_JITTER_UP: "Whether the jitter server is currently up.",
+	}
+)
+
+// MetricLabels are the labels requests are counted by.  A request which did
+// not match an endpoint has an empty Endpoint and one with no fault an empty
+// Fault.
+type MetricLabels struct {
+	Endpoint string
+	Method   string
+	Code     int
+	Fault    string
+}
+
+func (instance MetricLabels) String() string {
+	return fmt.Sprintf(`endpoint="%s",method="%s",code="%d",fault="%s"`,
+		escapeLabel(instance.Endpoint), escapeLabel(instance.Method), instance.Code, escapeLabel(instance.Fault))
+}
+
+type RequestMetric struct {
+	Count   uint64
+	Sum     float64
+	Buckets []uint64
+}
+
+// Metrics counts the requests served and the state of the servers so that they
+// can be scraped by Prometheus.
+type Metrics struct {
+	mutex    sync.Mutex
+	requests map[MetricLabels]*RequestMetric
+	gauges   map[string]float64
+}
+
+// Observe counts the request.  It does nothing on a nil Metrics, like
+// SetGauge.
+func (instance *Metrics) Observe(record *RecordedRequest) {
+	if instance == nil {
+		return
+	}
+	labels := MetricLabels{record.Endpoint, record.Method, record.Code, record.Fault}
+	seconds := record.Elapsed.Seconds()
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	metric, ok := instance.requests[labels]
+	if !ok {
+		metric = &RequestMetric{Buckets: make([]uint64, len(METRIC_BUCKETS))}
+		instance.requests[labels] = metric
+	}
+	metric.Count++
+	metric.Sum += seconds
+	for i, bucket := range METRIC_BUCKETS {
+		if seconds <= bucket {
+			metric.Buckets[i]++
+		}
+	}
+}
+
+// SetGauge sets the value of one of the gauges.  It does nothing on a nil
+// Metrics so that servers can be used without one.
+func (instance *Metrics) SetGauge(name string, value float64) {
+	if instance == nil {
+		return
+	}
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.gauges[name] = value
+}
+
+// Write writes the metrics in the Prometheus text format.
+func (instance *Metrics) Write(w io.Writer) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	labels := []MetricLabels{}
+	for label := range instance.requests {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].String() < labels[j].String()
+	})
+	writeMetricHeader(w, METRIC_REQUESTS, "counter")
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s} %d\n", METRIC_REQUESTS, label, instance.requests[label].Count)
+	}
+	writeMetricHeader(w, METRIC_DURATION, "histogram")
+	for _, label := range labels {
+		metric := instance.requests[label]
+		for i, bucket := range METRIC_BUCKETS {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", METRIC_DURATION, label, strconv.FormatFloat(bucket, 'g', -1, 64), metric.Buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", METRIC_DURATION, label, metric.Count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", METRIC_DURATION, label, strconv.FormatFloat(metric.Sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", METRIC_DURATION, label, metric.Count)
+	}
+	for _, name := range []string{METRIC_ALIVE, METRIC_JITTER_UP} {
+		writeMetricHeader(w, name, "gauge")
+		fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(instance.gauges[name], 'g', -1, 64))
+	}
+}
+
+func writeMetricHeader(w io.Writer, name string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, METRIC_HELP[name])
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: map[MetricLabels]*RequestMetric{},
+		gauges:   map[string]float64{},
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+
+	var metrics *Metrics
+
+	scrape := func() string {
+		buffer := &bytes.Buffer{}
+		metrics.Write(buffer)
+		return buffer.String()
+	}
+
+	BeforeEach(func() {
+		metrics = NewMetrics()
+	})
+
+	It("counts requests by endpoint, method, code and fault", func() {
+		record := &RecordedRequest{Endpoint: "/chaos", Method: "GET", Code: 503, Fault: FAULT_SERVER_ERROR}
+		metrics.Observe(record)
+		metrics.Observe(record)
+		Expect(scrape()).To(ContainSubstring(`enanos_requests_total{endpoint="/chaos",method="GET",code="503",fault="server_error"} 2`))
+	})
+
+	It("adds the duration to the histogram buckets", func() {
+		metrics.Observe(&RecordedRequest{Endpoint: "/wait", Method: "GET", Code: 200, Elapsed: 200 * time.Millisecond})
+		output := scrape()
+		labels := `endpoint="/wait",method="GET",code="200",fault=""`
+		Expect(output).To(ContainSubstring(`enanos_request_duration_seconds_bucket{` + labels + `,le="0.1"} 0`))
+		Expect(output).To(ContainSubstring(`enanos_request_duration_seconds_bucket{` + labels + `,le="0.25"} 1`))
+		Expect(output).To(ContainSubstring(`enanos_request_duration_seconds_bucket{` + labels + `,le="+Inf"} 1`))
+		Expect(output).To(ContainSubstring(`enanos_request_duration_seconds_sum{` + labels + `} 0.2`))
+	})
+
+	It("reports the gauges", func() {
+		metrics.SetGauge(METRIC_ALIVE, 1)
+		output := scrape()
+		Expect(output).To(ContainSubstring("# TYPE enanos_alive gauge\nenanos_alive 1\n"))
+		Expect(output).To(ContainSubstring("enanos_jitter_up 0\n"))
+	})
+
+	It("does nothing on a nil Metrics", func() {
+		var metrics *Metrics
+		Expect(func() {
+			metrics.Observe(&RecordedRequest{Endpoint: "/success"})
+			metrics.SetGauge(METRIC_ALIVE, 1)
+		}).ToNot(Panic())
+	})
+
+	It("escapes label values", func() {
+		metrics.Observe(&RecordedRequest{Endpoint: `/a"b`, Method: "GET", Code: 200})
+		Expect(scrape()).To(ContainSubstring(`endpoint="/a\"b"`))
+	})
+
+	It("observes the requests served by the Router", func() {
+		router := NewRouter(NewLiveProfile(Profile{}), metrics)
+		router.HandleFunc("/success", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		router.ServeHTTP(httptest.NewRecorder(), newRequest("GET", "/success"))
+		router.ServeHTTP(httptest.NewRecorder(), newRequest("GET", "/nothing"))
+		output := scrape()
+		Expect(output).To(ContainSubstring(`enanos_requests_total{endpoint="/success",method="GET",code="200",fault="success"} 1`))
+		Expect(output).To(ContainSubstring(`enanos_requests_total{endpoint="",method="GET",code="404",fault=""} 1`))
+	})
+})
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+)
+
+// OutcomeArgs is the configuration file form of one weighted outcome of a
+// MixBehaviour.
+type OutcomeArgs struct {
+	Weight        int `json:"weight"`
+	BehaviourArgs `yaml:",inline"`
+}
+
+var (
+	// DEFAULT_CHAOS is the mix used by the /chaos endpoint when none is configured.
+	DEFAULT_CHAOS []OutcomeArgs = []OutcomeArgs{
+		{Weight: 90, BehaviourArgs: BehaviourArgs{Fault: FAULT_SUCCESS}},
+		{Weight: 5, BehaviourArgs: BehaviourArgs{Fault: FAULT_SERVER_ERROR}},
+		{Weight: 5, BehaviourArgs: BehaviourArgs{Fault: FAULT_WAIT}},
+	}
+)
+
+type Outcome struct {
+	Weight    int
+	Behaviour Behaviour
+}
+
+// MixBehaviour picks one of its outcomes for each request with a probability
+// proportional to the outcome's weight.
+type MixBehaviour struct {
+	Args     []OutcomeArgs
+	Outcomes []Outcome
+	total    int
+	random   Random
+}
+
+func (instance *MixBehaviour) Apply(exchange *Exchange) {
+	instance.Pick().Apply(exchange)
+}
+
+func (instance *MixBehaviour) Pick() Behaviour {
+	value := instance.random.Int(0, instance.total)
+	for _, outcome := range instance.Outcomes {
+		if value < outcome.Weight {
+			return outcome.Behaviour
+		}
+		value -= outcome.Weight
+	}
+	return instance.Outcomes[len(instance.Outcomes)-1].Behaviour
+}
+
+func NewMixBehaviour(args []OutcomeArgs, random Random) (*MixBehaviour, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("mix: at least one outcome is required")
+	}
+	mix := &MixBehaviour{Args: args, random: random}
+	for _, outcomeArgs := range args {
+		if outcomeArgs.Weight <= 0 {
+			return nil, fmt.Errorf("mix: weight must be greater than 0")
+		}
+		behaviour, err := NewBehaviour(outcomeArgs.BehaviourArgs)
+		if err != nil {
+			return nil, fmt.Errorf("mix: %v", err)
+		}
+		mix.Outcomes = append(mix.Outcomes, Outcome{outcomeArgs.Weight, behaviour})
+		mix.total += outcomeArgs.Weight
+	}
+	return mix, nil
+}
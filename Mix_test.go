@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MixBehaviour", func() {
+
+	var random *FakeRandom
+	var mix *MixBehaviour
+
+	BeforeEach(func() {
+		random = NewFakeRandom()
+		mix, _ = NewMixBehaviour([]OutcomeArgs{
+			{Weight: 90, BehaviourArgs: BehaviourArgs{Status: 200}},
+			{Weight: 5, BehaviourArgs: BehaviourArgs{Status: 503}},
+			{Weight: 5, BehaviourArgs: BehaviourArgs{Fault: FAULT_SERVER_ERROR}},
+		}, random)
+	})
+
+	apply := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		codes := NewFakeResponseCodeGenerator()
+		codes.Use(502)
+		exchange := NewExchange(recorder, newRequest("GET", "/chaos"), nil, Profile{ResponseCodeGenerator: codes})
+		mix.Apply(exchange)
+		exchange.Respond()
+		return recorder
+	}
+
+	It("picks outcomes in proportion to their weights", func() {
+		random.ForIntUse(89)
+		Expect(apply().Code).To(Equal(200))
+		random.ForIntUse(90)
+		Expect(apply().Code).To(Equal(503))
+		random.ForIntUse(94)
+		Expect(apply().Code).To(Equal(503))
+		random.ForIntUse(95)
+		Expect(apply().Code).To(Equal(502))
+	})
+
+	It("rejects a mix without outcomes", func() {
+		_, err := NewMixBehaviour([]OutcomeArgs{}, random)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects an outcome without a weight", func() {
+		_, err := NewMixBehaviour([]OutcomeArgs{{BehaviourArgs: BehaviourArgs{Status: 200}}}, random)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects an unknown fault", func() {
+		_, err := NewMixBehaviour([]OutcomeArgs{{Weight: 1, BehaviourArgs: BehaviourArgs{Fault: "boom"}}}, random)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("is built once for the profile, falling back to the default chaos", func() {
+		profile := NewProfile(Configuration{})
+		Expect(profile.Chaos.Args).To(Equal(DEFAULT_CHAOS))
+		Expect(NewProfile(Configuration{chaos: mix}).Chaos).To(BeIdenticalTo(mix))
+	})
+})
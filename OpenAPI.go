@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OPENAPI_ALL_OPERATIONS string = "*"
+
+	// OPENAPI_MAX_DEPTH stops the examples of recursive schemas at a few levels
+	OPENAPI_MAX_DEPTH int = 8
+)
+
+var (
+	OPENAPI_METHODS []string = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+	// OPENAPI_PARAMS finds the templates in a spec path, e.g. {id}
+	OPENAPI_PARAMS *regexp.Regexp = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// OpenAPIArgs is the configuration file form of an OpenAPI 3 spec served as a
+// mock.  Each operation of the spec gets a route which responds with an
+// example of its response, followed by the behaviours and mix of the first
+// operations entry which names it.
+type OpenAPIArgs struct {
+	Spec       string          `json:"spec,omitempty"`
+	Operations []OperationArgs `json:"operations,omitempty"`
+}
+
+// OperationArgs names an operation by its operationId, as METHOD /path or *
+// for every operation no other entry names.  The response is the documented
+// response to use, by default the first success.
+type OperationArgs struct {
+	Operation  string          `json:"operation"`
+	Response   string          `json:"response,omitempty"`
+	Behaviours []BehaviourArgs `json:"behaviours,omitempty"`
+	Mix        []OutcomeArgs   `json:"mix,omitempty"`
+}
+
+func (instance OperationArgs) matches(id string, method string, path string) bool {
+	return instance.Operation == OPENAPI_ALL_OPERATIONS ||
+		(id != "" && instance.Operation == id) ||
+		strings.EqualFold(instance.Operation, method+" "+path)
+}
+
+// OpenAPI holds the routes generated for the operations of a spec.
+type OpenAPI struct {
+	Args     OpenAPIArgs
+	Routes   []*Route
+	document map[string]interface{}
+}
+
+// RouteArgs generates a route for each operation of the spec.  Routes with
+// fewer path templates come first, so that /orders/summary is matched before
+// /orders/{id}.
+func (instance *OpenAPI) RouteArgs() ([]RouteArgs, error) {
+	paths, _ := instance.document["paths"].(map[string]interface{})
+	names := []string{}
+	for path := range paths {
+		names = append(names, path)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		templates := strings.Count(names[i], "{") - strings.Count(names[j], "{")
+		if templates != 0 {
+			return templates < 0
+		}
+		return names[i] < names[j]
+	})
+	used := map[int]bool{}
+	routes := []RouteArgs{}
+	for _, path := range names {
+		item, _ := instance.resolve(paths[path]).(map[string]interface{})
+		for _, method := range OPENAPI_METHODS {
+			operation, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			method = strings.ToUpper(method)
+			id, _ := operation["operationId"].(string)
+			//An operation named on its own wins over * wherever * is listed
+			args := OperationArgs{}
+			matched := -1
+			for i, operationArgs := range instance.Args.Operations {
+				if !operationArgs.matches(id, method, path) {
+					continue
+				}
+				if operationArgs.Operation != OPENAPI_ALL_OPERATIONS {
+					matched = i
+					break
+				}
+				if matched < 0 {
+					matched = i
+				}
+			}
+			if matched >= 0 {
+				args = instance.Args.Operations[matched]
+				used[matched] = true
+			}
+			behaviours, err := instance.responseBehaviours(operation, args.Response)
+			if err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %v", method, path, err)
+			}
+			routes = append(routes, RouteArgs{
+				Path:       routePath(path),
+				Methods:    []string{method},
+				Behaviours: append(behaviours, args.Behaviours...),
+				Mix:        args.Mix,
+			})
+		}
+	}
+	for i, operationArgs := range instance.Args.Operations {
+		if !used[i] {
+			return nil, fmt.Errorf("openapi: operation %q is not in the spec", operationArgs.Operation)
+		}
+	}
+	return routes, nil
+}
+
+// routePath turns a spec path into a route path.  A template which is only
+// part of a segment, such as {name}.json or {id}:cancel, becomes a glob as
+// route params have to be whole segments.
+func routePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if OPENAPI_PARAMS.FindString(segment) != segment {
+			segments[i] = OPENAPI_PARAMS.ReplaceAllString(segment, "*")
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// responseBehaviours sets the status, headers and an example body of the
+// documented response.
+func (instance *OpenAPI) responseBehaviours(operation map[string]interface{}, name string) ([]BehaviourArgs, error) {
+	responses, _ := operation["responses"].(map[string]interface{})
+	if name == "" {
+		name = defaultResponse(responses)
+	}
+	response, ok := instance.resolve(responses[name]).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("response %q is not documented", name)
+	}
+	behaviours := []BehaviourArgs{{Status: responseCode(name)}}
+	headers := []string{}
+	documented, _ := response["headers"].(map[string]interface{})
+	for _, key := range sortedKeys(documented) {
+		header, _ := instance.resolve(documented[key]).(map[string]interface{})
+		value := header["example"]
+		if value == nil {
+			value = instance.Example(header["schema"], 0)
+		}
+		if value != nil {
+			headers = append(headers, fmt.Sprintf("%s:%v", key, value))
+		}
+	}
+	content, _ := response["content"].(map[string]interface{})
+	mediaType := preferredMediaType(content)
+	if mediaType != "" {
+		headers = append(headers, "Content-Type:"+mediaType)
+	}
+	if len(headers) > 0 {
+		behaviours = append(behaviours, BehaviourArgs{Headers: headers})
+	}
+	if mediaType == "" {
+		return behaviours, nil
+	}
+	media, _ := content[mediaType].(map[string]interface{})
+	body, err := encodeExample(mediaType, instance.mediaExample(media))
+	if err != nil {
+		return nil, err
+	}
+	if body != "" {
+		behaviours = append(behaviours, BehaviourArgs{Content: body})
+	}
+	return behaviours, nil
+}
+
+// mediaExample is the example of the media type, then the first of its
+// examples and then one generated from its schema.
+func (instance *OpenAPI) mediaExample(media map[string]interface{}) interface{} {
+	if example, ok := media["example"]; ok {
+		return example
+	}
+	if examples, ok := media["examples"].(map[string]interface{}); ok && len(examples) > 0 {
+		example, _ := instance.resolve(examples[sortedKeys(examples)[0]]).(map[string]interface{})
+		if value, ok := example["value"]; ok {
+			return value
+		}
+	}
+	return instance.Example(media["schema"], 0)
+}
+
+// Example generates a value which is valid against the schema, preferring any
+// example, default or enum it declares.
+func (instance *OpenAPI) Example(value interface{}, depth int) interface{} {
+	schema, ok := instance.resolve(value).(map[string]interface{})
+	if !ok || depth > OPENAPI_MAX_DEPTH {
+		return nil
+	}
+	for _, key := range []string{"example", "default", "const"} {
+		if example, ok := schema[key]; ok {
+			return example
+		}
+	}
+	for _, key := range []string{"examples", "enum", "oneOf", "anyOf"} {
+		if list, ok := schema[key].([]interface{}); ok && len(list) > 0 {
+			if key == "oneOf" || key == "anyOf" {
+				return instance.Example(list[0], depth+1)
+			}
+			return list[0]
+		}
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		merged := map[string]interface{}{}
+		for _, item := range allOf {
+			if object, ok := instance.Example(item, depth+1).(map[string]interface{}); ok {
+				for key, value := range object {
+					merged[key] = value
+				}
+			}
+		}
+		return merged
+	}
+	switch schemaType(schema) {
+	case "object":
+		required := map[string]bool{}
+		if names, ok := schema["required"].([]interface{}); ok {
+			for _, name := range names {
+				required[fmt.Sprint(name)] = true
+			}
+		}
+		object := map[string]interface{}{}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for key, property := range properties {
+			//Optional properties of recursive schemas are left out once too deep
+			if example := instance.Example(property, depth+1); example != nil || required[key] {
+				object[key] = example
+			}
+		}
+		return object
+	case "array":
+		items := []interface{}{}
+		example := instance.Example(schema["items"], depth+1)
+		if example == nil {
+			return items
+		}
+		for i := 0; i < int(math.Max(1, number(schema["minItems"], 0))); i++ {
+			items = append(items, example)
+		}
+		return items
+	case "integer":
+		return int64(math.Ceil(exampleNumber(schema)))
+	case "number":
+		return exampleNumber(schema)
+	case "boolean":
+		return true
+	case "string":
+		return exampleString(schema)
+	}
+	return nil
+}
+
+// resolve follows a local $ref, e.g. #/components/schemas/Order.
+func (instance *OpenAPI) resolve(value interface{}) interface{} {
+	for i := 0; i < OPENAPI_MAX_DEPTH; i++ {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		ref, ok := object["$ref"].(string)
+		if !ok {
+			return value
+		}
+		value = nil
+		if strings.HasPrefix(ref, "#/") {
+			var current interface{} = instance.document
+			for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				name = strings.Replace(strings.Replace(name, "~1", "/", -1), "~0", "~", -1)
+				parent, _ := current.(map[string]interface{})
+				current = parent[name]
+			}
+			value = current
+		}
+	}
+	return value
+}
+
+func schemaType(schema map[string]interface{}) string {
+	switch value := schema["type"].(type) {
+	case string:
+		return value
+	case []interface{}:
+		//OpenAPI 3.1 allows a list of types, e.g. [string, "null"]
+		for _, item := range value {
+			if name, ok := item.(string); ok && name != "null" {
+				return name
+			}
+		}
+	}
+	if _, ok := schema["properties"]; ok {
+		return "object"
+	}
+	if _, ok := schema["items"]; ok {
+		return "array"
+	}
+	return ""
+}
+
+// exampleNumber is the smallest number allowed by the minimum and maximum, or
+// 0 when they allow it.
+func exampleNumber(schema map[string]interface{}) float64 {
+	example := 0.0
+	if minimum, ok := schema["minimum"]; ok {
+		example = number(minimum, 0)
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive {
+			example++
+		}
+	} else if maximum, ok := schema["maximum"]; ok && number(maximum, 0) < 0 {
+		example = number(maximum, 0)
+		if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive {
+			example--
+		}
+	}
+	if exclusiveMinimum, ok := schema["exclusiveMinimum"]; ok {
+		if _, isBool := exclusiveMinimum.(bool); !isBool && number(exclusiveMinimum, 0) >= example {
+			example = number(exclusiveMinimum, 0) + 1
+		}
+	}
+	return example
+}
+
+func exampleString(schema map[string]interface{}) string {
+	formats := map[string]string{
+		"date-time": "2024-01-01T00:00:00Z",
+		"date":      "2024-01-01",
+		"time":      "00:00:00Z",
+		"uuid":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+		"email":     "enanos@example.com",
+		"uri":       "https://example.com/enanos",
+		"url":       "https://example.com/enanos",
+		"hostname":  "example.com",
+		"ipv4":      "192.0.2.1",
+		"ipv6":      "2001:db8::1",
+		"byte":      "ZW5hbm9z",
+	}
+	example, ok := formats[fmt.Sprint(schema["format"])]
+	if !ok {
+		example = "enanos"
+	}
+	if minLength := int(number(schema["minLength"], 0)); len(example) < minLength {
+		example += strings.Repeat("-", minLength-len(example))
+	}
+	if maxLength, ok := schema["maxLength"]; ok && len(example) > int(number(maxLength, 0)) {
+		example = example[:int(number(maxLength, 0))]
+	}
+	return example
+}
+
+func number(value interface{}, fallback float64) float64 {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case uint64:
+		return float64(value)
+	case float64:
+		return value
+	}
+	return fallback
+}
+
+// defaultResponse is the first documented success, then default and then
+// whichever comes first.
+func defaultResponse(responses map[string]interface{}) string {
+	names := sortedKeys(responses)
+	for _, name := range names {
+		if strings.HasPrefix(name, "2") {
+			return name
+		}
+	}
+	if _, ok := responses["default"]; ok {
+		return "default"
+	}
+	if len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// responseCode converts a documented response such as 404, 5XX or default to
+// a status code.
+func responseCode(name string) int {
+	if code, err := strconv.Atoi(strings.Replace(strings.ToUpper(name), "XX", "00", 1)); err == nil {
+		return code
+	}
+	return http.StatusOK
+}
+
+// preferredMediaType is JSON when the content has it and otherwise whichever
+// comes first.
+func preferredMediaType(content map[string]interface{}) string {
+	names := sortedKeys(content)
+	for _, name := range names {
+		if contentTypeFormat(name) == BODY_FORMAT_JSON {
+			return name
+		}
+	}
+	if len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// encodeExample writes JSON examples as JSON and any other string as it is.
+func encodeExample(mediaType string, example interface{}) (string, error) {
+	if example == nil {
+		return "", nil
+	}
+	if text, ok := example.(string); ok && contentTypeFormat(mediaType) != BODY_FORMAT_JSON {
+		return text, nil
+	}
+	data, err := json.Marshal(example)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode the example: %v", err)
+	}
+	return string(data), nil
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := []string{}
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normalise converts the maps read from YAML to the maps read from JSON.
+func normalise(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := map[string]interface{}{}
+		for key, item := range value {
+			object[fmt.Sprint(key)] = normalise(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalise(item)
+		}
+	}
+	return value
+}
+
+// NewOpenAPI reads the spec, in JSON or YAML, and generates its routes.
+func NewOpenAPI(args OpenAPIArgs) (*OpenAPI, error) {
+	data, err := ioutil.ReadFile(args.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: cannot read %q: %v", args.Spec, err)
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("openapi: cannot parse %q: %v", args.Spec, err)
+	}
+	instance := &OpenAPI{Args: args}
+	instance.document, _ = normalise(document).(map[string]interface{})
+	if version, _ := instance.document["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("openapi: %q is not an OpenAPI 3 spec", args.Spec)
+	}
+	routeArgs, err := instance.RouteArgs()
+	if err != nil {
+		return nil, err
+	}
+	for _, routeArgs := range routeArgs {
+		route, err := NewRoute(routeArgs)
+		if err != nil {
+			return nil, fmt.Errorf("openapi: %v", err)
+		}
+		instance.Routes = append(instance.Routes, route)
+	}
+	return instance, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testSpec = `openapi: 3.0.3
+info: {title: orders, version: "1"}
+paths:
+  /orders/{id}:
+    get:
+      operationId: getOrder
+      responses:
+        "200":
+          description: an order
+          headers:
+            X-Rate-Limit: {schema: {type: integer, minimum: 10}}
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Order"}
+            application/xml:
+              example: <order/>
+        "404":
+          description: not found
+  /orders/summary:
+    get:
+      responses:
+        default:
+          description: the summary
+          content:
+            text/plain:
+              schema: {type: string, minLength: 10}
+  /orders:
+    post:
+      operationId: createOrder
+      responses:
+        "201":
+          description: created
+          content:
+            application/json:
+              examples:
+                created: {value: {id: 7}}
+        "409":
+          description: conflict
+          content:
+            application/json:
+              example: {error: exists}
+components:
+  schemas:
+    Order:
+      type: object
+      required: [id, status]
+      properties:
+        id: {type: string, format: uuid}
+        status: {type: string, enum: [open, closed]}
+        total: {type: number, minimum: 0, exclusiveMinimum: true}
+        lines:
+          type: array
+          items: {allOf: [{$ref: "#/components/schemas/Line"}, {properties: {note: {type: string, maxLength: 3}}}]}
+        parent: {$ref: "#/components/schemas/Order"}
+    Line:
+      properties:
+        quantity: {type: integer, default: 2}
+`
+
+var _ = Describe("OpenAPI", func() {
+
+	var spec string
+
+	BeforeEach(func() {
+		file, _ := ioutil.TempFile("", "enanos-openapi")
+		file.WriteString(testSpec)
+		file.Close()
+		spec = file.Name()
+	})
+
+	AfterEach(func() {
+		os.Remove(spec)
+	})
+
+	serve := func(args OpenAPIArgs, method string, path string) *httptest.ResponseRecorder {
+		openapi, err := NewOpenAPI(args)
+		Expect(err).To(BeNil())
+		router := NewRouter(NewLiveProfile(Profile{Config: Configuration{openapi: openapi}}))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, newRequest(method, path))
+		return recorder
+	}
+
+	It("responds with a value generated from the schema", func() {
+		recorder := serve(OpenAPIArgs{Spec: spec}, "GET", "/orders/1")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Header().Get("X-Rate-Limit")).To(Equal("10"))
+		order := map[string]interface{}{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &order)).To(BeNil())
+		Expect(order["id"]).To(Equal("3fa85f64-5717-4562-b3fc-2c963f66afa6"))
+		Expect(order["status"]).To(Equal("open"))
+		Expect(order["total"]).To(Equal(1.0))
+		Expect(order["lines"]).To(Equal([]interface{}{map[string]interface{}{"quantity": 2.0, "note": "ena"}}))
+		Expect(order["parent"]).To(HaveKey("status"))
+	})
+
+	It("uses the examples and the other media types", func() {
+		recorder := serve(OpenAPIArgs{Spec: spec}, "POST", "/orders")
+		Expect(recorder.Code).To(Equal(http.StatusCreated))
+		Expect(recorder.Body.String()).To(Equal(`{"id":7}`))
+		recorder = serve(OpenAPIArgs{Spec: spec}, "GET", "/orders/summary")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/plain"))
+		Expect(recorder.Body.String()).To(Equal("enanos----"))
+		Expect(serve(OpenAPIArgs{Spec: spec}, "DELETE", "/orders").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("applies the response and behaviours of the operations", func() {
+		args := OpenAPIArgs{Spec: spec, Operations: []OperationArgs{
+			{Operation: "post /orders", Response: "409"},
+			{Operation: "*", Behaviours: []BehaviourArgs{{Status: 503}}},
+		}}
+		recorder := serve(args, "POST", "/orders")
+		Expect(recorder.Code).To(Equal(http.StatusConflict))
+		Expect(recorder.Body.String()).To(Equal(`{"error":"exists"}`))
+		recorder = serve(args, "GET", "/orders/1")
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Body.String()).To(ContainSubstring(`"status":"open"`))
+	})
+
+	It("applies the operations named on their own before * wherever it is listed", func() {
+		args := OpenAPIArgs{Spec: spec, Operations: []OperationArgs{
+			{Operation: "*", Behaviours: []BehaviourArgs{{Status: 503}}},
+			{Operation: "post /orders", Response: "409"},
+		}}
+		_, err := NewOpenAPI(args)
+		Expect(err).To(BeNil())
+		Expect(serve(args, "POST", "/orders").Code).To(Equal(http.StatusConflict))
+		Expect(serve(args, "GET", "/orders/1").Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("matches the operations ahead of routes with a lower priority", func() {
+		openapi, err := NewOpenAPI(OpenAPIArgs{Spec: spec})
+		Expect(err).To(BeNil())
+		fallback, _ := NewRoute(RouteArgs{Path: "/*", Priority: -1, Behaviours: []BehaviourArgs{{Status: 418}}})
+		override, _ := NewRoute(RouteArgs{Path: "/orders/{id}", Behaviours: []BehaviourArgs{{Status: 503}}})
+		config := Configuration{openapi: openapi, routes: []*Route{fallback}}
+		router := NewRouter(NewLiveProfile(Profile{Config: config}))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, newRequest("GET", "/orders/1"))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, newRequest("GET", "/undocumented"))
+		Expect(recorder.Code).To(Equal(http.StatusTeapot))
+		config.routes = []*Route{override, fallback}
+		router = NewRouter(NewLiveProfile(Profile{Config: config}))
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, newRequest("GET", "/orders/1"))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("matches paths with templates which are part of a segment", func() {
+		ioutil.WriteFile(spec, []byte(`openapi: 3.0.3
+info: {title: files, version: "1"}
+paths:
+  "/files/{name}.json":
+    get:
+      responses:
+        "200": {description: a file}
+  "/orders/{id}:cancel":
+    post:
+      responses:
+        "202": {description: cancelled}
+`), 0644)
+		Expect(serve(OpenAPIArgs{Spec: spec}, "GET", "/files/report.json").Code).To(Equal(http.StatusOK))
+		Expect(serve(OpenAPIArgs{Spec: spec}, "GET", "/files/report.xml").Code).To(Equal(http.StatusNotFound))
+		Expect(serve(OpenAPIArgs{Spec: spec}, "POST", "/orders/7:cancel").Code).To(Equal(http.StatusAccepted))
+		Expect(routePath("/orders/{id}/{a}.{b}")).To(Equal("/orders/{id}/*.*"))
+	})
+
+	It("orders the routes once when the configuration is read", func() {
+		config := NewArgsConfigurationReader(&CommandLineArgs{
+			OpenAPI: OpenAPIArgs{Spec: spec},
+			Routes:  []RouteArgs{{Path: "/*", Priority: -1, Behaviours: []BehaviourArgs{{Status: 418}}}},
+		}).Read()
+		routes := config.allRoutes()
+		Expect(routes).To(HaveLen(len(config.openapi.Routes) + 1))
+		Expect(routes[len(routes)-1].Args.Path).To(Equal("/*"))
+		Expect(&config.allRoutes()[0]).To(BeIdenticalTo(&routes[0]))
+	})
+
+	It("rejects operations which are not in the spec and specs which are not OpenAPI 3", func() {
+		_, err := NewOpenAPI(OpenAPIArgs{Spec: spec, Operations: []OperationArgs{{Operation: "deleteOrder"}}})
+		Expect(err).ToNot(BeNil())
+		_, err = NewOpenAPI(OpenAPIArgs{Spec: spec, Operations: []OperationArgs{{Operation: "getOrder", Response: "500"}}})
+		Expect(err).ToNot(BeNil())
+		ioutil.WriteFile(spec, []byte(`{"swagger":"2.0"}`), 0644)
+		_, err = NewOpenAPI(OpenAPIArgs{Spec: spec})
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	OVERRIDE_HEADER_PREFIX string = "X-Enanos-"
+)
+
+var (
+	DEFAULT_MAX_OVERRIDE_DELAY time.Duration = 60 * time.Second
+	DEFAULT_MAX_OVERRIDE_SIZE  uint64        = 10 * 1000 * 1000
+
+	errOverrideStreamed error = fmt.Errorf("the body and corrupt overrides need a size on a streamed response")
+)
+
+// OverrideArgs is the configuration file form of the limits on per request
+// overrides.  Empty or zero limits use the defaults.
+type OverrideArgs struct {
+	MaxDelay string `json:"maxdelay,omitempty"`
+	MaxSize  string `json:"maxsize,omitempty"`
+}
+
+// Overrides are the changes a single request has asked for, either with query
+// parameters, e.g. ?delay=250ms&size=2MB&code=503&header=X-Foo:bar, or with
+// the matching X-Enanos-Delay, X-Enanos-Size, X-Enanos-Code and
+// X-Enanos-Header request headers.  The body and corrupt overrides change the
+// format of the body and corrupt it.
+type Overrides struct {
+	Delay   time.Duration
+	Size    uint64
+	HasSize bool
+	Code    int
+	Headers []string
+	Format  string
+	Corrupt string
+}
+
+// ParseOverrides reads the overrides from the request headers and, when
+// useQuery is set, from the query string.  It returns nil when the request
+// does not override anything.
+func ParseOverrides(r *http.Request, useQuery bool, config Configuration) (*Overrides, error) {
+	values := func(name string) []string {
+		found := r.Header[http.CanonicalHeaderKey(OVERRIDE_HEADER_PREFIX+name)]
+		if useQuery {
+			found = append(found, r.URL.Query()[name]...)
+		}
+		return found
+	}
+	last := func(name string) string {
+		found := values(name)
+		if len(found) == 0 {
+			return ""
+		}
+		return found[len(found)-1]
+	}
+	maxDelay, maxSize := overrideLimits(config)
+	overrides := &Overrides{}
+	found := false
+	if value := last("delay"); value != "" {
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("delay: cannot parse time from %q", value)
+		}
+		if delay > maxDelay {
+			return nil, fmt.Errorf("delay: %v is more than the maximum of %v", delay, maxDelay)
+		}
+		overrides.Delay = delay
+		found = true
+	}
+	if value := last("size"); value != "" {
+		size, err := humanize.ParseBytes(value)
+		if err != nil {
+			return nil, fmt.Errorf("size: cannot parse size from %q", value)
+		}
+		if size > maxSize {
+			return nil, fmt.Errorf("size: %dB is more than the maximum of %dB", size, maxSize)
+		}
+		overrides.Size = size
+		overrides.HasSize = true
+		found = true
+	}
+	if value := last("code"); value != "" {
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 100 || code > 999 {
+			return nil, fmt.Errorf("code: %q is not a valid response code", value)
+		}
+		overrides.Code = code
+		found = true
+	}
+	if value := last("body"); value != "" {
+		if !ContainsString(BODY_FORMATS, value) {
+			return nil, fmt.Errorf("body: %q is not one of %v", value, BODY_FORMATS)
+		}
+		overrides.Format = value
+		found = true
+	}
+	if value := last("corrupt"); value != "" {
+		if !ContainsString(CORRUPTIONS, value) {
+			return nil, fmt.Errorf("corrupt: %q is not one of %v", value, CORRUPTIONS)
+		}
+		overrides.Corrupt = value
+		found = true
+	}
+	for _, header := range values("header") {
+		if !strings.Contains(header, ":") {
+			return nil, fmt.Errorf("header: %q is not in the format Key:Value", header)
+		}
+		overrides.Headers = append(overrides.Headers, header)
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+	return overrides, nil
+}
+
+func overrideLimits(config Configuration) (time.Duration, uint64) {
+	maxDelay := parseTime(config.overrides.MaxDelay)
+	if maxDelay == 0 {
+		maxDelay = DEFAULT_MAX_OVERRIDE_DELAY
+	}
+	maxSize := parseSize(config.overrides.MaxSize)
+	if maxSize == 0 {
+		maxSize = DEFAULT_MAX_OVERRIDE_SIZE
+	}
+	return maxDelay, maxSize
+}
+
+// Wrap applies the overrides around the handler, sleeping for the delay
+// before the handler is called and changing what it writes.
+func (instance *Overrides) Wrap(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(instance.Delay)
+		recordSleep(r, instance.Delay)
+		writer := &OverrideResponseWriter{writer: w, overrides: instance}
+		handler(writer, r)
+		writer.finish()
+	}
+}
+
+// OverrideResponseWriter replaces the response code, adds the response
+// headers and, when a size is overridden, replaces the body with one of that
+// size in the format of the response, written at the same pace as the handler
+// writes its own body.  When only the format or corruption is overridden the
+// body is held back until the handler has finished and then rewritten, which
+// a streamed response, one the handler flushes, never does, so it is refused
+// with a 400 instead.
+type OverrideResponseWriter struct {
+	writer      http.ResponseWriter
+	overrides   *Overrides
+	wroteHeader bool
+	hijacked    bool
+	refused     bool
+	code        int
+	body        []byte
+	remaining   int
+	buffer      *bytes.Buffer
+}
+
+func (instance *OverrideResponseWriter) Header() http.Header {
+	return instance.writer.Header()
+}
+
+func (instance *OverrideResponseWriter) WriteHeader(code int) {
+	if instance.wroteHeader {
+		return
+	}
+	instance.wroteHeader = true
+	overrides := instance.overrides
+	for _, header := range overrides.Headers {
+		split := strings.SplitN(header, ":", 2)
+		instance.Header().Set(split[0], split[1])
+	}
+	if overrides.Code != 0 {
+		code = overrides.Code
+	}
+	if overrides.Format != "" {
+		instance.Header().Set("Content-Type", BODY_CONTENT_TYPES[overrides.Format])
+	}
+	if overrides.HasSize {
+		format := contentTypeFormat(instance.Header().Get("Content-Type"))
+		instance.body = CorruptBody(GenerateBody(format, int(overrides.Size)), overrides.Corrupt)
+		instance.remaining = len(instance.body)
+		instance.Header().Set("Content-Length", strconv.Itoa(len(instance.body)))
+	} else if overrides.Format != "" || overrides.Corrupt != "" {
+		instance.code = code
+		instance.buffer = &bytes.Buffer{}
+		return
+	}
+	instance.writer.WriteHeader(code)
+}
+
+func (instance *OverrideResponseWriter) Write(data []byte) (int, error) {
+	if !instance.wroteHeader {
+		instance.WriteHeader(http.StatusOK)
+	}
+	if instance.refused {
+		return 0, errOverrideStreamed
+	}
+	if instance.buffer != nil {
+		return instance.buffer.Write(data)
+	}
+	if !instance.overrides.HasSize {
+		return instance.writer.Write(data)
+	}
+	size := len(data)
+	if size > instance.remaining {
+		size = instance.remaining
+	}
+	start := len(instance.body) - instance.remaining
+	instance.remaining -= size
+	if _, err := instance.writer.Write(instance.body[start : start+size]); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (instance *OverrideResponseWriter) Flush() {
+	if instance.buffer != nil {
+		instance.refuse()
+		return
+	}
+	if instance.refused {
+		return
+	}
+	if flusher, ok := instance.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (instance *OverrideResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := instance.writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the connection cannot be hijacked")
+	}
+	instance.hijacked = true
+	return hijacker.Hijack()
+}
+
+// refuse responds with a 400 in place of the held back response.
+func (instance *OverrideResponseWriter) refuse() {
+	instance.refused = true
+	instance.buffer = nil
+	instance.Header().Del("Content-Length")
+	http.Error(instance.writer, errOverrideStreamed.Error(), http.StatusBadRequest)
+}
+
+// finish writes whatever the handler did not, so that a handler which writes
+// no body still returns a body of the overridden size, and writes the held
+// back body in the overridden format and corrupted.
+func (instance *OverrideResponseWriter) finish() {
+	if instance.hijacked || instance.refused {
+		return
+	}
+	if !instance.wroteHeader {
+		instance.WriteHeader(http.StatusOK)
+	}
+	if instance.buffer != nil {
+		body := instance.buffer.Bytes()
+		if instance.overrides.Format != "" {
+			body = GenerateBody(instance.overrides.Format, len(body))
+		}
+		body = CorruptBody(body, instance.overrides.Corrupt)
+		if instance.Header().Get("Content-Length") != "" {
+			instance.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		instance.writer.WriteHeader(instance.code)
+		instance.writer.Write(body)
+		return
+	}
+	if instance.remaining > 0 {
+		start := len(instance.body) - instance.remaining
+		instance.writer.Write(instance.body[start:])
+		instance.remaining = 0
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Overrides", func() {
+
+	var router *Router
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		route, _ := NewRoute(RouteArgs{Path: "/orders", Behaviours: []BehaviourArgs{{Content: "orders"}}})
+		config := Configuration{routes: []*Route{route}, overrides: OverrideArgs{MaxDelay: "1s", MaxSize: "1KB"}}
+		router = NewRouter(NewLiveProfile(Profile{Config: config}), nil)
+		router.HandleFunc("/success", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("hello world"))
+		})
+	})
+
+	It("replaces the response code", func() {
+		Expect(serve(newRequest("GET", "/success?code=503")).Code).To(Equal(503))
+	})
+
+	It("replaces the body with one of the given size", func() {
+		recorder := serve(newRequest("GET", "/success?size=20B"))
+		Expect(recorder.Body.Len()).To(Equal(20))
+		Expect(recorder.Header().Get("Content-Length")).To(Equal("20"))
+	})
+
+	It("rewrites the body in the format", func() {
+		recorder := serve(newRequest("GET", "/success?body=json"))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Body.Len()).To(Equal(len("hello world")))
+		Expect(json.Valid(recorder.Body.Bytes())).To(BeTrue())
+	})
+
+	It("corrupts the body of the given size", func() {
+		request := newRequest("GET", "/success?size=100B")
+		request.Header.Set("X-Enanos-Corrupt", "bom")
+		request.Header.Set("X-Enanos-Body", "xml")
+		recorder := serve(request)
+		Expect(recorder.Body.Bytes()[:3]).To(Equal(UTF8_BOM))
+		Expect(recorder.Body.Len()).To(Equal(103))
+		Expect(recorder.Header().Get("Content-Length")).To(Equal("103"))
+		Expect(string(recorder.Body.Bytes()[3:])).To(HavePrefix("<?xml"))
+	})
+
+	It("refuses to rewrite the body of a streamed response", func() {
+		writes := 0
+		router.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for writes < 100 {
+				if _, err := w.Write([]byte("event")); err != nil {
+					return
+				}
+				writes++
+			}
+		})
+		recorder := serve(newRequest("GET", "/stream?corrupt=truncated"))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(writes).To(Equal(0))
+		Expect(serve(newRequest("GET", "/stream?size=10B")).Body.String()).To(HaveLen(10))
+	})
+
+	It("adds the response headers", func() {
+		recorder := serve(newRequest("GET", "/success?header=X-Foo:bar&header=X-Bar:foo"))
+		Expect(recorder.Header().Get("X-Foo")).To(Equal("bar"))
+		Expect(recorder.Header().Get("X-Bar")).To(Equal("foo"))
+	})
+
+	It("delays the response", func() {
+		start := time.Now()
+		serve(newRequest("GET", "/success?delay=50ms"))
+		Expect(time.Since(start) >= 50*time.Millisecond).To(BeTrue())
+	})
+
+	It("accepts the overrides as headers", func() {
+		r := newRequest("GET", "/orders")
+		r.Header.Set("X-Enanos-Code", "418")
+		r.Header.Set("X-Enanos-Size", "5B")
+		recorder := serve(r)
+		Expect(recorder.Code).To(Equal(418))
+		Expect(recorder.Body.String()).To(Equal("-----"))
+	})
+
+	It("ignores query parameters on routes", func() {
+		recorder := serve(newRequest("GET", "/orders?code=503"))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("orders"))
+	})
+
+	It("rejects overrides above the limits", func() {
+		Expect(serve(newRequest("GET", "/success?delay=2s")).Code).To(Equal(http.StatusBadRequest))
+		Expect(serve(newRequest("GET", "/success?size=2KB")).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects overrides which cannot be parsed", func() {
+		Expect(serve(newRequest("GET", "/success?code=bang")).Code).To(Equal(http.StatusBadRequest))
+		Expect(serve(newRequest("GET", "/success?header=bang")).Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Profile is the set of collaborators which together decide how the fault
+// endpoints behave.
+type Profile struct {
+	Config                Configuration
+	ResponseBodyGenerator ResponseBodyGenerator
+	ResponseCodeGenerator ResponseCodeGenerator
+	Snoozer               Snoozer
+	Chaos                 *MixBehaviour
+}
+
+// NewProfile creates a Profile using the real generators described by config.
+func NewProfile(config Configuration) Profile {
+	return Profile{
+		Config:                config,
+		ResponseBodyGenerator: createResponseBodyGenerator(config),
+		ResponseCodeGenerator: NewRandomResponseCodeGenerator(responseCodes_300, responseCodes_400, responseCodes_500),
+		Snoozer:               createSnoozer(config),
+		Chaos:                 createChaos(config),
+	}
+}
+
+// createChaos returns the configured mix of the /chaos endpoint or, when there
+// is none, DEFAULT_CHAOS.
+func createChaos(config Configuration) *MixBehaviour {
+	if config.chaos != nil {
+		return config.chaos
+	}
+	mix, err := NewMixBehaviour(DEFAULT_CHAOS, NewRealRandom())
+	if err != nil {
+		panic(fmt.Sprintf("DEFAULT_CHAOS is invalid: %v", err))
+	}
+	return mix
+}
+
+func createSnoozer(config Configuration) Snoozer {
+	switch config.latency.Distribution {
+	case DISTRIBUTION_UNIFORM:
+		return NewRandomSnoozer(config.minWait, config.maxWait)
+	case DISTRIBUTION_MAX:
+		return NewMaxSnoozer(config.maxWait)
+	case "":
+	default:
+		snoozer, err := NewDistributionSnoozer(config.latency, config.minWait, config.maxWait, NewRealRandom())
+		if err == nil {
+			return snoozer
+		}
+	}
+	if config.randomWait {
+		return NewRandomSnoozer(config.minWait, config.maxWait)
+	}
+	return NewMaxSnoozer(config.maxWait)
+}
+
+func createResponseBodyGenerator(config Configuration) ResponseBodyGenerator {
+	if config.randomSize {
+		return NewRandomResponseBodyGenerator(int(config.minSize), int(config.maxSize))
+	}
+	return NewMaxResponseBodyGenerator(int(config.maxSize))
+}
+
+// LiveProfile holds the Profile currently in use and allows it to be swapped
+// while requests are being served.
+type LiveProfile struct {
+	mutex   sync.RWMutex
+	current Profile
+}
+
+func (instance *LiveProfile) Current() Profile {
+	instance.mutex.RLock()
+	defer instance.mutex.RUnlock()
+	return instance.current
+}
+
+func (instance *LiveProfile) Swap(profile Profile) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.current = profile
+}
+
+func NewLiveProfile(profile Profile) *LiveProfile {
+	return &LiveProfile{current: profile}
+}
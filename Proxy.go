@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// PROXY_DEFAULT_TIMEOUT is the timeout when none is configured.
+	PROXY_DEFAULT_TIMEOUT time.Duration = 30 * time.Second
+	// The timeouts for connecting to the upstream and for its TLS handshake.
+	PROXY_DIAL_TIMEOUT      time.Duration = 10 * time.Second
+	PROXY_HANDSHAKE_TIMEOUT time.Duration = 10 * time.Second
+)
+
+var (
+	// Headers which only apply to a single connection and must not be forwarded.
+	hopByHopHeaders []string = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+)
+
+// ProxyArgs is the configuration file form of how requests are forwarded to the
+// upstream.  The timeout covers the whole exchange with the upstream, from
+// connecting to reading the end of its body.
+type ProxyArgs struct {
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (instance ProxyArgs) timeout() time.Duration {
+	if timeout := parseTime(instance.Timeout); timeout > 0 {
+		return timeout
+	}
+	return PROXY_DEFAULT_TIMEOUT
+}
+
+func validateProxy(args ProxyArgs) error {
+	if args.Timeout == "" {
+		return nil
+	}
+	if timeout, err := time.ParseDuration(args.Timeout); err != nil || timeout < 0 {
+		return fmt.Errorf("proxy: cannot parse time from timeout %q", args.Timeout)
+	}
+	return nil
+}
+
+// ProxyBehaviour forwards the request to the configured upstream and uses its
+// response, so that later behaviours are applied on top of a real response.
+type ProxyBehaviour struct {
+	client *http.Client
+}
+
+func (instance *ProxyBehaviour) Apply(exchange *Exchange) {
+	upstream := exchange.Profile.Config.upstream
+	if upstream == nil {
+		exchange.Code = http.StatusBadGateway
+		exchange.Body = []byte("no upstream is configured")
+		return
+	}
+	r := exchange.Request
+	target := *upstream
+	target.Path = strings.TrimSuffix(upstream.Path, "/") + r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+	ctx, cancel := context.WithTimeout(r.Context(), exchange.Profile.Config.proxy.timeout())
+	defer cancel()
+	request, err := http.NewRequest(r.Method, target.String(), r.Body)
+	if err != nil {
+		exchange.Code = http.StatusBadGateway
+		exchange.Body = []byte(err.Error())
+		return
+	}
+	copyHeaders(request.Header, r.Header)
+	for key := range request.Header {
+		//Overrides are for enanos, not the upstream
+		if strings.HasPrefix(key, OVERRIDE_HEADER_PREFIX) {
+			request.Header.Del(key)
+		}
+	}
+	request.ContentLength = r.ContentLength
+	response, err := instance.client.Do(request.WithContext(ctx))
+	if err != nil {
+		exchange.Code = http.StatusBadGateway
+		exchange.Body = []byte(err.Error())
+		return
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		exchange.Code = http.StatusBadGateway
+		exchange.Body = []byte(err.Error())
+		return
+	}
+	if fixtures := exchange.Profile.Config.fixtures; fixtures.Recording() {
+		fixtures.Record(NewFixture(r, response.StatusCode, response.Header, body))
+	}
+	copyHeaders(exchange.Header(), response.Header)
+	exchange.Header().Del("Content-Length")
+	exchange.Code = response.StatusCode
+	exchange.Body = body
+}
+
+func copyHeaders(to http.Header, from http.Header) {
+	for key, values := range from {
+		if containsHeader(hopByHopHeaders, key) {
+			continue
+		}
+		to.Del(key)
+		for _, value := range values {
+			to.Add(key, value)
+		}
+	}
+}
+
+func containsHeader(headers []string, header string) bool {
+	for _, item := range headers {
+		if strings.EqualFold(item, header) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewProxyBehaviour() *ProxyBehaviour {
+	return &ProxyBehaviour{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: PROXY_DIAL_TIMEOUT, KeepAlive: 30 * time.Second}).DialContext,
+				TLSHandshakeTimeout:   PROXY_HANDSHAKE_TIMEOUT,
+				MaxIdleConnsPerHost:   32,
+				IdleConnTimeout:       90 * time.Second,
+				ExpectContinueTimeout: time.Second,
+			},
+			CheckRedirect: func(r *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// TruncateBehaviour cuts the body short while still declaring its full length,
+// so the client sees the connection close part way through the body.
+type TruncateBehaviour struct {
+	Percent float64
+	Bytes   uint64
+}
+
+func (instance *TruncateBehaviour) Apply(exchange *Exchange) {
+	recordFault(exchange.Request, "truncate")
+	length := len(exchange.Body)
+	keep := length
+	if instance.Percent > 0 {
+		keep = int(float64(length) * instance.Percent / 100)
+	} else if instance.Bytes < uint64(length) {
+		keep = int(instance.Bytes)
+	}
+	if keep >= length {
+		return
+	}
+	exchange.Header().Set("Content-Length", strconv.Itoa(length))
+	exchange.Body = exchange.Body[:keep]
+}
+
+// NewTruncateBehaviour accepts either a percentage of the body to keep, e.g.
+// 50%, or a size, e.g. 1KB.
+func NewTruncateBehaviour(value string) (*TruncateBehaviour, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return nil, fmt.Errorf("truncate: %q is not a percentage between 0 and 100", value)
+		}
+		return &TruncateBehaviour{Percent: percent}, nil
+	}
+	bytes, err := humanize.ParseBytes(value)
+	if err != nil {
+		return nil, fmt.Errorf("truncate: cannot parse size from %q", value)
+	}
+	return &TruncateBehaviour{Bytes: bytes}, nil
+}
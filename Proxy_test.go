@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProxyBehaviour", func() {
+
+	var upstream *httptest.Server
+	var profile Profile
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", r.URL.Path+"?"+r.URL.RawQuery)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("0123456789"))
+		}))
+		upstreamUrl, _ := url.Parse(upstream.URL)
+		profile = Profile{Config: Configuration{upstream: upstreamUrl}}
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+	})
+
+	It("uses the response from the upstream", func() {
+		recorder := httptest.NewRecorder()
+		exchange := NewExchange(recorder, newRequest("GET", "/orders/1?expand=true"), nil, profile)
+		NewProxyBehaviour().Apply(exchange)
+		exchange.Respond()
+		Expect(recorder.Code).To(Equal(http.StatusCreated))
+		Expect(recorder.Header().Get("X-Upstream")).To(Equal("/orders/1?expand=true"))
+		Expect(recorder.Body.String()).To(Equal("0123456789"))
+	})
+
+	It("returns 502 when there is no upstream", func() {
+		recorder := httptest.NewRecorder()
+		exchange := NewExchange(recorder, newRequest("GET", "/orders/1"), nil, Profile{})
+		NewProxyBehaviour().Apply(exchange)
+		exchange.Respond()
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("returns 502 when the upstream stalls for longer than the timeout", func() {
+		release := make(chan struct{})
+		stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer stalled.Close()
+		defer close(release)
+		stalledUrl, _ := url.Parse(stalled.URL)
+		recorder := httptest.NewRecorder()
+		config := Configuration{upstream: stalledUrl, proxy: ProxyArgs{Timeout: "50ms"}}
+		exchange := NewExchange(recorder, newRequest("GET", "/orders/1"), nil, Profile{Config: config})
+		start := time.Now()
+		NewProxyBehaviour().Apply(exchange)
+		exchange.Respond()
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("rejects an invalid timeout", func() {
+		Expect(validateProxy(ProxyArgs{Timeout: "soon"})).ToNot(BeNil())
+		Expect(validateProxy(ProxyArgs{Timeout: "-1s"})).ToNot(BeNil())
+		Expect(ProxyArgs{}.timeout()).To(Equal(PROXY_DEFAULT_TIMEOUT))
+	})
+
+	It("applies later behaviours on top of the upstream response", func() {
+		route, err := NewRoute(RouteArgs{
+			Path:       "/orders/{id}",
+			Behaviours: []BehaviourArgs{{Proxy: true}, {Status: 503}},
+		})
+		Expect(err).To(BeNil())
+		recorder := httptest.NewRecorder()
+		route.Serve(recorder, newRequest("GET", "/orders/1"), nil, profile)
+		Expect(recorder.Code).To(Equal(503))
+		Expect(recorder.Body.String()).To(Equal("0123456789"))
+	})
+
+	It("forwards requests which do not match anything when an upstream is configured", func() {
+		router := NewRouter(NewLiveProfile(profile), nil)
+		server := httptest.NewServer(router)
+		defer server.Close()
+		resp, err := http.Get(server.URL + "/anything")
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+	})
+})
+
+var _ = Describe("TruncateBehaviour", func() {
+
+	serve := func(truncate string) (*http.Response, error) {
+		route, _ := NewRoute(RouteArgs{
+			Path:       "/",
+			Behaviours: []BehaviourArgs{{Content: "0123456789"}, {Truncate: truncate}},
+		})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route.Serve(w, r, nil, Profile{})
+		}))
+		defer server.Close()
+		return http.Get(server.URL)
+	}
+
+	It("declares the full length but closes the connection part way through the body", func() {
+		resp, err := serve("50%")
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+		Expect(resp.ContentLength).To(Equal(int64(10)))
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal("01234"))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("keeps a number of bytes", func() {
+		resp, err := serve("3B")
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal("012"))
+	})
+
+	It("rejects an invalid percentage", func() {
+		_, err := NewTruncateBehaviour("150%")
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -2,33 +2,61 @@ package main
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
 type Random interface {
 	Int(from int, to int) (randomInt int)
 	Duration(from time.Duration, to time.Duration) time.Duration
+	Float64() float64
+	NormFloat64() float64
+	ExpFloat64() float64
 }
 
-type RealRandom struct{}
+type RealRandom struct {
+	mutex  sync.Mutex
+	source *rand.Rand
+}
 
 func (instance *RealRandom) Int(min int, max int) (randomInt int) {
-	rand.Seed(time.Now().Unix())
-	return rand.Intn(max-min) + min
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.source.Intn(max-min) + min
 }
 
 func (instance *RealRandom) Duration(min time.Duration, max time.Duration) time.Duration {
-	rand.Seed(time.Now().Unix())
-	return time.Duration(rand.Int63n(int64(max-min) + int64(min)))
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return time.Duration(instance.source.Int63n(int64(max-min)) + int64(min))
+}
+
+func (instance *RealRandom) Float64() float64 {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.source.Float64()
+}
+
+func (instance *RealRandom) NormFloat64() float64 {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.source.NormFloat64()
+}
+
+func (instance *RealRandom) ExpFloat64() float64 {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.source.ExpFloat64()
 }
 
 func NewRealRandom() *RealRandom {
-	return &RealRandom{}
+	return &RealRandom{source: rand.New(rand.NewSource(time.Now().UnixNano()))}
 }
 
 type FakeRandom struct {
 	number   int
 	duration time.Duration
+	float    float64
 }
 
 func (instance *FakeRandom) Int(from int, to int) (randomInt int) {
@@ -39,6 +67,18 @@ func (instance *FakeRandom) Duration(from time.Duration, to time.Duration) time.
 	return instance.duration
 }
 
+func (instance *FakeRandom) Float64() float64 {
+	return instance.float
+}
+
+func (instance *FakeRandom) NormFloat64() float64 {
+	return instance.float
+}
+
+func (instance *FakeRandom) ExpFloat64() float64 {
+	return instance.float
+}
+
 func (instance *FakeRandom) ForIntUse(value int) {
 	instance.number = value
 }
@@ -47,6 +87,10 @@ func (instance *FakeRandom) ForDurationUse(value time.Duration) {
 	instance.duration = value
 }
 
+func (instance *FakeRandom) ForFloatUse(value float64) {
+	instance.float = value
+}
+
 func NewFakeRandom() (random *FakeRandom) {
-	return &FakeRandom{0, 0}
+	return &FakeRandom{0, 0, 0}
 }
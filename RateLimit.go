@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_TOKEN_BUCKET   string = "token_bucket"
+	RATE_LIMIT_FIXED_WINDOW   string = "fixed_window"
+	RATE_LIMIT_SLIDING_WINDOW string = "sliding_window"
+
+	FAULT_RATE_LIMIT string = "rate_limit"
+)
+
+var (
+	RATE_LIMIT_ALGORITHMS []string = []string{RATE_LIMIT_TOKEN_BUCKET, RATE_LIMIT_FIXED_WINDOW, RATE_LIMIT_SLIDING_WINDOW}
+)
+
+// RateLimitArgs is the configuration file form of a RateLimitBehaviour.  Each
+// client, told apart by the key in the same way as a retry, may make limit
+// requests per window.  A token bucket refills at limit per window and holds
+// up to burst tokens, which defaults to limit.
+type RateLimitArgs struct {
+	Algorithm string `json:"algorithm,omitempty"`
+	Limit     int    `json:"limit"`
+	Window    string `json:"window,omitempty"`
+	Burst     int    `json:"burst,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Status    int    `json:"status,omitempty"`
+}
+
+// RateLimitDecision is the outcome of one request against a client's limit.
+// Reset is how long until the full limit is available again and RetryAfter
+// how long until the next request would be allowed.
+type RateLimitDecision struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// rateLimitState is the accounting of one client for one of the algorithms.
+type rateLimitState interface {
+	take(now time.Time) RateLimitDecision
+	idle(now time.Time) bool
+}
+
+type tokenBucket struct {
+	capacity float64
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+func (instance *tokenBucket) take(now time.Time) RateLimitDecision {
+	instance.tokens = math.Min(instance.capacity, instance.tokens+float64(now.Sub(instance.last))/float64(instance.interval))
+	instance.last = now
+	decision := RateLimitDecision{}
+	if instance.tokens >= 1 {
+		instance.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = time.Duration((1 - instance.tokens) * float64(instance.interval))
+	}
+	decision.Remaining = int(instance.tokens)
+	decision.Reset = time.Duration((instance.capacity - instance.tokens) * float64(instance.interval))
+	return decision
+}
+
+func (instance *tokenBucket) idle(now time.Time) bool {
+	return now.Sub(instance.last) >= time.Duration(instance.capacity*float64(instance.interval))
+}
+
+type fixedWindow struct {
+	limit  int
+	window time.Duration
+	start  time.Time
+	count  int
+}
+
+func (instance *fixedWindow) take(now time.Time) RateLimitDecision {
+	if now.Sub(instance.start) >= instance.window {
+		instance.start = now.Truncate(instance.window)
+		instance.count = 0
+	}
+	decision := RateLimitDecision{Reset: instance.start.Add(instance.window).Sub(now)}
+	if instance.count < instance.limit {
+		instance.count++
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = decision.Reset
+	}
+	decision.Remaining = instance.limit - instance.count
+	return decision
+}
+
+func (instance *fixedWindow) idle(now time.Time) bool {
+	return now.Sub(instance.start) >= instance.window
+}
+
+// slidingWindow keeps the time of each allowed request within the window, so
+// that a client can never make more than limit requests in any window.
+type slidingWindow struct {
+	limit  int
+	window time.Duration
+	times  []time.Time
+}
+
+func (instance *slidingWindow) take(now time.Time) RateLimitDecision {
+	expired := 0
+	for expired < len(instance.times) && now.Sub(instance.times[expired]) >= instance.window {
+		expired++
+	}
+	instance.times = instance.times[expired:]
+	decision := RateLimitDecision{}
+	if len(instance.times) < instance.limit {
+		instance.times = append(instance.times, now)
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = instance.times[0].Add(instance.window).Sub(now)
+	}
+	decision.Remaining = instance.limit - len(instance.times)
+	decision.Reset = instance.times[len(instance.times)-1].Add(instance.window).Sub(now)
+	return decision
+}
+
+func (instance *slidingWindow) idle(now time.Time) bool {
+	return len(instance.times) == 0 || now.Sub(instance.times[len(instance.times)-1]) >= instance.window
+}
+
+// RateLimitBehaviour keeps the rate of requests from each client within a
+// limit, rejecting the rest with a 429 or 503 and the Retry-After,
+// X-RateLimit-* and RateLimit-* headers which clients use to back off.
+type RateLimitBehaviour struct {
+	Args    RateLimitArgs
+	Window  time.Duration
+	mutex   sync.Mutex
+	clients map[string]rateLimitState
+	swept   time.Time
+	clock   Clock
+}
+
+func (instance *RateLimitBehaviour) Apply(exchange *Exchange) {
+	now := instance.clock.Now()
+	decision := instance.take(attemptKey(instance.Args.Key, exchange.Request), now)
+	limit := instance.Args.Limit
+	if instance.Args.Algorithm == RATE_LIMIT_TOKEN_BUCKET {
+		limit = instance.Args.Burst
+	}
+	reset := ceilSeconds(decision.Reset)
+	header := exchange.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+reset, 10))
+	header.Set("RateLimit-Limit", strconv.Itoa(limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	header.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", instance.Args.Limit, ceilSeconds(instance.Window)))
+	if decision.Allowed {
+		return
+	}
+	recordFault(exchange.Request, FAULT_RATE_LIMIT)
+	retryAfter := ceilSeconds(decision.RetryAfter)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	header.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	exchange.Code = instance.Args.Status
+	exchange.Body = []byte(http.StatusText(instance.Args.Status))
+	exchange.Respond()
+}
+
+func (instance *RateLimitBehaviour) take(key string, now time.Time) RateLimitDecision {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if now.Sub(instance.swept) >= instance.Window {
+		for client, state := range instance.clients {
+			if state.idle(now) {
+				delete(instance.clients, client)
+			}
+		}
+		instance.swept = now
+	}
+	state, ok := instance.clients[key]
+	if !ok {
+		state = instance.newState(now)
+		instance.clients[key] = state
+	}
+	return state.take(now)
+}
+
+func (instance *RateLimitBehaviour) newState(now time.Time) rateLimitState {
+	switch instance.Args.Algorithm {
+	case RATE_LIMIT_FIXED_WINDOW:
+		return &fixedWindow{limit: instance.Args.Limit, window: instance.Window}
+	case RATE_LIMIT_SLIDING_WINDOW:
+		return &slidingWindow{limit: instance.Args.Limit, window: instance.Window}
+	}
+	return &tokenBucket{
+		capacity: float64(instance.Args.Burst),
+		interval: instance.Window / time.Duration(instance.Args.Limit),
+		tokens:   float64(instance.Args.Burst),
+		last:     now,
+	}
+}
+
+func ceilSeconds(duration time.Duration) int64 {
+	return int64((duration + time.Second - 1) / time.Second)
+}
+
+func NewRateLimitBehaviour(args RateLimitArgs, clock Clock) (*RateLimitBehaviour, error) {
+	if args.Algorithm == "" {
+		args.Algorithm = RATE_LIMIT_TOKEN_BUCKET
+	}
+	if !ContainsString(RATE_LIMIT_ALGORITHMS, args.Algorithm) {
+		return nil, fmt.Errorf("ratelimit: %q is not one of %v", args.Algorithm, RATE_LIMIT_ALGORITHMS)
+	}
+	if args.Limit <= 0 {
+		return nil, fmt.Errorf("ratelimit: limit must be greater than 0")
+	}
+	if args.Window == "" {
+		args.Window = "1s"
+	}
+	window, err := time.ParseDuration(args.Window)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("ratelimit: window: cannot parse time from %q", args.Window)
+	}
+	if args.Burst < 0 {
+		return nil, fmt.Errorf("ratelimit: burst cannot be negative")
+	}
+	if args.Burst == 0 {
+		args.Burst = args.Limit
+	}
+	if args.Key == "" {
+		args.Key = RETRY_KEY_IP
+	}
+	if err := validateAttemptKey(args.Key); err != nil {
+		return nil, fmt.Errorf("ratelimit: %v", err)
+	}
+	if args.Status == 0 {
+		args.Status = http.StatusTooManyRequests
+	}
+	if args.Status != http.StatusTooManyRequests && args.Status != http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("ratelimit: status must be 429 or 503")
+	}
+	return &RateLimitBehaviour{
+		Args:    args,
+		Window:  window,
+		clients: map[string]rateLimitState{},
+		clock:   clock,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimit", func() {
+
+	var clock *FakeClock
+	var behaviour *RateLimitBehaviour
+
+	limit := func(args RateLimitArgs) {
+		behaviour, _ = NewRateLimitBehaviour(args, clock)
+	}
+
+	request := func(remote string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := newRequest("GET", "/")
+		r.RemoteAddr = remote
+		exchange := NewExchange(recorder, r, nil, Profile{})
+		exchange.Code = 200
+		behaviour.Apply(exchange)
+		exchange.Respond()
+		return recorder
+	}
+
+	BeforeEach(func() {
+		clock = NewFakeClock()
+	})
+
+	It("refills a token bucket at limit per window", func() {
+		limit(RateLimitArgs{Limit: 2, Window: "10s"})
+		Expect(request("10.0.0.1:1").Code).To(Equal(200))
+		Expect(request("10.0.0.1:1").Code).To(Equal(200))
+		response := request("10.0.0.1:1")
+		Expect(response.Code).To(Equal(429))
+		Expect(response.Header().Get("Retry-After")).To(Equal("5"))
+		Expect(response.Header().Get("RateLimit-Remaining")).To(Equal("0"))
+		Expect(response.Header().Get("RateLimit-Reset")).To(Equal("10"))
+		clock.Advance(5 * time.Second)
+		Expect(request("10.0.0.1:1").Code).To(Equal(200))
+	})
+
+	It("allows a burst above the limit from a token bucket", func() {
+		limit(RateLimitArgs{Limit: 1, Window: "1s", Burst: 3})
+		Expect([]int{request("10.0.0.1:1").Code, request("10.0.0.1:1").Code, request("10.0.0.1:1").Code, request("10.0.0.1:1").Code}).To(Equal([]int{200, 200, 200, 429}))
+	})
+
+	It("resets a fixed window at the end of the window", func() {
+		limit(RateLimitArgs{Algorithm: RATE_LIMIT_FIXED_WINDOW, Limit: 1, Window: "1m", Status: 503})
+		clock.Advance(40 * time.Second)
+		response := request("10.0.0.1:1")
+		Expect(response.Header().Get("X-RateLimit-Limit")).To(Equal("1"))
+		Expect(response.Header().Get("X-RateLimit-Remaining")).To(Equal("0"))
+		Expect(response.Header().Get("X-RateLimit-Reset")).To(Equal("1451606460"))
+		response = request("10.0.0.1:1")
+		Expect(response.Code).To(Equal(503))
+		Expect(response.Header().Get("Retry-After")).To(Equal("20"))
+		clock.Advance(20 * time.Second)
+		Expect(request("10.0.0.1:1").Code).To(Equal(200))
+	})
+
+	It("never allows more than the limit in any sliding window", func() {
+		limit(RateLimitArgs{Algorithm: RATE_LIMIT_SLIDING_WINDOW, Limit: 2, Window: "1m"})
+		request("10.0.0.1:1")
+		clock.Advance(30 * time.Second)
+		request("10.0.0.1:1")
+		clock.Advance(29 * time.Second)
+		response := request("10.0.0.1:1")
+		Expect(response.Code).To(Equal(429))
+		Expect(response.Header().Get("Retry-After")).To(Equal("1"))
+		clock.Advance(time.Second)
+		Expect(request("10.0.0.1:1").Code).To(Equal(200))
+	})
+
+	It("limits each client separately", func() {
+		limit(RateLimitArgs{Limit: 1, Window: "1m"})
+		Expect(request("10.0.0.1:1").Code).To(Equal(200))
+		Expect(request("10.0.0.2:1").Code).To(Equal(200))
+		Expect(request("10.0.0.1:2").Code).To(Equal(429))
+	})
+
+	It("rejects a status other than 429 or 503", func() {
+		_, err := NewRateLimitBehaviour(RateLimitArgs{Limit: 1, Status: 500}, clock)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects an unknown algorithm", func() {
+		_, err := NewRateLimitBehaviour(RateLimitArgs{Limit: 1, Algorithm: "leaky"}, clock)
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RECORDED_BODY_LIMIT int64  = 64 * 1024
+	REQUEST_ID_HEADER   string = "X-Request-Id"
+)
+
+type requestRecordKey struct{}
+
+// RecordedRequest is what enanos received and how it responded.  The ID is
+// taken from the X-Request-Id request header or generated, the body is
+// limited to the first RECORDED_BODY_LIMIT bytes, the Endpoint is the built
+// in endpoint or route pattern which matched and the Fault is the name of the
+// endpoint or fault which was applied, if any.  A Code of 0 means the response
+// was written directly to the connection by a connection fault, whose bytes
+// are not counted.  A Pending request is still being served and has no
+// outcome yet.
+type RecordedRequest struct {
+	ID       string        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Remote   string        `json:"remote"`
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Query    string        `json:"query"`
+	Proto    string        `json:"proto"`
+	Headers  http.Header   `json:"headers"`
+	Body     string        `json:"body"`
+	Endpoint string        `json:"endpoint"`
+	Fault    string        `json:"fault"`
+	Code     int           `json:"code"`
+	Bytes    int           `json:"bytes"`
+	Duration string        `json:"duration"`
+	Sleep    string        `json:"sleep"`
+	Pending  bool          `json:"pending,omitempty"`
+	Elapsed  time.Duration `json:"-"`
+	Slept    time.Duration `json:"-"`
+}
+
+// NewRecordedRequest starts the record of a request.  A request body of known
+// length is read up front and replaced so that the handler can still read it,
+// while a streamed body, such as that of a gRPC call, is recorded as the
+// handler reads it.
+func NewRecordedRequest(r *http.Request) *RecordedRequest {
+	id := r.Header.Get(REQUEST_ID_HEADER)
+	if id == "" {
+		id = newRequestID()
+	}
+	record := &RecordedRequest{
+		ID:      id,
+		Time:    time.Now(),
+		Remote:  r.RemoteAddr,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Proto:   r.Proto,
+		Headers: r.Header,
+	}
+	if r.Body != nil && r.ContentLength >= 0 {
+		body, _ := ioutil.ReadAll(io.LimitReader(r.Body, RECORDED_BODY_LIMIT))
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		record.Body = string(body)
+	} else if r.Body != nil {
+		r.Body = &recordedBody{ReadCloser: r.Body, record: record}
+	}
+	return record
+}
+
+// recordedBody copies the first RECORDED_BODY_LIMIT bytes read from a request
+// body to its record.
+type recordedBody struct {
+	io.ReadCloser
+	record *RecordedRequest
+	body   []byte
+}
+
+func (instance *recordedBody) Read(p []byte) (int, error) {
+	n, err := instance.ReadCloser.Read(p)
+	if room := int(RECORDED_BODY_LIMIT) - len(instance.body); room > 0 && n > 0 {
+		if n < room {
+			room = n
+		}
+		instance.body = append(instance.body, p[:room]...)
+		instance.record.Body = string(instance.body)
+	}
+	return n, err
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// RequestObserver is told about each request once it has been served.
+type RequestObserver interface {
+	Observe(record *RecordedRequest)
+}
+
+// RequestStartObserver is also told about each request as soon as it arrives,
+// so that requests which are never answered can be seen.
+type RequestStartObserver interface {
+	RequestObserver
+	Start(record *RecordedRequest)
+}
+
+// RequestFilter selects recorded requests.  Empty fields match everything and
+// a Path ending in * matches any path with that prefix.
+type RequestFilter struct {
+	Method string
+	Path   string
+	Fault  string
+	Code   int
+	Header string
+	Since  time.Time
+}
+
+func (instance RequestFilter) Matches(record RecordedRequest) bool {
+	if instance.Method != "" && !strings.EqualFold(instance.Method, record.Method) {
+		return false
+	}
+	if strings.HasSuffix(instance.Path, "*") {
+		if !strings.HasPrefix(record.Path, strings.TrimSuffix(instance.Path, "*")) {
+			return false
+		}
+	} else if instance.Path != "" && instance.Path != record.Path {
+		return false
+	}
+	if instance.Fault != "" && instance.Fault != record.Fault {
+		return false
+	}
+	if instance.Code != 0 && instance.Code != record.Code {
+		return false
+	}
+	if instance.Header != "" {
+		split := strings.SplitN(instance.Header, ":", 2)
+		values, ok := record.Headers[http.CanonicalHeaderKey(split[0])]
+		if !ok || (len(split) == 2 && !containsHeader(values, split[1])) {
+			return false
+		}
+	}
+	return !record.Time.Before(instance.Since)
+}
+
+// NewRequestFilter reads a filter from the query parameters method, path,
+// fault, code, header (Key or Key:Value) and since (RFC 3339).
+func NewRequestFilter(values map[string][]string) (RequestFilter, error) {
+	get := func(name string) string {
+		if len(values[name]) == 0 {
+			return ""
+		}
+		return values[name][0]
+	}
+	filter := RequestFilter{
+		Method: get("method"),
+		Path:   get("path"),
+		Fault:  get("fault"),
+		Header: get("header"),
+	}
+	if value := get("code"); value != "" {
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.Code = code
+	}
+	if value := get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return filter, err
+		}
+		filter.Since = since
+	}
+	return filter, nil
+}
+
+// RequestRecorder keeps the most recent requests in a ring buffer so that
+// tests can assert on what their service actually sent.  Requests are added
+// as pending when they arrive and their outcome is filled in once served.
+type RequestRecorder struct {
+	mutex    sync.Mutex
+	requests []RecordedRequest
+	owners   []*RecordedRequest
+	pending  map[*RecordedRequest]int
+	next     int
+	full     bool
+}
+
+func (instance *RequestRecorder) Start(record *RecordedRequest) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	started := *record
+	started.Pending = true
+	if slot, ok := instance.add(started); ok {
+		instance.owners[slot] = record
+		instance.pending[record] = slot
+	}
+}
+
+func (instance *RequestRecorder) Observe(record *RecordedRequest) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if slot, ok := instance.pending[record]; ok {
+		delete(instance.pending, record)
+		instance.owners[slot] = nil
+		instance.requests[slot] = *record
+		return
+	}
+	instance.add(*record)
+}
+
+func (instance *RequestRecorder) Add(record RecordedRequest) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.add(record)
+}
+
+// add stores the record in the next slot, forgetting any pending request it
+// overwrites.
+func (instance *RequestRecorder) add(record RecordedRequest) (int, bool) {
+	if len(instance.requests) == 0 {
+		return 0, false
+	}
+	slot := instance.next
+	if owner := instance.owners[slot]; owner != nil {
+		delete(instance.pending, owner)
+		instance.owners[slot] = nil
+	}
+	instance.requests[slot] = record
+	instance.next = (instance.next + 1) % len(instance.requests)
+	if instance.next == 0 {
+		instance.full = true
+	}
+	return slot, true
+}
+
+// Requests returns the recorded requests which match the filter, oldest first.
+func (instance *RequestRecorder) Requests(filter RequestFilter) []RecordedRequest {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	ordered := instance.requests[:instance.next]
+	if instance.full {
+		ordered = append(append([]RecordedRequest{}, instance.requests[instance.next:]...), ordered...)
+	}
+	requests := []RecordedRequest{}
+	for _, record := range ordered {
+		if filter.Matches(record) {
+			requests = append(requests, record)
+		}
+	}
+	return requests
+}
+
+func (instance *RequestRecorder) Reset() {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.requests = make([]RecordedRequest, len(instance.requests))
+	instance.owners = make([]*RecordedRequest, len(instance.requests))
+	instance.pending = map[*RecordedRequest]int{}
+	instance.next = 0
+	instance.full = false
+}
+
+// NewRequestRecorder creates a RequestRecorder which keeps the last size
+// requests, or none when size is 0.
+func NewRequestRecorder(size int) *RequestRecorder {
+	return &RequestRecorder{
+		requests: make([]RecordedRequest, size),
+		owners:   make([]*RecordedRequest, size),
+		pending:  map[*RecordedRequest]int{},
+	}
+}
+
+// recordFault notes on the request's record the name of the fault which was
+// applied to it.
+func recordFault(r *http.Request, fault string) {
+	if record, ok := r.Context().Value(requestRecordKey{}).(*RecordedRequest); ok {
+		record.Fault = fault
+	}
+}
+
+// recordSleep adds to the request's record the time enanos deliberately slept
+// before responding.
+func recordSleep(r *http.Request, slept time.Duration) {
+	if record, ok := r.Context().Value(requestRecordKey{}).(*RecordedRequest); ok {
+		record.Slept += slept
+	}
+}
+
+func recordEndpoint(r *http.Request, endpoint string) {
+	if record, ok := r.Context().Value(requestRecordKey{}).(*RecordedRequest); ok {
+		record.Endpoint = endpoint
+	}
+}
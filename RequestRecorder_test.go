@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestRecorder", func() {
+
+	var recorder *RequestRecorder
+	var router *Router
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, r)
+		return response
+	}
+
+	BeforeEach(func() {
+		recorder = NewRequestRecorder(3)
+		route, _ := NewRoute(RouteArgs{Path: "/orders", Behaviours: []BehaviourArgs{{Fault: FAULT_SERVER_ERROR}}})
+		config := Configuration{routes: []*Route{route}}
+		codes := NewFakeResponseCodeGenerator()
+		codes.Use(500)
+		router = NewRouter(NewLiveProfile(Profile{Config: config, ResponseCodeGenerator: codes}), recorder)
+		router.HandleFunc("/success", func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				body, _ := ioutil.ReadAll(r.Body)
+				w.Write(body)
+			}
+		})
+	})
+
+	It("records the request and the response", func() {
+		r, _ := http.NewRequest("POST", "/success?a=b", bytes.NewBufferString("hello"))
+		r.Header.Set("X-Foo", "bar")
+		response := serve(r)
+		Expect(response.Body.String()).To(Equal("hello"))
+		requests := recorder.Requests(RequestFilter{})
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Method).To(Equal("POST"))
+		Expect(requests[0].Path).To(Equal("/success"))
+		Expect(requests[0].Query).To(Equal("a=b"))
+		Expect(requests[0].Headers.Get("X-Foo")).To(Equal("bar"))
+		Expect(requests[0].Body).To(Equal("hello"))
+		Expect(requests[0].Fault).To(Equal("success"))
+		Expect(requests[0].Code).To(Equal(http.StatusOK))
+	})
+
+	It("records the fault applied by a route", func() {
+		serve(newRequest("GET", "/orders"))
+		requests := recorder.Requests(RequestFilter{})
+		Expect(requests[0].Fault).To(Equal(FAULT_SERVER_ERROR))
+		Expect(requests[0].Code).To(Equal(500))
+	})
+
+	It("keeps only the most recent requests, oldest first", func() {
+		for _, path := range []string{"/a", "/b", "/c", "/d"} {
+			serve(newRequest("GET", path))
+		}
+		requests := recorder.Requests(RequestFilter{})
+		Expect(requests).To(HaveLen(3))
+		Expect(requests[0].Path).To(Equal("/b"))
+		Expect(requests[2].Path).To(Equal("/d"))
+	})
+
+	It("records a request as pending until it has been served", func() {
+		router.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+			requests := recorder.Requests(RequestFilter{})
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Path).To(Equal("/hang"))
+			Expect(requests[0].Pending).To(BeTrue())
+		})
+		serve(newRequest("GET", "/hang"))
+		requests := recorder.Requests(RequestFilter{})
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Pending).To(BeFalse())
+		Expect(requests[0].Fault).To(Equal("hang"))
+	})
+
+	It("records a request whose handler aborts", func() {
+		router.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic(http.ErrAbortHandler)
+		})
+		Expect(func() { serve(newRequest("GET", "/abort")) }).To(Panic())
+		requests := recorder.Requests(RequestFilter{})
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Pending).To(BeFalse())
+		Expect(requests[0].Code).To(Equal(http.StatusAccepted))
+	})
+
+	It("forgets a pending request once it is overwritten", func() {
+		router.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+			serve(newRequest("GET", "/a"))
+			serve(newRequest("GET", "/b"))
+			serve(newRequest("GET", "/c"))
+		})
+		serve(newRequest("GET", "/hang"))
+		requests := recorder.Requests(RequestFilter{})
+		Expect(requests).To(HaveLen(3))
+		Expect(requests[0].Path).To(Equal("/b"))
+		Expect(requests[2].Path).To(Equal("/hang"))
+	})
+
+	It("filters the requests", func() {
+		serve(newRequest("GET", "/success"))
+		serve(newRequest("DELETE", "/orders"))
+		serve(newRequest("GET", "/nothing"))
+		Expect(recorder.Requests(RequestFilter{Method: "delete"})).To(HaveLen(1))
+		Expect(recorder.Requests(RequestFilter{Code: 404})).To(HaveLen(1))
+		Expect(recorder.Requests(RequestFilter{Path: "/success"})).To(HaveLen(1))
+		Expect(recorder.Requests(RequestFilter{Path: "/*"})).To(HaveLen(3))
+		Expect(recorder.Requests(RequestFilter{Fault: FAULT_SERVER_ERROR})).To(HaveLen(1))
+	})
+
+	Describe("admin API", func() {
+		var adminServer *AdminServer
+
+		send := func(method string, path string) *httptest.ResponseRecorder {
+			response := httptest.NewRecorder()
+			adminServer.Requests(response, newRequest(method, path))
+			return response
+		}
+
+		BeforeEach(func() {
+			adminServer = &AdminServer{Recorder: recorder}
+			serve(newRequest("GET", "/success"))
+			serve(newRequest("GET", "/orders"))
+		})
+
+		It("GET returns the requests which match the query", func() {
+			response := send("GET", ADMIN_REQUESTS_PATH+"?fault=success")
+			Expect(response.Code).To(Equal(http.StatusOK))
+			requests := []RecordedRequest{}
+			json.Unmarshal(response.Body.Bytes(), &requests)
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Path).To(Equal("/success"))
+		})
+
+		It("GET rejects an invalid filter", func() {
+			Expect(send("GET", ADMIN_REQUESTS_PATH+"?code=bang").Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("DELETE forgets the requests", func() {
+			Expect(send("DELETE", ADMIN_REQUESTS_PATH).Code).To(Equal(http.StatusNoContent))
+			Expect(recorder.Requests(RequestFilter{})).To(BeEmpty())
+		})
+	})
+})
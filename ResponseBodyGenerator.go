@@ -16,6 +16,10 @@ func (instance *MaxResponseBodyGenerator) Generate() string {
 	return string(returnArray)
 }
 
+func (instance *MaxResponseBodyGenerator) GenerateFormat(format string) string {
+	return string(GenerateBody(format, instance.maxLength))
+}
+
 func NewMaxResponseBodyGenerator(maxLength int) *MaxResponseBodyGenerator {
 	return &MaxResponseBodyGenerator{maxLength}
 }
@@ -35,6 +39,10 @@ func (instance *RandomResponseBodyGenerator) Generate() string {
 	return string(returnArray)
 }
 
+func (instance *RandomResponseBodyGenerator) GenerateFormat(format string) string {
+	return string(GenerateBody(format, instance.random.Int(instance.minLength, instance.maxLength)))
+}
+
 func NewRandomResponseBodyGenerator(minLength int, maxLength int) *RandomResponseBodyGenerator {
 	random := NewRealRandom()
 	return &RandomResponseBodyGenerator{minLength, maxLength, random}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RETRY_KEY_IP     string = "ip"
+	RETRY_KEY_HEADER string = "header:"
+	RETRY_KEY_QUERY  string = "query:"
+	ATTEMPT_HEADER   string = "X-Enanos-Attempt"
+)
+
+var (
+	// DEFAULT_RETRY is used by the /fail_first endpoint for anything which is
+	// not configured.
+	DEFAULT_RETRY RetryArgs = RetryArgs{Failures: intPointer(3), Key: RETRY_KEY_IP, TTL: "1m", Failure: FAULT_SERVER_ERROR}
+)
+
+// RetryArgs is the configuration file form of a RetryBehaviour.  The key is
+// ip, header:<name> or query:<name> and the failure is the name of the fault
+// returned until the failures have been used up, e.g. server_error or
+// client_error, whose codes come from the ResponseCodeGenerator.  Failures
+// is a pointer so that 0, which always succeeds, can be told from unset.
+type RetryArgs struct {
+	Failures *int   `json:"failures,omitempty"`
+	Key      string `json:"key,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+	Failure  string `json:"failure,omitempty"`
+}
+
+// withDefaults fills in anything which is not set from defaults.
+func (instance RetryArgs) withDefaults(defaults RetryArgs) RetryArgs {
+	if instance.Failures == nil {
+		instance.Failures = defaults.Failures
+	}
+	if instance.Key == "" {
+		instance.Key = defaults.Key
+	}
+	if instance.TTL == "" {
+		instance.TTL = defaults.TTL
+	}
+	if instance.Failure == "" {
+		instance.Failure = defaults.Failure
+	}
+	return instance
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+type attempts struct {
+	count int
+	last  time.Time
+}
+
+// AttemptTracker counts the attempts made for each key, forgetting a key once
+// no attempt has been made for it for the TTL.
+type AttemptTracker struct {
+	mutex    sync.Mutex
+	attempts map[string]*attempts
+	swept    time.Time
+	clock    Clock
+}
+
+// Attempt counts an attempt for the key and returns how many attempts have
+// been made, including this one.
+func (instance *AttemptTracker) Attempt(key string, ttl time.Duration) int {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	now := instance.clock.Now()
+	if now.Sub(instance.swept) >= ttl {
+		for key, entry := range instance.attempts {
+			if now.Sub(entry.last) >= ttl {
+				delete(instance.attempts, key)
+			}
+		}
+		instance.swept = now
+	}
+	entry, ok := instance.attempts[key]
+	if !ok || now.Sub(entry.last) >= ttl {
+		entry = &attempts{}
+		instance.attempts[key] = entry
+	}
+	entry.count++
+	entry.last = now
+	return entry.count
+}
+
+func NewAttemptTracker(clock Clock) *AttemptTracker {
+	return &AttemptTracker{attempts: map[string]*attempts{}, clock: clock}
+}
+
+// RetryBehaviour fails the first attempts for each key and then succeeds, to
+// prove that a client's retries work.
+type RetryBehaviour struct {
+	Args     RetryArgs
+	TTL      time.Duration
+	failure  *FaultBehaviour
+	success  *FaultBehaviour
+	attempts *AttemptTracker
+}
+
+func (instance *RetryBehaviour) Apply(exchange *Exchange) {
+	key := attemptKey(instance.Args.Key, exchange.Request)
+	attempt := instance.attempts.Attempt(key, instance.TTL)
+	exchange.Header().Set(ATTEMPT_HEADER, strconv.Itoa(attempt))
+	if attempt <= *instance.Args.Failures {
+		instance.failure.Apply(exchange)
+	} else {
+		instance.success.Apply(exchange)
+	}
+}
+
+func attemptKey(spec string, r *http.Request) string {
+	switch {
+	case strings.HasPrefix(spec, RETRY_KEY_HEADER):
+		return spec + "=" + r.Header.Get(strings.TrimPrefix(spec, RETRY_KEY_HEADER))
+	case strings.HasPrefix(spec, RETRY_KEY_QUERY):
+		return spec + "=" + r.URL.Query().Get(strings.TrimPrefix(spec, RETRY_KEY_QUERY))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return RETRY_KEY_IP + "=" + host
+}
+
+func validateAttemptKey(spec string) error {
+	if spec != RETRY_KEY_IP &&
+		!(strings.HasPrefix(spec, RETRY_KEY_HEADER) && len(spec) > len(RETRY_KEY_HEADER)) &&
+		!(strings.HasPrefix(spec, RETRY_KEY_QUERY) && len(spec) > len(RETRY_KEY_QUERY)) {
+		return fmt.Errorf("key %q is not one of ip, header:<name> or query:<name>", spec)
+	}
+	return nil
+}
+
+func NewRetryBehaviour(args RetryArgs, attempts *AttemptTracker) (*RetryBehaviour, error) {
+	args = args.withDefaults(DEFAULT_RETRY)
+	if *args.Failures < 0 {
+		return nil, fmt.Errorf("retry: failures cannot be negative")
+	}
+	if err := validateAttemptKey(args.Key); err != nil {
+		return nil, fmt.Errorf("retry: %v", err)
+	}
+	ttl, err := time.ParseDuration(args.TTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("retry: cannot parse ttl from %q", args.TTL)
+	}
+	failure, err := NewFaultBehaviour(args.Failure)
+	if err != nil {
+		return nil, fmt.Errorf("retry: %v", err)
+	}
+	return &RetryBehaviour{
+		Args:     args,
+		TTL:      ttl,
+		failure:  failure,
+		success:  &FaultBehaviour{FAULT_SUCCESS},
+		attempts: attempts,
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry", func() {
+
+	var clock *FakeClock
+	var behaviour *RetryBehaviour
+
+	attempt := func(remote string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		codes := NewFakeResponseCodeGenerator()
+		codes.Use(503)
+		r := newRequest("GET", path)
+		r.RemoteAddr = remote
+		exchange := NewExchange(recorder, r, nil, Profile{ResponseCodeGenerator: codes})
+		behaviour.Apply(exchange)
+		exchange.Respond()
+		return recorder
+	}
+
+	BeforeEach(func() {
+		clock = NewFakeClock()
+		behaviour, _ = NewRetryBehaviour(RetryArgs{Failures: intPointer(2)}, NewAttemptTracker(clock))
+	})
+
+	It("fails the first attempts and then succeeds", func() {
+		Expect(attempt("10.0.0.1:1000", "/").Code).To(Equal(503))
+		Expect(attempt("10.0.0.1:1001", "/").Code).To(Equal(503))
+		response := attempt("10.0.0.1:1002", "/")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get(ATTEMPT_HEADER)).To(Equal("3"))
+	})
+
+	It("counts the attempts of each client separately", func() {
+		attempt("10.0.0.1:1000", "/")
+		attempt("10.0.0.1:1000", "/")
+		Expect(attempt("10.0.0.2:1000", "/").Code).To(Equal(503))
+		Expect(attempt("10.0.0.1:1000", "/").Code).To(Equal(200))
+	})
+
+	It("forgets a client once the ttl has passed since its last attempt", func() {
+		attempt("10.0.0.1:1000", "/")
+		attempt("10.0.0.1:1000", "/")
+		clock.Advance(time.Minute)
+		Expect(attempt("10.0.0.1:1000", "/").Code).To(Equal(503))
+	})
+
+	It("keys on a query parameter", func() {
+		behaviour, _ = NewRetryBehaviour(RetryArgs{Failures: intPointer(1), Key: "query:test"}, NewAttemptTracker(clock))
+		Expect(attempt("10.0.0.1:1000", "/?test=a").Code).To(Equal(503))
+		Expect(attempt("10.0.0.1:1000", "/?test=b").Code).To(Equal(503))
+		Expect(attempt("10.0.0.2:1000", "/?test=a").Code).To(Equal(200))
+	})
+
+	It("succeeds at once when there are no failures", func() {
+		behaviour, _ = NewRetryBehaviour(RetryArgs{Failures: intPointer(0)}, NewAttemptTracker(clock))
+		Expect(attempt("10.0.0.1:1000", "/").Code).To(Equal(200))
+	})
+
+	It("rejects an unknown key", func() {
+		_, err := NewRetryBehaviour(RetryArgs{Key: "cookie:a"}, nil)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("is available as a route behaviour", func() {
+		_, err := NewBehaviour(BehaviourArgs{Retry: &RetryArgs{Failures: intPointer(1), Failure: FAULT_CLIENT_ERROR}})
+		Expect(err).To(BeNil())
+	})
+})
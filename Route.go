@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// RouteArgs is the configuration file form of a Route.
+type RouteArgs struct {
+	Path       string          `json:"path"`
+	Methods    []string        `json:"methods,omitempty"`
+	Behaviours []BehaviourArgs `json:"behaviours"`
+	Mix        []OutcomeArgs   `json:"mix,omitempty"`
+	Priority   int             `json:"priority,omitempty"`
+	Match      *MatchArgs      `json:"match,omitempty"`
+}
+
+// BehaviourArgs is the configuration file form of a Behaviour.  Exactly one
+// of the fields must be set for each entry in a route's chain.  A fault
+// reproduces one of the built in endpoints, proxy fetches the response from
+// the configured upstream, replay responds with the fixture recorded for the
+// request, scenario applies the current state of the named scenario, retry
+// fails the first attempts for each client and ratelimit rejects the requests
+// from each client beyond a limit.
+type BehaviourArgs struct {
+	Status    int            `json:"status,omitempty"`
+	Delay     string         `json:"delay,omitempty"`
+	Size      string         `json:"size,omitempty"`
+	Headers   []string       `json:"headers,omitempty"`
+	Content   string         `json:"content,omitempty"`
+	Fault     string         `json:"fault,omitempty"`
+	Proxy     bool           `json:"proxy,omitempty"`
+	Truncate  string         `json:"truncate,omitempty"`
+	Throttle  *ThrottleArgs  `json:"throttle,omitempty"`
+	Scenario  string         `json:"scenario,omitempty"`
+	Retry     *RetryArgs     `json:"retry,omitempty"`
+	RateLimit *RateLimitArgs `json:"ratelimit,omitempty"`
+	Stream    *StreamArgs    `json:"stream,omitempty"`
+	Corrupt   string         `json:"corrupt,omitempty"`
+	Replay    bool           `json:"replay,omitempty"`
+	Template  bool           `json:"template,omitempty"`
+}
+
+// Exchange is the response being built for a request as it passes along a
+// chain of behaviours.
+type Exchange struct {
+	Request  *http.Request
+	Writer   http.ResponseWriter
+	Params   map[string]string
+	Profile  Profile
+	Code     int
+	Body     []byte
+	Throttle *Throttle
+	Handled  bool
+}
+
+func (instance *Exchange) Header() http.Header {
+	return instance.Writer.Header()
+}
+
+// Respond writes the response unless a behaviour has already done so.
+func (instance *Exchange) Respond() {
+	if instance.Handled {
+		return
+	}
+	instance.Handled = true
+	if instance.Throttle == nil {
+		instance.Writer.WriteHeader(instance.Code)
+		instance.Writer.Write(instance.Body)
+		return
+	}
+	if instance.Header().Get("Content-Length") == "" {
+		instance.Header().Set("Content-Length", strconv.Itoa(len(instance.Body)))
+	}
+	instance.Writer.WriteHeader(instance.Code)
+	if flusher, ok := instance.Writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	instance.Throttle.Write(instance.Writer, instance.Body)
+}
+
+func NewExchange(w http.ResponseWriter, r *http.Request, params map[string]string, profile Profile) *Exchange {
+	return &Exchange{
+		Request: r,
+		Writer:  w,
+		Params:  params,
+		Profile: profile,
+		Code:    http.StatusOK,
+	}
+}
+
+type Behaviour interface {
+	Apply(exchange *Exchange)
+}
+
+type StatusBehaviour struct {
+	Code int
+}
+
+func (instance *StatusBehaviour) Apply(exchange *Exchange) {
+	exchange.Code = instance.Code
+}
+
+type DelayBehaviour struct {
+	Snoozer Snoozer
+}
+
+func (instance *DelayBehaviour) Apply(exchange *Exchange) {
+	snoozeFor(exchange.Request, instance.Snoozer)
+}
+
+type SizeBehaviour struct {
+	ResponseBodyGenerator ResponseBodyGenerator
+}
+
+func (instance *SizeBehaviour) Apply(exchange *Exchange) {
+	exchange.Body = generateContent(instance.ResponseBodyGenerator, exchange.Profile.Config.body, exchange.Header().Get("Content-Type"))
+}
+
+type HeadersBehaviour struct {
+	Headers  []string
+	Template bool
+}
+
+func (instance *HeadersBehaviour) Apply(exchange *Exchange) {
+	for _, header := range instance.Headers {
+		split := strings.SplitN(header, ":", 2)
+		value := split[1]
+		if instance.Template {
+			value = renderTemplate(value, exchange.Request, exchange.Params)
+		}
+		exchange.Header().Set(split[0], value)
+	}
+}
+
+type ContentBehaviour struct {
+	Content  string
+	Template bool
+}
+
+func (instance *ContentBehaviour) Apply(exchange *Exchange) {
+	if instance.Template {
+		exchange.Body = []byte(renderTemplate(instance.Content, exchange.Request, exchange.Params))
+		return
+	}
+	exchange.Body = []byte(instance.Content)
+}
+
+// fields returns the names of the fields which are set.
+func (instance BehaviourArgs) fields() []string {
+	fields := []string{}
+	for name, set := range map[string]bool{
+		"status":    instance.Status != 0,
+		"delay":     instance.Delay != "",
+		"size":      instance.Size != "",
+		"headers":   instance.Headers != nil,
+		"content":   instance.Content != "",
+		"fault":     instance.Fault != "",
+		"proxy":     instance.Proxy,
+		"truncate":  instance.Truncate != "",
+		"throttle":  instance.Throttle != nil,
+		"scenario":  instance.Scenario != "",
+		"retry":     instance.Retry != nil,
+		"ratelimit": instance.RateLimit != nil,
+		"stream":    instance.Stream != nil,
+		"corrupt":   instance.Corrupt != "",
+		"replay":    instance.Replay,
+	} {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// NewBehaviour creates the Behaviour described by args.
+func NewBehaviour(args BehaviourArgs) (Behaviour, error) {
+	if fields := args.fields(); len(fields) > 1 {
+		return nil, fmt.Errorf("behaviour specifies %s, each behaviour in the chain must specify only one", strings.Join(fields, ", "))
+	}
+	if args.Template && args.Headers == nil && args.Content == "" {
+		return nil, fmt.Errorf("template: only applies to headers and content")
+	}
+	switch {
+	case args.Status != 0:
+		if args.Status < 100 || args.Status > 999 {
+			return nil, fmt.Errorf("status: %d is not a valid response code", args.Status)
+		}
+		return &StatusBehaviour{args.Status}, nil
+	case args.Delay != "":
+		delay, err := time.ParseDuration(args.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("delay: cannot parse time from %q", args.Delay)
+		}
+		return &DelayBehaviour{NewMaxSnoozer(delay)}, nil
+	case args.Size != "":
+		size, err := humanize.ParseBytes(args.Size)
+		if err != nil {
+			return nil, fmt.Errorf("size: cannot parse size from %q", args.Size)
+		}
+		return &SizeBehaviour{NewMaxResponseBodyGenerator(int(size))}, nil
+	case args.Headers != nil:
+		for _, header := range args.Headers {
+			if !strings.Contains(header, ":") {
+				return nil, fmt.Errorf("headers: %q is not in the format Key:Value", header)
+			}
+			if !args.Template {
+				continue
+			}
+			if err := validateTemplates(header); err != nil {
+				return nil, fmt.Errorf("headers: %v", err)
+			}
+		}
+		return &HeadersBehaviour{args.Headers, args.Template}, nil
+	case args.Content != "":
+		if args.Template {
+			if err := validateTemplates(args.Content); err != nil {
+				return nil, fmt.Errorf("content: %v", err)
+			}
+		}
+		return &ContentBehaviour{args.Content, args.Template}, nil
+	case args.Fault != "":
+		return NewFaultBehaviour(args.Fault)
+	case args.Proxy:
+		return NewProxyBehaviour(), nil
+	case args.Truncate != "":
+		return NewTruncateBehaviour(args.Truncate)
+	case args.Throttle != nil:
+		throttle, err := NewThrottle(*args.Throttle, NewRealRandom())
+		if err != nil {
+			return nil, err
+		}
+		return &ThrottleBehaviour{throttle}, nil
+	case args.Scenario != "":
+		return &ScenarioBehaviour{args.Scenario}, nil
+	case args.Retry != nil:
+		return NewRetryBehaviour(*args.Retry, NewAttemptTracker(NewRealClock()))
+	case args.RateLimit != nil:
+		return NewRateLimitBehaviour(*args.RateLimit, NewRealClock())
+	case args.Stream != nil:
+		return NewStreamBehaviour(*args.Stream, NewRealRandom())
+	case args.Corrupt != "":
+		return NewCorruptBehaviour(args.Corrupt)
+	case args.Replay:
+		return NewReplayBehaviour(), nil
+	}
+	return nil, fmt.Errorf("behaviour does not specify any of status, delay, size, headers, content, fault, proxy, truncate, throttle, scenario, retry, ratelimit, stream, corrupt or replay")
+}
+
+// Route binds a path pattern such as /api/v1/orders/{id} to a chain of
+// behaviours.  A {name} segment matches any single path segment, a trailing *
+// matches the remainder of the path and any other segment with * or ? is a
+// glob for a single segment.
+type Route struct {
+	Args       RouteArgs
+	Methods    []string
+	Behaviours []Behaviour
+	Matcher    *Matcher
+	segments   []string
+}
+
+// Match reports whether the route matches the request, returning the values
+// of any named path segments.
+func (instance *Route) Match(r *http.Request) (map[string]string, bool) {
+	if len(instance.Methods) > 0 && !containsMethod(instance.Methods, r.Method) {
+		return nil, false
+	}
+	params := map[string]string{}
+	segments := splitPath(r.URL.Path)
+	remainder := false
+	for i, segment := range instance.segments {
+		if segment == "*" && i == len(instance.segments)-1 {
+			remainder = true
+			break
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params[segment[1:len(segment)-1]] = segments[i]
+		} else if matched, _ := path.Match(segment, segments[i]); !matched {
+			return nil, false
+		}
+	}
+	if !remainder && len(segments) != len(instance.segments) {
+		return nil, false
+	}
+	if instance.Matcher != nil && !instance.Matcher.Match(r) {
+		return nil, false
+	}
+	return params, true
+}
+
+func (instance *Route) Serve(w http.ResponseWriter, r *http.Request, params map[string]string, profile Profile) {
+	exchange := NewExchange(w, r, params, profile)
+	for _, behaviour := range instance.Behaviours {
+		behaviour.Apply(exchange)
+		if exchange.Handled {
+			return
+		}
+	}
+	exchange.Respond()
+}
+
+func NewRoute(args RouteArgs) (*Route, error) {
+	if !strings.HasPrefix(args.Path, "/") {
+		return nil, fmt.Errorf("route %q: path must start with /", args.Path)
+	}
+	route := &Route{
+		Args:     args,
+		segments: splitPath(args.Path),
+	}
+	for _, method := range args.Methods {
+		route.Methods = append(route.Methods, strings.ToUpper(method))
+	}
+	for _, segment := range route.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("route %q: %q is not a valid glob", args.Path, segment)
+		}
+	}
+	if args.Match != nil {
+		matcher, err := NewMatcher(*args.Match)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %v", args.Path, err)
+		}
+		route.Matcher = matcher
+	}
+	for _, behaviourArgs := range args.Behaviours {
+		behaviour, err := NewBehaviour(behaviourArgs)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %v", args.Path, err)
+		}
+		route.Behaviours = append(route.Behaviours, behaviour)
+	}
+	if args.Mix != nil {
+		mix, err := NewMixBehaviour(args.Mix, NewRealRandom())
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %v", args.Path, err)
+		}
+		route.Behaviours = append(route.Behaviours, mix)
+	}
+	return route, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, item := range methods {
+		if item == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Route", func() {
+
+	Describe("Match", func() {
+		It("matches named segments", func() {
+			route, _ := NewRoute(RouteArgs{Path: "/api/v1/orders/{id}"})
+			params, ok := route.Match(newRequest("GET", "/api/v1/orders/123"))
+			Expect(ok).To(BeTrue())
+			Expect(params["id"]).To(Equal("123"))
+		})
+
+		It("does not match a different number of segments", func() {
+			route, _ := NewRoute(RouteArgs{Path: "/api/v1/orders/{id}"})
+			_, ok := route.Match(newRequest("GET", "/api/v1/orders/123/items"))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("matches the rest of the path with a trailing wildcard", func() {
+			route, _ := NewRoute(RouteArgs{Path: "/api/*"})
+			_, ok := route.Match(newRequest("GET", "/api/v1/orders/123"))
+			Expect(ok).To(BeTrue())
+		})
+
+		It("only matches the configured methods", func() {
+			route, _ := NewRoute(RouteArgs{Path: "/orders", Methods: []string{"post"}})
+			_, ok := route.Match(newRequest("GET", "/orders"))
+			Expect(ok).To(BeFalse())
+			_, ok = route.Match(newRequest("POST", "/orders"))
+			Expect(ok).To(BeTrue())
+		})
+	})
+
+	Describe("Serve", func() {
+		It("applies the behaviours in order", func() {
+			route, err := NewRoute(RouteArgs{
+				Path: "/orders/{id}",
+				Behaviours: []BehaviourArgs{
+					{Status: 503},
+					{Headers: []string{"Retry-After:5"}},
+					{Size: "5B"},
+					{Content: "unavailable"},
+				},
+			})
+			Expect(err).To(BeNil())
+			recorder := httptest.NewRecorder()
+			route.Serve(recorder, newRequest("GET", "/orders/1"), nil, Profile{})
+			Expect(recorder.Code).To(Equal(503))
+			Expect(recorder.Header().Get("Retry-After")).To(Equal("5"))
+			Expect(recorder.Body.String()).To(Equal("unavailable"))
+		})
+	})
+
+	Describe("NewRoute", func() {
+		It("rejects a behaviour with nothing set", func() {
+			_, err := NewRoute(RouteArgs{Path: "/orders", Behaviours: []BehaviourArgs{{}}})
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("rejects a behaviour with more than one field set", func() {
+			_, err := NewRoute(RouteArgs{Path: "/orders", Behaviours: []BehaviourArgs{{Status: 503, Delay: "2s"}}})
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(ContainSubstring("delay, status"))
+		})
+
+		It("rejects an invalid delay", func() {
+			_, err := NewRoute(RouteArgs{Path: "/orders", Behaviours: []BehaviourArgs{{Delay: "soon"}}})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Router", func() {
+
+	It("serves a configured route before the built in endpoints", func() {
+		route, _ := NewRoute(RouteArgs{Path: "/success", Behaviours: []BehaviourArgs{{Status: 418}}})
+		config := Configuration{routes: []*Route{route}}
+		router := NewRouter(NewLiveProfile(Profile{Config: config}), nil)
+		router.HandleFunc("/success", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, newRequest("GET", "/success"))
+		Expect(recorder.Code).To(Equal(418))
+	})
+
+	It("returns 404 when nothing matches", func() {
+		router := NewRouter(NewLiveProfile(Profile{}), nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, newRequest("GET", "/nothing"))
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+func newRequest(method string, path string) *http.Request {
+	r, _ := http.NewRequest(method, path, nil)
+	return r
+}
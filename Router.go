@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ENDPOINT_UPSTREAM string = "upstream"
+)
+
+var (
+	ROUTER_METHODS []string = []string{"GET", "POST", "PUT", "DELETE"}
+)
+
+// Router serves the routes from the live configuration, falling back to the
+// fixtures being replayed, then to the built in endpoints when none of them
+// match and then to the upstream when one is configured.  A router made with
+// NewEndpointRouter only serves the built in endpoints.
+type Router struct {
+	profile   *LiveProfile
+	handlers  map[string]http.HandlerFunc
+	proxy     *ProxyBehaviour
+	replay    *ReplayBehaviour
+	observers []RequestObserver
+	endpoints bool
+}
+
+// HandleFunc registers a built in endpoint for GET, POST, PUT and DELETE.
+func (instance *Router) HandleFunc(path string, handler http.HandlerFunc) {
+	instance.handlers[path] = handler
+}
+
+// ServeHTTP applies any per request overrides to whichever handler matches
+// and tells the observers about the request, both as it arrives and once it
+// has been served, abandoned or aborted.  Query parameter overrides are only
+// read for the built in endpoints so that they cannot clash with the query
+// strings of routes and the upstream.
+func (instance *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(instance.observers) == 0 {
+		instance.serve(w, r)
+		return
+	}
+	record := NewRecordedRequest(r)
+	w.Header().Set(REQUEST_ID_HEADER, record.ID)
+	for _, observer := range instance.observers {
+		if observer, ok := observer.(RequestStartObserver); ok {
+			observer.Start(record)
+		}
+	}
+	writer := &HttpResponseWriterRecorder{writer: w}
+	defer func() {
+		record.Code = writer.Code
+		record.Bytes = writer.Bytes
+		record.Elapsed = time.Since(record.Time)
+		record.Duration = record.Elapsed.String()
+		record.Sleep = record.Slept.String()
+		for _, observer := range instance.observers {
+			observer.Observe(record)
+		}
+	}()
+	instance.serve(writer, r.WithContext(context.WithValue(r.Context(), requestRecordKey{}, record)))
+}
+
+func (instance *Router) serve(w http.ResponseWriter, r *http.Request) {
+	handler, builtin := instance.match(r)
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if builtin {
+		recordFault(r, strings.TrimPrefix(r.URL.Path, "/"))
+	}
+	overrides, err := ParseOverrides(r, builtin, instance.profile.Current().Config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if overrides != nil {
+		handler = overrides.Wrap(handler)
+	}
+	handler(w, r)
+}
+
+func (instance *Router) match(r *http.Request) (http.HandlerFunc, bool) {
+	profile := instance.profile.Current()
+	config := profile.Config
+	if instance.endpoints {
+		return instance.endpoint(r)
+	}
+	for _, route := range config.allRoutes() {
+		if params, ok := route.Match(r); ok {
+			recordEndpoint(r, route.Args.Path)
+			return func(w http.ResponseWriter, r *http.Request) {
+				setHeaders(w, r, config)
+				route.Serve(w, r, params, profile)
+			}, false
+		}
+	}
+	if config.fixtures.Replaying() && config.fixtures.Match(r) != nil {
+		recordEndpoint(r, ENDPOINT_FIXTURE)
+		return func(w http.ResponseWriter, r *http.Request) {
+			exchange := NewExchange(w, r, nil, profile)
+			instance.replay.Apply(exchange)
+			exchange.Respond()
+		}, false
+	}
+	if _, ok := instance.handlers[r.URL.Path]; !ok && config.upstream != nil {
+		recordEndpoint(r, ENDPOINT_UPSTREAM)
+		return func(w http.ResponseWriter, r *http.Request) {
+			exchange := NewExchange(w, r, nil, profile)
+			instance.proxy.Apply(exchange)
+			exchange.Respond()
+		}, false
+	}
+	return instance.endpoint(r)
+}
+
+// endpoint returns the built in endpoint for the path of the request.
+func (instance *Router) endpoint(r *http.Request) (http.HandlerFunc, bool) {
+	handler, ok := instance.handlers[r.URL.Path]
+	if !ok {
+		return nil, false
+	}
+	recordEndpoint(r, r.URL.Path)
+	if !containsMethod(ROUTER_METHODS, r.Method) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}, true
+	}
+	return handler, true
+}
+
+// NewRouter creates a Router which tells the observers about each request it
+// serves.  Nil observers are ignored.
+func NewRouter(profile *LiveProfile, observers ...RequestObserver) *Router {
+	router := &Router{
+		profile:  profile,
+		handlers: map[string]http.HandlerFunc{},
+		proxy:    NewProxyBehaviour(),
+		replay:   NewReplayBehaviour(),
+	}
+	for _, observer := range observers {
+		if observer != nil {
+			router.observers = append(router.observers, observer)
+		}
+	}
+	return router
+}
+
+// NewEndpointRouter creates a Router which only serves the built in
+// endpoints, for listeners which the routes, fixtures and upstream are not
+// meant for.
+func NewEndpointRouter(profile *LiveProfile, observers ...RequestObserver) *Router {
+	router := NewRouter(profile, observers...)
+	router.endpoints = true
+	return router
+}
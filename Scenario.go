@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ScenarioStateArgs is the configuration file form of one state of a
+// Scenario.  The scenario moves on from the state after the given number of
+// requests or once it has been in the state for the duration, whichever comes
+// first.  A state with neither is only left when the admin API moves it on.
+type ScenarioStateArgs struct {
+	Name       string          `json:"name"`
+	Requests   int             `json:"requests,omitempty"`
+	Duration   string          `json:"duration,omitempty"`
+	Next       string          `json:"next,omitempty"`
+	Behaviours []BehaviourArgs `json:"behaviours"`
+	Mix        []OutcomeArgs   `json:"mix,omitempty"`
+}
+
+// ScenarioArgs is the configuration file form of a Scenario.  A state moves on
+// to its next state, or the one after it in the list, and the last state moves
+// back to the first when loop is set.
+type ScenarioArgs struct {
+	Name   string              `json:"name"`
+	Loop   bool                `json:"loop,omitempty"`
+	States []ScenarioStateArgs `json:"states"`
+}
+
+type ScenarioState struct {
+	Args       ScenarioStateArgs
+	Duration   time.Duration
+	Next       int
+	Behaviours []Behaviour
+}
+
+// ScenarioStatus is the state a Scenario is in, as reported by the admin API.
+type ScenarioStatus struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Requests int       `json:"requests"`
+	Since    time.Time `json:"since"`
+}
+
+// Scenario is a named sequence of states, each with its own chain of
+// behaviours, which routes share so that they can tell a story such as healthy
+// for 100 requests, then 503 for 30s, then slow for 2 minutes, then healthy.
+type Scenario struct {
+	Args     ScenarioArgs
+	States   []*ScenarioState
+	mutex    sync.Mutex
+	current  int
+	requests int
+	since    time.Time
+	clock    Clock
+}
+
+// Apply applies the behaviours of the current state, after moving on from any
+// states whose duration has passed, and counts the request against the state.
+func (instance *Scenario) Apply(exchange *Exchange) {
+	instance.mutex.Lock()
+	instance.expire()
+	state := instance.States[instance.current]
+	instance.requests++
+	if state.Args.Requests > 0 && instance.requests >= state.Args.Requests {
+		instance.enter(state.Next, instance.clock.Now())
+	}
+	instance.mutex.Unlock()
+	for _, behaviour := range state.Behaviours {
+		behaviour.Apply(exchange)
+		if exchange.Handled {
+			return
+		}
+	}
+}
+
+// expire moves on from each state whose duration has passed, timing the next
+// state from when the previous one should have ended so that the schedule
+// does not drift when there are no requests.
+func (instance *Scenario) expire() {
+	now := instance.clock.Now()
+	for {
+		state := instance.States[instance.current]
+		if state.Duration <= 0 || state.Next == instance.current || now.Sub(instance.since) < state.Duration {
+			return
+		}
+		instance.enter(state.Next, instance.since.Add(state.Duration))
+	}
+}
+
+func (instance *Scenario) enter(state int, since time.Time) {
+	instance.current = state
+	instance.requests = 0
+	instance.since = since
+}
+
+// MoveTo puts the scenario into the named state, or the first state when name
+// is empty.
+func (instance *Scenario) MoveTo(name string) error {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if name == "" {
+		instance.enter(0, instance.clock.Now())
+		return nil
+	}
+	for i, state := range instance.States {
+		if state.Args.Name == name {
+			instance.enter(i, instance.clock.Now())
+			return nil
+		}
+	}
+	return fmt.Errorf("scenario %q has no state %q", instance.Args.Name, name)
+}
+
+func (instance *Scenario) Status() ScenarioStatus {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.expire()
+	return ScenarioStatus{
+		Name:     instance.Args.Name,
+		State:    instance.States[instance.current].Args.Name,
+		Requests: instance.requests,
+		Since:    instance.since,
+	}
+}
+
+func NewScenario(args ScenarioArgs, clock Clock) (*Scenario, error) {
+	if args.Name == "" {
+		return nil, fmt.Errorf("scenario must have a name")
+	}
+	if len(args.States) == 0 {
+		return nil, fmt.Errorf("scenario %q: at least one state is required", args.Name)
+	}
+	indexes := map[string]int{}
+	for i, stateArgs := range args.States {
+		if _, ok := indexes[stateArgs.Name]; ok || stateArgs.Name == "" {
+			return nil, fmt.Errorf("scenario %q: each state must have a unique name", args.Name)
+		}
+		indexes[stateArgs.Name] = i
+	}
+	scenario := &Scenario{Args: args, clock: clock, since: clock.Now()}
+	for i, stateArgs := range args.States {
+		state := &ScenarioState{Args: stateArgs, Next: i + 1}
+		if stateArgs.Duration != "" {
+			duration, err := time.ParseDuration(stateArgs.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("scenario %q: duration: cannot parse time from %q", args.Name, stateArgs.Duration)
+			}
+			state.Duration = duration
+		}
+		if stateArgs.Requests < 0 {
+			return nil, fmt.Errorf("scenario %q: requests cannot be negative", args.Name)
+		}
+		if stateArgs.Next != "" {
+			next, ok := indexes[stateArgs.Next]
+			if !ok {
+				return nil, fmt.Errorf("scenario %q: next state %q does not exist", args.Name, stateArgs.Next)
+			}
+			state.Next = next
+		} else if state.Next == len(args.States) {
+			state.Next = i
+			if args.Loop {
+				state.Next = 0
+			}
+		}
+		for _, behaviourArgs := range stateArgs.Behaviours {
+			if behaviourArgs.Scenario != "" {
+				return nil, fmt.Errorf("scenario %q: a state cannot use another scenario", args.Name)
+			}
+			behaviour, err := NewBehaviour(behaviourArgs)
+			if err != nil {
+				return nil, fmt.Errorf("scenario %q: %v", args.Name, err)
+			}
+			state.Behaviours = append(state.Behaviours, behaviour)
+		}
+		if stateArgs.Mix != nil {
+			for _, outcomeArgs := range stateArgs.Mix {
+				if outcomeArgs.Scenario != "" {
+					return nil, fmt.Errorf("scenario %q: a state cannot use another scenario", args.Name)
+				}
+			}
+			mix, err := NewMixBehaviour(stateArgs.Mix, NewRealRandom())
+			if err != nil {
+				return nil, fmt.Errorf("scenario %q: %v", args.Name, err)
+			}
+			state.Behaviours = append(state.Behaviours, mix)
+		}
+		scenario.States = append(scenario.States, state)
+	}
+	return scenario, nil
+}
+
+// ScenarioBehaviour applies the named scenario from the live configuration.
+type ScenarioBehaviour struct {
+	Name string
+}
+
+func (instance *ScenarioBehaviour) Apply(exchange *Exchange) {
+	scenario := exchange.Profile.Config.scenario(instance.Name)
+	if scenario == nil {
+		exchange.Code = http.StatusInternalServerError
+		exchange.Body = []byte(fmt.Sprintf("scenario %q is not configured", instance.Name))
+		return
+	}
+	scenario.Apply(exchange)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scenario", func() {
+
+	var clock *FakeClock
+	var scenario *Scenario
+
+	outage := ScenarioArgs{
+		Name: "outage",
+		States: []ScenarioStateArgs{
+			{Name: "healthy", Requests: 2, Behaviours: []BehaviourArgs{{Status: 200}}},
+			{Name: "down", Duration: "30s", Behaviours: []BehaviourArgs{{Status: 503}}},
+			{Name: "slow", Duration: "2m", Behaviours: []BehaviourArgs{{Status: 202}}},
+			{Name: "recovered", Behaviours: []BehaviourArgs{{Status: 200}}},
+		},
+	}
+
+	code := func() int {
+		exchange := NewExchange(httptest.NewRecorder(), newRequest("GET", "/"), nil, Profile{})
+		scenario.Apply(exchange)
+		return exchange.Code
+	}
+
+	BeforeEach(func() {
+		clock = NewFakeClock()
+		scenario, _ = NewScenario(outage, clock)
+	})
+
+	It("moves on after the number of requests", func() {
+		Expect(code()).To(Equal(200))
+		Expect(code()).To(Equal(200))
+		Expect(code()).To(Equal(503))
+	})
+
+	It("moves on after the duration", func() {
+		scenario.MoveTo("down")
+		Expect(code()).To(Equal(503))
+		clock.Advance(30 * time.Second)
+		Expect(code()).To(Equal(202))
+	})
+
+	It("keeps to the schedule when there are no requests", func() {
+		scenario.MoveTo("down")
+		clock.Advance(2*time.Minute + 31*time.Second)
+		Expect(code()).To(Equal(200))
+		Expect(scenario.Status().State).To(Equal("recovered"))
+	})
+
+	It("stays in the last state unless it loops", func() {
+		scenario.MoveTo("recovered")
+		clock.Advance(time.Hour)
+		Expect(code()).To(Equal(200))
+		Expect(scenario.Status().State).To(Equal("recovered"))
+	})
+
+	It("moves from the last state to the first when it loops", func() {
+		args := ScenarioArgs{
+			Name: "flapping",
+			Loop: true,
+			States: []ScenarioStateArgs{
+				{Name: "up", Requests: 1, Behaviours: []BehaviourArgs{{Status: 200}}},
+				{Name: "down", Requests: 1, Behaviours: []BehaviourArgs{{Status: 503}}},
+			},
+		}
+		scenario, _ = NewScenario(args, clock)
+		Expect([]int{code(), code(), code()}).To(Equal([]int{200, 503, 200}))
+	})
+
+	It("rejects an unknown next state", func() {
+		args := ScenarioArgs{Name: "broken", States: []ScenarioStateArgs{{Name: "a", Next: "b"}}}
+		_, err := NewScenario(args, clock)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("cannot be used by a route unless it is defined", func() {
+		args := CommandLineArgs{Routes: []RouteArgs{{Path: "/", Behaviours: []BehaviourArgs{{Scenario: "outage"}}}}}
+		Expect(NewArgsConfigurationReader(&args).Validate()).ToNot(BeNil())
+		args.Scenarios = []ScenarioArgs{outage}
+		Expect(NewArgsConfigurationReader(&args).Validate()).To(BeNil())
+	})
+
+	Describe("admin API", func() {
+		var profile *LiveProfile
+		var adminServer *AdminServer
+
+		send := func(method string, path string, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+			adminServer.Scenarios(recorder, request)
+			return recorder
+		}
+
+		BeforeEach(func() {
+			args := CommandLineArgs{Scenarios: []ScenarioArgs{outage}}
+			config := NewArgsConfigurationReader(&args).Read()
+			profile = NewLiveProfile(NewProfile(config))
+			adminServer = &AdminServer{Config: config, Profile: profile}
+		})
+
+		It("GET returns the state of every scenario", func() {
+			recorder := send("GET", ADMIN_SCENARIOS_PATH, "")
+			statuses := []ScenarioStatus{}
+			json.Unmarshal(recorder.Body.Bytes(), &statuses)
+			Expect(statuses).To(HaveLen(1))
+			Expect(statuses[0].State).To(Equal("healthy"))
+		})
+
+		It("PUT moves a scenario to a state", func() {
+			recorder := send("PUT", ADMIN_SCENARIOS_PATH+"/outage", `{"state":"down"}`)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(profile.Current().Config.scenario("outage").Status().State).To(Equal("down"))
+		})
+
+		It("PUT rejects an unknown state", func() {
+			Expect(send("PUT", ADMIN_SCENARIOS_PATH+"/outage", `{"state":"gone"}`).Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("DELETE moves a scenario back to its first state", func() {
+			send("PUT", ADMIN_SCENARIOS_PATH+"/outage", `{"state":"down"}`)
+			send("DELETE", ADMIN_SCENARIOS_PATH+"/outage", "")
+			Expect(profile.Current().Config.scenario("outage").Status().State).To(Equal("healthy"))
+		})
+
+		It("returns 404 for an unknown scenario", func() {
+			Expect(send("GET", ADMIN_SCENARIOS_PATH+"/nothing", "").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("keeps the state of unchanged scenarios when the configuration is updated", func() {
+			send("PUT", ADMIN_SCENARIOS_PATH+"/outage", `{"state":"down"}`)
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("PATCH", ADMIN_CONFIG_PATH, bytes.NewBufferString(`{"content":"bang"}`))
+			adminServer.Configuration(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(profile.Current().Config.scenario("outage").Status().State).To(Equal("down"))
+		})
+	})
+})
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net/http"
 	"time"
 )
 
@@ -8,6 +9,13 @@ type Snoozer interface {
 	Snooze()
 }
 
+// snoozeFor snoozes and notes how long for on the record of the request.
+func snoozeFor(r *http.Request, snoozer Snoozer) {
+	start := time.Now()
+	snoozer.Snooze()
+	recordSleep(r, time.Since(start))
+}
+
 type MaxSnoozer struct {
 	Max time.Duration
 }
@@ -32,7 +40,7 @@ func (instance *RandomSnoozer) Snooze() {
 }
 
 func NewRandomSnoozer(min time.Duration, max time.Duration) *RandomSnoozer {
-	return &RandomSnoozer{min, max, &RealRandom{}}
+	return &RandomSnoozer{min, max, NewRealRandom()}
 }
 
 type FakeSnoozer struct {
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	STREAM_FORMAT_SSE    string = "sse"
+	STREAM_FORMAT_NDJSON string = "ndjson"
+
+	STREAM_END_NONE       string = "none"
+	STREAM_END_STALL      string = "stall"
+	STREAM_END_TRUNCATE   string = "truncate"
+	STREAM_END_DISCONNECT string = "disconnect"
+
+	SSE_MIME_TYPE        string = "text/event-stream"
+	NDJSON_MIME_TYPE     string = "application/x-ndjson"
+	LAST_EVENT_ID_HEADER string = "Last-Event-ID"
+
+	// DEFAULT_STREAM_INTERVAL is the default of --stream-interval, which is
+	// also used by routes which do not set one.
+	DEFAULT_STREAM_INTERVAL string = "1s"
+)
+
+var (
+	STREAM_FORMATS []string = []string{STREAM_FORMAT_SSE, STREAM_FORMAT_NDJSON}
+	STREAM_ENDS    []string = []string{STREAM_END_NONE, STREAM_END_STALL, STREAM_END_TRUNCATE, STREAM_END_DISCONNECT}
+)
+
+// StreamArgs is the configuration file form of the /stream endpoint.  An
+// event is written every interval until events have been written, or forever
+// when events is 0.  The duplicate is the share of events which repeat the ID
+// of the event before and, once after events have been written, the end is
+// how the stream finishes.  The retry is sent to SSE clients as the time to
+// wait before reconnecting.
+type StreamArgs struct {
+	Format    string  `json:"format,omitempty"`
+	Interval  string  `json:"interval,omitempty"`
+	Events    int     `json:"events,omitempty"`
+	Duplicate float64 `json:"duplicate,omitempty"`
+	After     int     `json:"after,omitempty"`
+	End       string  `json:"end,omitempty"`
+	Retry     string  `json:"retry,omitempty"`
+}
+
+func (instance StreamArgs) withDefaults(defaults StreamArgs) StreamArgs {
+	if instance.Format == "" {
+		instance.Format = defaults.Format
+	}
+	if instance.Format == "" {
+		instance.Format = STREAM_FORMAT_SSE
+	}
+	if instance.Interval == "" {
+		instance.Interval = defaults.Interval
+	}
+	if instance.Interval == "" {
+		instance.Interval = DEFAULT_STREAM_INTERVAL
+	}
+	if instance.Events == 0 {
+		instance.Events = defaults.Events
+	}
+	if instance.Duplicate == 0 {
+		instance.Duplicate = defaults.Duplicate
+	}
+	if instance.After == 0 {
+		instance.After = defaults.After
+	}
+	if instance.End == "" {
+		instance.End = defaults.End
+	}
+	if instance.Retry == "" {
+		instance.Retry = defaults.Retry
+	}
+	return instance
+}
+
+func validateStream(args StreamArgs) error {
+	if args.Format != "" && !ContainsString(STREAM_FORMATS, args.Format) {
+		return fmt.Errorf("stream: format %q is not one of %v", args.Format, STREAM_FORMATS)
+	}
+	if args.End != "" && !ContainsString(STREAM_ENDS, args.End) {
+		return fmt.Errorf("stream: end %q is not one of %v", args.End, STREAM_ENDS)
+	}
+	for name, value := range map[string]string{"interval": args.Interval, "retry": args.Retry} {
+		if value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(value); err != nil || duration < 0 {
+			return fmt.Errorf("stream: cannot parse time from %s %q", name, value)
+		}
+	}
+	if args.Events < 0 || args.After < 0 {
+		return fmt.Errorf("stream: events and after cannot be negative")
+	}
+	if args.Duplicate < 0 || args.Duplicate > 1 {
+		return fmt.Errorf("stream: duplicate must be between 0 and 1")
+	}
+	return nil
+}
+
+// parseStreamArgs reads the format, interval, events, duplicate, after, end
+// and retry query parameters.
+func parseStreamArgs(query url.Values) (StreamArgs, error) {
+	args := StreamArgs{
+		Format:   query.Get("format"),
+		Interval: query.Get("interval"),
+		End:      query.Get("end"),
+		Retry:    query.Get("retry"),
+	}
+	var err error
+	for name, value := range map[string]*int{"events": &args.Events, "after": &args.After} {
+		if query.Get(name) == "" {
+			continue
+		}
+		if *value, err = strconv.Atoi(query.Get(name)); err != nil {
+			return args, fmt.Errorf("%s: %q is not a number", name, query.Get(name))
+		}
+	}
+	if value := query.Get("duplicate"); value != "" {
+		if args.Duplicate, err = strconv.ParseFloat(value, 64); err != nil {
+			return args, fmt.Errorf("duplicate: %q is not a number", value)
+		}
+	}
+	return args, validateStream(args)
+}
+
+// StreamEvent is an event of the stream, which is encoded as an SSE event or
+// a line of JSON.
+type StreamEvent struct {
+	ID   int    `json:"id"`
+	Data string `json:"data"`
+}
+
+func (instance StreamEvent) Encode(format string) []byte {
+	if format == STREAM_FORMAT_NDJSON {
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(instance)
+		return buffer.Bytes()
+	}
+	encoded := fmt.Sprintf("id: %d\n", instance.ID)
+	for _, line := range strings.Split(instance.Data, "\n") {
+		encoded += fmt.Sprintf("data: %s\n", line)
+	}
+	return []byte(encoded + "\n")
+}
+
+// StreamBehaviour writes the events of the stream, carrying on from the
+// Last-Event-ID of a reconnecting client.  The data of each event is the body
+// of the exchange, or the configured content when it has none.
+type StreamBehaviour struct {
+	Args   StreamArgs
+	random Random
+}
+
+func (instance *StreamBehaviour) Apply(exchange *Exchange) {
+	exchange.Handled = true
+	w := exchange.Writer
+	r := exchange.Request
+	args := instance.Args
+	id := 0
+	if last, err := strconv.Atoi(lastEventID(r)); err == nil {
+		id = last
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Cache-Control", "no-cache")
+	if args.Format == STREAM_FORMAT_NDJSON {
+		w.Header().Set("Content-Type", NDJSON_MIME_TYPE)
+	} else {
+		w.Header().Set("Content-Type", SSE_MIME_TYPE)
+	}
+	w.WriteHeader(exchange.Code)
+	if args.Format == STREAM_FORMAT_SSE && args.Retry != "" {
+		fmt.Fprintf(w, "retry: %d\n\n", parseTime(args.Retry)/time.Millisecond)
+	}
+	flush(w)
+	data := string(exchange.Body)
+	if data == "" {
+		data = exchange.Profile.Config.render(exchange.Profile.Config.content, r, exchange.Params)
+	}
+	ending := args.End != "" && args.End != STREAM_END_NONE
+	interval := parseTime(args.Interval)
+	for written := 0; args.Events == 0 || written < args.Events; written++ {
+		if ending && written >= args.After {
+			instance.end(exchange, StreamEvent{ID: id + 1, Data: data})
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(interval):
+		}
+		if written == 0 || args.Duplicate == 0 || instance.random.Float64() >= args.Duplicate {
+			id++
+		}
+		if _, err := w.Write(StreamEvent{ID: id, Data: data}.Encode(args.Format)); err != nil {
+			return
+		}
+		flush(w)
+	}
+}
+
+// end finishes the stream with the end of the args in place of the event.
+func (instance *StreamBehaviour) end(exchange *Exchange, event StreamEvent) {
+	switch instance.Args.End {
+	case STREAM_END_STALL:
+		<-exchange.Request.Context().Done()
+	case STREAM_END_TRUNCATE:
+		encoded := event.Encode(instance.Args.Format)
+		exchange.Writer.Write(encoded[:len(encoded)/2])
+		flush(exchange.Writer)
+		killConnection(exchange)
+	case STREAM_END_DISCONNECT:
+		killConnection(exchange)
+	}
+}
+
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get(LAST_EVENT_ID_HEADER); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// NewStreamBehaviour creates a StreamBehaviour, rejecting a stream which
+// never ends and has no interval, which would write events as fast as it can.
+func NewStreamBehaviour(args StreamArgs, random Random) (*StreamBehaviour, error) {
+	if err := validateStream(args); err != nil {
+		return nil, err
+	}
+	args = args.withDefaults(StreamArgs{})
+	if args.Events == 0 && parseTime(args.Interval) == 0 {
+		return nil, fmt.Errorf("stream: interval cannot be 0 when events is 0")
+	}
+	return &StreamBehaviour{
+		Args:   args,
+		random: random,
+	}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream", func() {
+
+	get := func(path string, lastEventID string) (*http.Response, string, error) {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d%s", PORT, path), nil)
+		if lastEventID != "" {
+			request.Header.Set(LAST_EVENT_ID_HEADER, lastEventID)
+		}
+		client := &http.Client{Timeout: 500 * time.Millisecond}
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, "", err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		return response, string(body), err
+	}
+
+	event := func(id int) string {
+		return fmt.Sprintf("id: %d\ndata: %s\n\n", id, testContent)
+	}
+
+	It("writes Server-Sent Events", func() {
+		response, body, err := get("/stream?interval=1ms&events=2", "")
+		Expect(err).To(BeNil())
+		Expect(response.Header.Get("Content-Type")).To(Equal(SSE_MIME_TYPE))
+		Expect(body).To(Equal(event(1) + event(2)))
+	})
+
+	It("carries on from the Last-Event-ID as NDJSON", func() {
+		response, body, err := get("/stream?interval=1ms&events=2&format=ndjson", "41")
+		Expect(err).To(BeNil())
+		Expect(response.Header.Get("Content-Type")).To(Equal(NDJSON_MIME_TYPE))
+		Expect(body).To(Equal(`{"id":42,"data":"<xml type=\"foobar\"></xml>"}` + "\n" + `{"id":43,"data":"<xml type=\"foobar\"></xml>"}` + "\n"))
+	})
+
+	It("sends the retry and duplicates the event IDs", func() {
+		_, body, err := get("/stream?interval=1ms&events=3&duplicate=1&retry=2s", "")
+		Expect(err).To(BeNil())
+		Expect(body).To(Equal("retry: 2000\n\n" + event(1) + event(1) + event(1)))
+	})
+
+	It("drops the connection after the events", func() {
+		_, body, err := get("/stream?interval=1ms&after=2&end=disconnect", "")
+		Expect(err).ToNot(BeNil())
+		Expect(body).To(Equal(event(1) + event(2)))
+	})
+
+	It("truncates the event after the events", func() {
+		_, body, err := get("/stream?interval=1ms&after=1&end=truncate", "")
+		Expect(err).ToNot(BeNil())
+		Expect(strings.HasPrefix(body, event(1)+"id: 2")).To(BeTrue())
+		Expect(strings.HasSuffix(body, "\n\n")).To(BeFalse())
+	})
+
+	It("stalls after the events", func() {
+		start := time.Now()
+		_, _, err := get("/stream?interval=1ms&after=1&end=stall", "")
+		Expect(err).ToNot(BeNil())
+		Expect(time.Since(start) >= 500*time.Millisecond).To(BeTrue())
+	})
+
+	It("waits the default interval when none is set", func() {
+		behaviour, err := NewStreamBehaviour(StreamArgs{}, nil)
+		Expect(err).To(BeNil())
+		Expect(behaviour.Args.Interval).To(Equal(DEFAULT_STREAM_INTERVAL))
+	})
+
+	It("rejects a stream without an interval which never ends", func() {
+		response, _, err := get("/stream?interval=0s", "")
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		_, err = NewBehaviour(BehaviourArgs{Stream: &StreamArgs{Interval: "0s"}})
+		Expect(err).ToNot(BeNil())
+		_, err = NewBehaviour(BehaviourArgs{Stream: &StreamArgs{Interval: "0s", Events: 3}})
+		Expect(err).To(BeNil())
+	})
+
+	It("rejects invalid query parameters", func() {
+		response, _, err := get("/stream?format=xml", "")
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		_, err = NewBehaviour(BehaviourArgs{Stream: &StreamArgs{Duplicate: 2}})
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	TLS_FAULT_NONE                string = "none"
+	TLS_FAULT_EXPIRED             string = "expired"
+	TLS_FAULT_WRONG_HOSTNAME      string = "wrong_hostname"
+	TLS_FAULT_UNTRUSTED           string = "untrusted"
+	TLS_FAULT_UNSUPPORTED_VERSION string = "unsupported_version"
+	TLS_FAULT_HANDSHAKE_STALL     string = "handshake_stall"
+	TLS_FAULT_HANDSHAKE_CLOSE     string = "handshake_close"
+
+	TLS_CLIENT_AUTH_NONE    string = "none"
+	TLS_CLIENT_AUTH_REQUEST string = "request"
+	TLS_CLIENT_AUTH_REQUIRE string = "require"
+
+	TLS_WRONG_HOSTNAME string = "wrong.host.invalid"
+	PEM_MIME_TYPE      string = "application/x-pem-file"
+)
+
+var (
+	TLS_FAULTS []string = []string{TLS_FAULT_NONE, TLS_FAULT_EXPIRED, TLS_FAULT_WRONG_HOSTNAME, TLS_FAULT_UNTRUSTED,
+		TLS_FAULT_UNSUPPORTED_VERSION, TLS_FAULT_HANDSHAKE_STALL, TLS_FAULT_HANDSHAKE_CLOSE}
+	TLS_CLIENT_AUTHS []string = []string{TLS_CLIENT_AUTH_NONE, TLS_CLIENT_AUTH_REQUEST, TLS_CLIENT_AUTH_REQUIRE}
+)
+
+// TLSArgs is the configuration file form of the HTTPS listener, which is
+// disabled when the port is 0.  Without a cert and key the listener uses a
+// certificate issued by a CA generated when enanos starts.  The client auth
+// and fault are read for every handshake so they can be changed at runtime.
+type TLSArgs struct {
+	Port       int    `json:"port"`
+	Cert       string `json:"cert,omitempty"`
+	Key        string `json:"key,omitempty"`
+	ClientCA   string `json:"clientca,omitempty"`
+	ClientAuth string `json:"clientauth,omitempty"`
+	Fault      string `json:"fault,omitempty"`
+}
+
+func validateTLS(args TLSArgs) error {
+	if args.Fault != "" && !ContainsString(TLS_FAULTS, args.Fault) {
+		return fmt.Errorf("tls: fault %q is not one of %v", args.Fault, TLS_FAULTS)
+	}
+	if args.ClientAuth != "" && !ContainsString(TLS_CLIENT_AUTHS, args.ClientAuth) {
+		return fmt.Errorf("tls: clientauth %q is not one of %v", args.ClientAuth, TLS_CLIENT_AUTHS)
+	}
+	if (args.Cert == "") != (args.Key == "") {
+		return fmt.Errorf("tls: cert and key must be supplied together")
+	}
+	if args.Cert != "" {
+		if _, err := tls.LoadX509KeyPair(args.Cert, args.Key); err != nil {
+			return fmt.Errorf("tls: cannot load the cert and key: %v", err)
+		}
+	}
+	if args.ClientCA != "" {
+		if _, err := loadCertPool(args.ClientCA); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %q", path)
+	}
+	return pool, nil
+}
+
+// CertificateAuthority issues the certificates for the HTTPS listener, its
+// faults and clients, so that a test only has to trust the one CA.
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// PEM returns the certificate of the CA for clients to trust.
+func (instance *CertificateAuthority) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: instance.Certificate.Raw})
+}
+
+// Issue creates a certificate for the names, which are host names or IP
+// addresses, valid between notBefore and notAfter.
+func (instance *CertificateAuthority) Issue(names []string, notBefore time.Time, notAfter time.Time, usage x509.ExtKeyUsage) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{Organization: []string{"enanos"}, CommonName: names[0]},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, instance.Certificate, &key.PublicKey, instance.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// encodeCertificate returns the certificate and key in PEM form.
+func encodeCertificate(certificate tls.Certificate) ([]byte, error) {
+	key, err := x509.MarshalECPrivateKey(certificate.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key})...), nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+func NewCertificateAuthority() (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{Organization: []string{"enanos"}, CommonName: "enanos CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{Certificate: certificate, key: key}, nil
+}
+
+// TLSFaultListener handles the handshake faults which happen before any TLS
+// is spoken, passing every other connection on to be served.
+type TLSFaultListener struct {
+	net.Listener
+	fault func() string
+}
+
+func (instance *TLSFaultListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := instance.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		switch instance.fault() {
+		case TLS_FAULT_HANDSHAKE_STALL:
+			go stallHandshake(conn)
+		case TLS_FAULT_HANDSHAKE_CLOSE:
+			go closeHandshake(conn)
+		default:
+			return conn, nil
+		}
+	}
+}
+
+// stallHandshake reads whatever the client sends but never replies.
+func stallHandshake(conn net.Conn) {
+	io.Copy(ioutil.Discard, conn)
+	conn.Close()
+}
+
+// closeHandshake reads the ClientHello and then closes the connection with a
+// TCP RST instead of replying.
+func closeHandshake(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err == nil {
+		io.CopyN(ioutil.Discard, conn, int64(header[3])<<8|int64(header[4]))
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// TLSServer serves the same routes and endpoints as the HarnessServer over
+// HTTPS, and HTTP/2 when the client negotiates it, optionally requiring client
+// certificates, with faults injected into the handshake.
+type TLSServer struct {
+	Config       Configuration
+	Profile      *LiveProfile
+	Observers    []RequestObserver
+	Authority    *CertificateAuthority
+	Server       *HTTPServer
+	certificates map[string]tls.Certificate
+	clientCAs    *x509.CertPool
+}
+
+func (instance *TLSServer) Start() {
+	config := instance.Config
+	if config.tls.Port == 0 {
+		return
+	}
+	if err := instance.load(); err != nil {
+		fmt.Println(fmt.Sprintf("Cannot start the TLS server: %v", err))
+		return
+	}
+	instance.Server = NewHTTPServer(config.tls.Port, config.host)
+	instance.Server.HTTP2 = NewHTTP2Server(instance.Profile)
+	instance.Server.Wrap = func(listener net.Listener) net.Listener {
+		faults := &TLSFaultListener{listener, instance.fault}
+		return tls.NewListener(faults, &tls.Config{GetConfigForClient: instance.configFor})
+	}
+
+	router := NewRouter(instance.Profile, instance.Observers...)
+	for key, value := range endpoints(NewDefultHttpHandler(instance.Profile)) {
+		router.HandleFunc(key, value)
+	}
+	instance.Server.Handle("/", router)
+
+	if err := instance.Server.Start(); err != nil {
+		fmt.Println(fmt.Sprintf("Cannot start the TLS server: %v", err))
+	}
+}
+
+func (instance *TLSServer) Stop() {
+	if instance.Server != nil {
+		instance.Server.Stop()
+	}
+}
+
+// load reads or issues the certificate for each fault.
+func (instance *TLSServer) load() error {
+	args := instance.Config.tls
+	names := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		names = append(names, hostname)
+	}
+	if instance.Config.host != "" && instance.Config.host != "0.0.0.0" {
+		names = append(names, instance.Config.host)
+	}
+	now := time.Now()
+	instance.certificates = map[string]tls.Certificate{}
+	var err error
+	if args.Cert != "" {
+		instance.certificates[TLS_FAULT_NONE], err = tls.LoadX509KeyPair(args.Cert, args.Key)
+	} else {
+		instance.certificates[TLS_FAULT_NONE], err = instance.Authority.Issue(names, now.Add(-time.Hour), now.AddDate(1, 0, 0), x509.ExtKeyUsageServerAuth)
+	}
+	if err != nil {
+		return err
+	}
+	instance.certificates[TLS_FAULT_EXPIRED], err = instance.Authority.Issue(names, now.AddDate(-1, 0, 0), now.AddDate(0, 0, -1), x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return err
+	}
+	instance.certificates[TLS_FAULT_WRONG_HOSTNAME], err = instance.Authority.Issue([]string{TLS_WRONG_HOSTNAME}, now.Add(-time.Hour), now.AddDate(1, 0, 0), x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return err
+	}
+	untrusted, err := NewCertificateAuthority()
+	if err != nil {
+		return err
+	}
+	instance.certificates[TLS_FAULT_UNTRUSTED], err = untrusted.Issue(names, now.Add(-time.Hour), now.AddDate(1, 0, 0), x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return err
+	}
+	if args.ClientCA != "" {
+		instance.clientCAs, err = loadCertPool(args.ClientCA)
+		return err
+	}
+	instance.clientCAs = x509.NewCertPool()
+	instance.clientCAs.AddCert(instance.Authority.Certificate)
+	return nil
+}
+
+func (instance *TLSServer) fault() string {
+	return instance.Profile.Current().Config.tls.Fault
+}
+
+// configFor picks the certificate, versions and client auth for a handshake
+// from the live configuration.
+func (instance *TLSServer) configFor(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	args := instance.Profile.Current().Config.tls
+	certificate, ok := instance.certificates[args.Fault]
+	if !ok {
+		certificate = instance.certificates[TLS_FAULT_NONE]
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    instance.clientCAs,
+		NextProtos:   []string{http2.NextProtoTLS, "http/1.1"},
+	}
+	switch args.ClientAuth {
+	case TLS_CLIENT_AUTH_REQUEST:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case TLS_CLIENT_AUTH_REQUIRE:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if args.Fault == TLS_FAULT_UNSUPPORTED_VERSION {
+		config.MinVersion = tls.VersionTLS10
+		config.MaxVersion = tls.VersionTLS10
+	}
+	return config, nil
+}
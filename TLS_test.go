@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS", func() {
+
+	const TLS_PORT int = 8443
+
+	var authority *CertificateAuthority
+	var profile *LiveProfile
+	var server *TLSServer
+
+	useTLS := func(args TLSArgs) {
+		config := Configuration{}
+		config.content = "hello"
+		config.tls = args
+		config.tls.Port = TLS_PORT
+		profile.Swap(Profile{Config: config})
+	}
+
+	get := func(certificates ...tls.Certificate) (*http.Response, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(authority.Certificate)
+		client := &http.Client{
+			Timeout: 500 * time.Millisecond,
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certificates},
+				DisableKeepAlives: true,
+				ForceAttemptHTTP2: true,
+			},
+		}
+		return client.Get(fmt.Sprintf("https://localhost:%d/success", TLS_PORT))
+	}
+
+	BeforeEach(func() {
+		authority, _ = NewCertificateAuthority()
+		config := Configuration{}
+		config.host = "localhost"
+		config.tls = TLSArgs{Port: TLS_PORT}
+		profile = NewLiveProfile(Profile{Config: config})
+		server = &TLSServer{Config: config, Profile: profile, Authority: authority}
+		server.Start()
+		useTLS(TLSArgs{})
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	It("serves the endpoints with a certificate issued by the generated CA", func() {
+		response, err := get()
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("negotiates HTTP/2", func() {
+		response, err := get()
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		Expect(response.ProtoMajor).To(Equal(2))
+	})
+
+	handshake := func(fault string) error {
+		useTLS(TLSArgs{Fault: fault})
+		_, err := get()
+		Expect(err).ToNot(BeNil())
+		return err
+	}
+
+	It("fails the handshake with an expired certificate", func() {
+		invalid := x509.CertificateInvalidError{}
+		Expect(errors.As(handshake(TLS_FAULT_EXPIRED), &invalid)).To(BeTrue())
+		Expect(invalid.Reason).To(Equal(x509.Expired))
+	})
+
+	It("fails the handshake with a certificate for the wrong host", func() {
+		hostname := x509.HostnameError{}
+		Expect(errors.As(handshake(TLS_FAULT_WRONG_HOSTNAME), &hostname)).To(BeTrue())
+		Expect(hostname.Host).To(Equal("localhost"))
+	})
+
+	It("fails the handshake with a certificate from an unknown authority", func() {
+		unknown := x509.UnknownAuthorityError{}
+		Expect(errors.As(handshake(TLS_FAULT_UNTRUSTED), &unknown)).To(BeTrue())
+	})
+
+	It("fails the handshake with a protocol version alert", func() {
+		Expect(handshake(TLS_FAULT_UNSUPPORTED_VERSION).Error()).To(ContainSubstring("protocol version not supported"))
+	})
+
+	It("times out a stalled handshake", func() {
+		var timeout net.Error
+		Expect(errors.As(handshake(TLS_FAULT_HANDSHAKE_STALL), &timeout)).To(BeTrue())
+		Expect(timeout.Timeout()).To(BeTrue())
+	})
+
+	It("fails the handshake with the connection closed", func() {
+		err := handshake(TLS_FAULT_HANDSHAKE_CLOSE)
+		Expect(errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)).To(BeTrue(), err.Error())
+	})
+
+	It("requires a client certificate issued by the CA", func() {
+		useTLS(TLSArgs{ClientAuth: TLS_CLIENT_AUTH_REQUIRE})
+		_, err := get()
+		Expect(err).ToNot(BeNil())
+		now := time.Now()
+		certificate, _ := authority.Issue([]string{"client"}, now.Add(-time.Hour), now.Add(time.Hour), x509.ExtKeyUsageClientAuth)
+		response, err := get(certificate)
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("rejects an unknown fault", func() {
+		Expect(validateTLS(TLSArgs{Fault: "broken"})).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	TEMPLATE_DELIMITER  string = "{{"
+	TEMPLATE_CHARACTERS string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+var (
+	// The parsed templates by their text, so that each is only parsed once.
+	parsedTemplates sync.Map
+	// The counters live as long as enanos so that reloading the configuration
+	// does not reset them.
+	templateCounters = NewTemplateCounters()
+	templateRandom   = NewRealRandom()
+	templateFuncs    = template.FuncMap{
+		"now":          time.Now,
+		"uuid":         newUUID,
+		"counter":      templateCounters.Next,
+		"randomInt":    randomInt,
+		"randomString": randomString,
+		"randomChoice": randomChoice,
+	}
+)
+
+// TemplateRequest is the request as seen by a template, e.g.
+// {{.Header "X-Correlation-Id"}}, {{.Param "id"}} or {{.JSON "order.lines.0.sku"}}.
+type TemplateRequest struct {
+	Method  string
+	Path    string
+	Host    string
+	Params  map[string]string
+	request *http.Request
+	body    []byte
+	read    bool
+}
+
+func (instance *TemplateRequest) Query(name string) string {
+	return instance.request.URL.Query().Get(name)
+}
+
+func (instance *TemplateRequest) Header(name string) string {
+	return instance.request.Header.Get(name)
+}
+
+func (instance *TemplateRequest) Param(name string) string {
+	return instance.Params[name]
+}
+
+func (instance *TemplateRequest) Body() string {
+	if !instance.read {
+		instance.body = readBody(instance.request)
+		instance.read = true
+	}
+	return string(instance.body)
+}
+
+// JSON returns the field of the JSON body at the dot separated path, or an
+// empty string when there is no such field.
+func (instance *TemplateRequest) JSON(path string) string {
+	instance.Body()
+	value, _ := jsonField(instance.body, path)
+	return value
+}
+
+func NewTemplateRequest(r *http.Request, params map[string]string) *TemplateRequest {
+	return &TemplateRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Host:    r.Host,
+		Params:  params,
+		request: r,
+	}
+}
+
+// TemplateCounters are the named counters of the counter template function.
+type TemplateCounters struct {
+	mutex    sync.Mutex
+	counters map[string]int
+}
+
+// Next increments the counter and returns its new value, starting at 1.
+func (instance *TemplateCounters) Next(name string) int {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.counters[name]++
+	return instance.counters[name]
+}
+
+func NewTemplateCounters() *TemplateCounters {
+	return &TemplateCounters{counters: map[string]int{}}
+}
+
+// parseTemplate parses text holding a Go template, returning nil for plain
+// text.
+func parseTemplate(text string) (*template.Template, error) {
+	if !strings.Contains(text, TEMPLATE_DELIMITER) {
+		return nil, nil
+	}
+	if parsed, ok := parsedTemplates.Load(text); ok {
+		return parsed.(*template.Template), nil
+	}
+	parsed, err := template.New("response").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the template %q: %v", text, err)
+	}
+	parsedTemplates.Store(text, parsed)
+	return parsed, nil
+}
+
+// renderTemplate renders text holding a Go template for the request, or
+// returns plain text as it is.  A template which fails is rendered as the
+// error, so that it shows up in the response.
+func renderTemplate(text string, r *http.Request, params map[string]string) string {
+	parsed, err := parseTemplate(text)
+	if err != nil {
+		return err.Error()
+	}
+	if parsed == nil {
+		return text
+	}
+	var rendered bytes.Buffer
+	if err := parsed.Execute(&rendered, NewTemplateRequest(r, params)); err != nil {
+		return err.Error()
+	}
+	return rendered.String()
+}
+
+// validateTemplates checks that each of the texts parses.
+func validateTemplates(texts ...string) error {
+	for _, text := range texts {
+		if _, err := parseTemplate(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newUUID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// randomInt returns a number from min to max inclusive.
+func randomInt(min int, max int) int {
+	if max < min {
+		min, max = max, min
+	}
+	return templateRandom.Int(min, max+1)
+}
+
+func randomChoice(choices ...string) string {
+	if len(choices) == 0 {
+		return ""
+	}
+	return choices[templateRandom.Int(0, len(choices))]
+}
+
+func randomString(length int) string {
+	text := make([]byte, length)
+	for i := range text {
+		text[i] = TEMPLATE_CHARACTERS[templateRandom.Int(0, len(TEMPLATE_CHARACTERS))]
+	}
+	return string(text)
+}
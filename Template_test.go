@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Templates", func() {
+
+	render := func(text string, body string) string {
+		request := httptest.NewRequest("POST", "/orders/7?expand=lines", strings.NewReader(body))
+		request.Header.Set("X-Correlation-Id", "abc")
+		return renderTemplate(text, request, map[string]string{"id": "7"})
+	}
+
+	It("leaves plain text as it is", func() {
+		Expect(render("hello world", "")).To(Equal("hello world"))
+	})
+
+	It("renders the fields of the request", func() {
+		Expect(render(`{{.Method}} {{.Path}} {{.Param "id"}} {{.Query "expand"}} {{.Header "X-Correlation-Id"}}`, "")).To(Equal("POST /orders/7 7 lines abc"))
+		body := `{"lines":[{"sku":"A-1","quantity":2}]}`
+		Expect(render(`{{.JSON "lines.0.sku"}} {{.JSON "lines.0"}} {{.JSON "missing.field"}}|{{.Body}}`, body)).To(Equal(`A-1 {"quantity":2,"sku":"A-1"} |` + body))
+	})
+
+	It("renders the helpers", func() {
+		Expect(render("{{uuid}}", "")).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Expect(render("{{randomString 12}}", "")).To(MatchRegexp(`^[a-zA-Z0-9]{12}$`))
+		Expect(render(`{{randomChoice "a" "b"}}`, "")).To(Or(Equal("a"), Equal("b")))
+		number, err := strconv.Atoi(render("{{randomInt 3 4}}", ""))
+		Expect(err).To(BeNil())
+		Expect(number).To(BeNumerically(">=", 3))
+		Expect(number).To(BeNumerically("<=", 4))
+		Expect(render(`{{now.Year}}`, "")).To(MatchRegexp(`^\d{4}$`))
+		first, _ := strconv.Atoi(render(`{{counter "template-test"}}`, ""))
+		Expect(render(`{{counter "template-test"}}`, "")).To(Equal(strconv.Itoa(first + 1)))
+	})
+
+	It("renders the content, headers and route behaviours", func() {
+		route, err := NewRoute(RouteArgs{
+			Path: "/orders/{id}",
+			Behaviours: []BehaviourArgs{
+				{Headers: []string{`Location:/orders/{{.Param "id"}}`}, Template: true},
+				{Fault: FAULT_SUCCESS},
+			},
+		})
+		Expect(err).To(BeNil())
+		recorder := httptest.NewRecorder()
+		request := newRequest("GET", "/orders/7")
+		request.Header.Set("X-Correlation-Id", "abc")
+		config := Configuration{content: `order {{.Param "id"}}`, headers: []string{`X-Correlation-Id:{{.Header "X-Correlation-Id"}}`}, routes: []*Route{route}, templates: true}
+		NewRouter(NewLiveProfile(Profile{Config: config})).ServeHTTP(recorder, request)
+		Expect(recorder.Header().Get("Location")).To(Equal("/orders/7"))
+		Expect(recorder.Header().Get("X-Correlation-Id")).To(Equal("abc"))
+		Expect(recorder.Body.String()).To(Equal("order 7"))
+	})
+
+	It("sends content and headers as they are unless templates are switched on", func() {
+		route, err := NewRoute(RouteArgs{
+			Path:       "/orders/{id}",
+			Behaviours: []BehaviourArgs{{Headers: []string{`Location:{{.Param "id"}}`}}, {Content: "{{.Param"}},
+		})
+		Expect(err).To(BeNil())
+		recorder := httptest.NewRecorder()
+		config := Configuration{headers: []string{"X-Order:{{.Param"}, routes: []*Route{route}}
+		NewRouter(NewLiveProfile(Profile{Config: config})).ServeHTTP(recorder, newRequest("GET", "/orders/7"))
+		Expect(recorder.Header().Get("Location")).To(Equal(`{{.Param "id"}}`))
+		Expect(recorder.Body.String()).To(Equal("{{.Param"))
+		Expect(NewArgsConfigurationReader(&CommandLineArgs{Content: "{{nothing}}"}).Validate()).To(BeNil())
+	})
+
+	It("reads no more of the body than the limit and leaves all of it to be read", func() {
+		body := strings.Repeat("a", int(READ_BODY_LIMIT)+10)
+		request := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+		Expect(NewTemplateRequest(request, nil).Body()).To(HaveLen(int(READ_BODY_LIMIT)))
+		Expect(readBody(request)).To(HaveLen(int(READ_BODY_LIMIT)))
+		rest, _ := ioutil.ReadAll(request.Body)
+		Expect(rest).To(HaveLen(len(body)))
+	})
+
+	It("rejects templates which cannot be parsed", func() {
+		_, err := NewBehaviour(BehaviourArgs{Content: "{{.Param", Template: true})
+		Expect(err).ToNot(BeNil())
+		_, err = NewBehaviour(BehaviourArgs{Status: 200, Template: true})
+		Expect(err).ToNot(BeNil())
+		reader := NewArgsConfigurationReader(&CommandLineArgs{Content: "{{nothing}}", Templates: true})
+		Expect(reader.Validate()).ToNot(BeNil())
+	})
+})
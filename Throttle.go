@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ThrottleArgs is the configuration file form of a Throttle.
+type ThrottleArgs struct {
+	Rate   string `json:"rate,omitempty"`
+	Chunk  string `json:"chunk,omitempty"`
+	Delay  string `json:"delay,omitempty"`
+	Jitter string `json:"jitter,omitempty"`
+}
+
+var (
+	// DEFAULT_THROTTLE is used by the /slow_body endpoint when no throttle is configured.
+	DEFAULT_THROTTLE ThrottleArgs = ThrottleArgs{Rate: "1KB", Chunk: "128B"}
+)
+
+// Throttle writes a body in chunks, pausing between each one so that the
+// body is sent at no more than Rate bytes per second, plus Delay and a random
+// amount up to Jitter.
+type Throttle struct {
+	Args   ThrottleArgs
+	Rate   uint64
+	Chunk  int
+	Delay  time.Duration
+	Jitter time.Duration
+	random Random
+}
+
+func (instance *Throttle) Write(w io.Writer, data []byte) (int, error) {
+	flusher, _ := w.(http.Flusher)
+	written := 0
+	for written < len(data) {
+		end := written + instance.Chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		n, err := w.Write(data[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if written < len(data) {
+			time.Sleep(instance.Pause(n))
+		}
+	}
+	return written, nil
+}
+
+// Pause returns how long to wait after writing a chunk of the given size.
+func (instance *Throttle) Pause(size int) time.Duration {
+	pause := instance.Delay
+	if instance.Rate > 0 {
+		pause += time.Duration(uint64(size) * uint64(time.Second) / instance.Rate)
+	}
+	if instance.Jitter > 0 {
+		pause += instance.random.Duration(0, instance.Jitter)
+	}
+	return pause
+}
+
+func NewThrottle(args ThrottleArgs, random Random) (*Throttle, error) {
+	throttle := &Throttle{Args: args, Chunk: 128, random: random}
+	if args.Rate != "" {
+		rate, err := humanize.ParseBytes(args.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("throttle: cannot parse rate from %q", args.Rate)
+		}
+		throttle.Rate = rate
+	}
+	if args.Chunk != "" {
+		chunk, err := humanize.ParseBytes(args.Chunk)
+		if err != nil || chunk == 0 {
+			return nil, fmt.Errorf("throttle: cannot parse chunk from %q", args.Chunk)
+		}
+		throttle.Chunk = int(chunk)
+	}
+	if args.Delay != "" {
+		delay, err := time.ParseDuration(args.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("throttle: cannot parse delay from %q", args.Delay)
+		}
+		throttle.Delay = delay
+	}
+	if args.Jitter != "" {
+		jitter, err := time.ParseDuration(args.Jitter)
+		if err != nil {
+			return nil, fmt.Errorf("throttle: cannot parse jitter from %q", args.Jitter)
+		}
+		throttle.Jitter = jitter
+	}
+	return throttle, nil
+}
+
+// ThrottleBehaviour sends the body of the response through a Throttle.
+type ThrottleBehaviour struct {
+	Throttle *Throttle
+}
+
+func (instance *ThrottleBehaviour) Apply(exchange *Exchange) {
+	exchange.Throttle = instance.Throttle
+}
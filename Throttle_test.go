@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Throttle", func() {
+
+	var random *FakeRandom
+
+	BeforeEach(func() {
+		random = NewFakeRandom()
+	})
+
+	Describe("Pause", func() {
+		It("waits long enough to keep to the rate", func() {
+			throttle, _ := NewThrottle(ThrottleArgs{Rate: "100B"}, random)
+			Expect(throttle.Pause(10)).To(Equal(100 * time.Millisecond))
+		})
+
+		It("adds the delay and the jitter", func() {
+			random.ForDurationUse(5 * time.Millisecond)
+			throttle, _ := NewThrottle(ThrottleArgs{Delay: "10ms", Jitter: "20ms"}, random)
+			Expect(throttle.Pause(10)).To(Equal(15 * time.Millisecond))
+		})
+	})
+
+	Describe("Write", func() {
+		It("writes the data in chunks with a pause between each one", func() {
+			throttle, _ := NewThrottle(ThrottleArgs{Chunk: "2B", Delay: "10ms"}, random)
+			buffer := &bytes.Buffer{}
+			start := time.Now()
+			written, err := throttle.Write(buffer, []byte("foobar"))
+			Expect(err).To(BeNil())
+			Expect(written).To(Equal(6))
+			Expect(buffer.String()).To(Equal("foobar"))
+			Expect(time.Since(start) >= 20*time.Millisecond).To(BeTrue())
+		})
+	})
+
+	Describe("ThrottleBehaviour", func() {
+		It("declares the full length of the throttled body", func() {
+			route, err := NewRoute(RouteArgs{
+				Path: "/",
+				Behaviours: []BehaviourArgs{
+					{Content: "foobar"},
+					{Throttle: &ThrottleArgs{Chunk: "1B"}},
+				},
+			})
+			Expect(err).To(BeNil())
+			recorder := httptest.NewRecorder()
+			route.Serve(recorder, newRequest("GET", "/"), nil, Profile{})
+			Expect(recorder.Header().Get("Content-Length")).To(Equal("6"))
+			Expect(recorder.Body.String()).To(Equal("foobar"))
+		})
+	})
+
+	It("rejects an invalid rate", func() {
+		_, err := NewThrottle(ThrottleArgs{Rate: "fast"}, random)
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -1,5 +1,21 @@
 package main
 
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// READ_BODY_LIMIT is the most of a request body read for templates and
+	// matching, so that a large upload is not held in memory.
+	READ_BODY_LIMIT int64 = 1024 * 1024
+)
+
 func ContainsInt(array []int, item int) bool {
 	for _, arrayItem := range array {
 		if item == arrayItem {
@@ -8,3 +24,61 @@ func ContainsInt(array []int, item int) bool {
 	}
 	return false
 }
+
+func ContainsString(array []string, item string) bool {
+	for _, arrayItem := range array {
+		if item == arrayItem {
+			return true
+		}
+	}
+	return false
+}
+
+// readBody reads up to READ_BODY_LIMIT bytes of the request body, replacing
+// it so that the whole body can still be read.
+func readBody(r *http.Request) []byte {
+	if r.Body == nil {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(r.Body, READ_BODY_LIMIT))
+	r.Body = &replayedBody{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	return body
+}
+
+// replayedBody reads the part of a body which was already read and then the
+// rest of it.
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// jsonField returns the field of the JSON body at the dot separated path,
+// where numbers index into arrays.  Strings are returned as they are and
+// anything else as JSON.
+func jsonField(body []byte, path string) (string, bool) {
+	var value interface{}
+	if json.Unmarshal(body, &value) != nil {
+		return "", false
+	}
+	for _, name := range strings.Split(path, ".") {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			if value = current[name]; value == nil {
+				return "", false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(current) {
+				return "", false
+			}
+			value = current[index]
+		default:
+			return "", false
+		}
+	}
+	if text, ok := value.(string); ok {
+		return text, true
+	}
+	data, _ := json.Marshal(value)
+	return string(data), true
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WEBSOCKET_END_NONE       string = "none"
+	WEBSOCKET_END_CLOSE      string = "close"
+	WEBSOCKET_END_DISCONNECT string = "disconnect"
+	WEBSOCKET_END_MALFORMED  string = "malformed"
+
+	WEBSOCKET_GUID          string        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	WEBSOCKET_VERSION       string        = "13"
+	WEBSOCKET_CLOSE_NORMAL  int           = 1000
+	WEBSOCKET_CLOSE_ERROR   int           = 1002
+	WEBSOCKET_CLOSE_TIMEOUT time.Duration = time.Second
+	WEBSOCKET_MAX_PAYLOAD   uint64        = 16 << 20
+
+	websocketContinuation byte = 0x0
+	websocketText         byte = 0x1
+	websocketBinary       byte = 0x2
+	websocketClose        byte = 0x8
+	websocketPing         byte = 0x9
+	websocketPong         byte = 0xA
+	websocketFinal        byte = 0x80
+	//All three reserved bits with a reserved opcode, which no client accepts
+	websocketMalformed byte = 0x73
+)
+
+var (
+	WEBSOCKET_ENDS []string = []string{WEBSOCKET_END_NONE, WEBSOCKET_END_CLOSE, WEBSOCKET_END_DISCONNECT, WEBSOCKET_END_MALFORMED}
+)
+
+// WebSocketArgs is the configuration file form of the chaos of the /ws
+// endpoints.  The interval is waited before each message is sent, the drop is
+// the share of messages which are never sent and, once after messages have
+// been sent or dropped, the end is how the connection finishes.
+type WebSocketArgs struct {
+	Messages    []string `json:"messages,omitempty"`
+	Interval    string   `json:"interval,omitempty"`
+	Drop        float64  `json:"drop,omitempty"`
+	After       int      `json:"after,omitempty"`
+	End         string   `json:"end,omitempty"`
+	CloseCode   int      `json:"closecode,omitempty"`
+	IgnorePings bool     `json:"ignorepings,omitempty"`
+}
+
+func (instance WebSocketArgs) withDefaults(defaults WebSocketArgs) WebSocketArgs {
+	if len(instance.Messages) == 0 {
+		instance.Messages = defaults.Messages
+	}
+	if instance.Interval == "" {
+		instance.Interval = defaults.Interval
+	}
+	if instance.Drop == 0 {
+		instance.Drop = defaults.Drop
+	}
+	if instance.After == 0 {
+		instance.After = defaults.After
+	}
+	if instance.End == "" {
+		instance.End = defaults.End
+	}
+	if instance.CloseCode == 0 {
+		instance.CloseCode = defaults.CloseCode
+	}
+	instance.IgnorePings = instance.IgnorePings || defaults.IgnorePings
+	return instance
+}
+
+func validateWebSocket(args WebSocketArgs) error {
+	if args.End != "" && !ContainsString(WEBSOCKET_ENDS, args.End) {
+		return fmt.Errorf("websocket: end %q is not one of %v", args.End, WEBSOCKET_ENDS)
+	}
+	if args.Interval != "" {
+		if interval, err := time.ParseDuration(args.Interval); err != nil || interval < 0 {
+			return fmt.Errorf("websocket: cannot parse time from interval %q", args.Interval)
+		}
+	}
+	if args.Drop < 0 || args.Drop > 1 {
+		return fmt.Errorf("websocket: drop must be between 0 and 1")
+	}
+	if args.After < 0 {
+		return fmt.Errorf("websocket: after cannot be negative")
+	}
+	if args.CloseCode != 0 && (args.CloseCode < 1000 || args.CloseCode > 4999) {
+		return fmt.Errorf("websocket: closecode must be between 1000 and 4999")
+	}
+	return nil
+}
+
+// parseWebSocketArgs reads the message, interval, drop, after, end, closecode
+// and ignorepings query parameters.
+func parseWebSocketArgs(query url.Values) (WebSocketArgs, error) {
+	args := WebSocketArgs{
+		Messages: query["message"],
+		Interval: query.Get("interval"),
+		End:      query.Get("end"),
+	}
+	var err error
+	if value := query.Get("drop"); value != "" {
+		if args.Drop, err = strconv.ParseFloat(value, 64); err != nil {
+			return args, fmt.Errorf("drop: %q is not a number", value)
+		}
+	}
+	if value := query.Get("after"); value != "" {
+		if args.After, err = strconv.Atoi(value); err != nil {
+			return args, fmt.Errorf("after: %q is not a number", value)
+		}
+	}
+	if value := query.Get("closecode"); value != "" {
+		if args.CloseCode, err = strconv.Atoi(value); err != nil {
+			return args, fmt.Errorf("closecode: %q is not a number", value)
+		}
+	}
+	if value := query.Get("ignorepings"); value != "" {
+		if args.IgnorePings, err = strconv.ParseBool(value); err != nil {
+			return args, fmt.Errorf("ignorepings: %q is not true or false", value)
+		}
+	}
+	return args, validateWebSocket(args)
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection, or responds with 426 when the request is not an upgrade.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.Reader, bool) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != WEBSOCKET_VERSION || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", WEBSOCKET_VERSION)
+		http.Error(w, "a WebSocket upgrade is required", http.StatusUpgradeRequired)
+		return nil, nil, false
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "the connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	conn.SetDeadline(time.Time{})
+	hash := sha1.Sum([]byte(key + WEBSOCKET_GUID))
+	fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(hash[:]))
+	return conn, buffer.Reader, true
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header[name] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketSession sends the messages of an upgraded connection with the
+// chaos of its args, answering the pings and close of the client as it goes.
+type WebSocketSession struct {
+	args    WebSocketArgs
+	conn    net.Conn
+	reader  *bufio.Reader
+	random  Random
+	mutex   sync.Mutex
+	sent    int
+	ended   bool
+	closing bool
+}
+
+// Echo sends back each message until the connection ends.
+func (instance *WebSocketSession) Echo() {
+	defer instance.conn.Close()
+	instance.endAfter()
+	for {
+		opcode, payload, err := instance.read()
+		if err != nil || instance.control(opcode, payload) {
+			return
+		}
+		if opcode == websocketText || opcode == websocketBinary {
+			instance.send(opcode, payload)
+		}
+	}
+}
+
+// Script sends the messages and then closes the connection normally, unless
+// it has already ended.
+func (instance *WebSocketSession) Script(messages []string) {
+	defer instance.conn.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			opcode, payload, err := instance.read()
+			if err != nil || instance.control(opcode, payload) {
+				return
+			}
+		}
+	}()
+	instance.endAfter()
+	for _, message := range messages {
+		instance.send(websocketText, []byte(message))
+	}
+	code := instance.args.CloseCode
+	if code == 0 {
+		code = WEBSOCKET_CLOSE_NORMAL
+	}
+	instance.close(code)
+	select {
+	case <-done:
+	case <-time.After(WEBSOCKET_CLOSE_TIMEOUT):
+	}
+}
+
+// send writes a message unless it is dropped or the connection has ended,
+// and then ends the connection once enough messages have been sent.
+func (instance *WebSocketSession) send(opcode byte, payload []byte) {
+	if instance.isEnded() {
+		return
+	}
+	time.Sleep(parseTime(instance.args.Interval))
+	if instance.args.Drop == 0 || instance.random.Float64() >= instance.args.Drop {
+		instance.write(websocketFinal|opcode, payload)
+	}
+	instance.mutex.Lock()
+	instance.sent++
+	instance.mutex.Unlock()
+	instance.endAfter()
+}
+
+func (instance *WebSocketSession) endAfter() {
+	instance.mutex.Lock()
+	due := !instance.ended && instance.args.End != "" && instance.args.End != WEBSOCKET_END_NONE && instance.sent >= instance.args.After
+	if due {
+		instance.ended = true
+	}
+	instance.mutex.Unlock()
+	if !due {
+		return
+	}
+	switch instance.args.End {
+	case WEBSOCKET_END_CLOSE:
+		code := instance.args.CloseCode
+		if code == 0 {
+			code = WEBSOCKET_CLOSE_NORMAL
+		}
+		instance.close(code)
+	case WEBSOCKET_END_DISCONNECT:
+		instance.conn.Close()
+	case WEBSOCKET_END_MALFORMED:
+		instance.write(websocketMalformed, nil)
+		instance.conn.SetReadDeadline(time.Now().Add(WEBSOCKET_CLOSE_TIMEOUT))
+	}
+}
+
+func (instance *WebSocketSession) isEnded() bool {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.ended
+}
+
+// close starts the closing handshake, giving the client a while to reply.
+func (instance *WebSocketSession) close(code int) {
+	instance.mutex.Lock()
+	if instance.closing {
+		instance.mutex.Unlock()
+		return
+	}
+	instance.closing = true
+	instance.ended = true
+	instance.mutex.Unlock()
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	instance.write(websocketFinal|websocketClose, payload)
+	instance.conn.SetReadDeadline(time.Now().Add(WEBSOCKET_CLOSE_TIMEOUT))
+}
+
+// control answers a control frame, returning true once the client has
+// closed the connection.
+func (instance *WebSocketSession) control(opcode byte, payload []byte) bool {
+	switch opcode {
+	case websocketPing:
+		if !instance.args.IgnorePings {
+			instance.write(websocketFinal|websocketPong, payload)
+		}
+	case websocketClose:
+		code := WEBSOCKET_CLOSE_NORMAL
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+		}
+		instance.close(code)
+		return true
+	}
+	return false
+}
+
+func (instance *WebSocketSession) write(first byte, payload []byte) error {
+	header := []byte{first}
+	length := len(payload)
+	switch {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, byte(length>>8), byte(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	_, err := instance.conn.Write(append(header, payload...))
+	return err
+}
+
+// read returns the next control frame or whole message from the client.  A
+// frame which is not masked is a protocol error which closes the connection.
+func (instance *WebSocketSession) read() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(instance.reader, header); err != nil {
+			return 0, nil, err
+		}
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			extended := make([]byte, 2)
+			if _, err := io.ReadFull(instance.reader, extended); err != nil {
+				return 0, nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(extended))
+		case 127:
+			extended := make([]byte, 8)
+			if _, err := io.ReadFull(instance.reader, extended); err != nil {
+				return 0, nil, err
+			}
+			length = binary.BigEndian.Uint64(extended)
+		}
+		if length > WEBSOCKET_MAX_PAYLOAD {
+			return 0, nil, fmt.Errorf("the frame of %d bytes is too large", length)
+		}
+		if header[1]&0x80 == 0 {
+			instance.close(WEBSOCKET_CLOSE_ERROR)
+			return 0, nil, fmt.Errorf("the frame is not masked")
+		}
+		mask := make([]byte, 4)
+		if _, err := io.ReadFull(instance.reader, mask); err != nil {
+			return 0, nil, err
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(instance.reader, payload); err != nil {
+			return 0, nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		frameOpcode := header[0] & 0x0F
+		if frameOpcode >= websocketClose {
+			return frameOpcode, payload, nil
+		}
+		if frameOpcode != websocketContinuation {
+			opcode = frameOpcode
+		}
+		message = append(message, payload...)
+		if header[0]&websocketFinal != 0 {
+			return opcode, message, nil
+		}
+	}
+}
+
+func NewWebSocketSession(args WebSocketArgs, conn net.Conn, reader *bufio.Reader, random Random) *WebSocketSession {
+	return &WebSocketSession{
+		args:   args,
+		conn:   conn,
+		reader: reader,
+		random: random,
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// testWebSocket is just enough of a WebSocket client to check the framing of
+// the /ws endpoints.
+type testWebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// write sends a single frame, masked unless told otherwise.
+func (instance *testWebSocket) write(opcode byte, payload string, masked bool) {
+	frame := []byte{websocketFinal | opcode, byte(len(payload))}
+	data := []byte(payload)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame[1] |= 0x80
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	instance.conn.Write(append(frame, data...))
+}
+
+// read returns the next frame sent by the server.
+func (instance *testWebSocket) read() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(instance.reader, header); err != nil {
+		return 0, nil, err
+	}
+	if header[0]&0x70 != 0 {
+		return 0, nil, fmt.Errorf("the frame sets the reserved bits")
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(instance.reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(instance.reader, payload)
+	return header[0] & 0x0F, payload, err
+}
+
+// readAll returns the messages until the server closes the connection,
+// along with the close code, which is 0 when there is no close frame.
+func (instance *testWebSocket) readAll() ([]string, int, error) {
+	messages := []string{}
+	for {
+		opcode, payload, err := instance.read()
+		if err != nil {
+			return messages, 0, err
+		}
+		switch opcode {
+		case websocketText, websocketBinary:
+			messages = append(messages, string(payload))
+		case websocketClose:
+			return messages, int(binary.BigEndian.Uint16(payload)), nil
+		}
+	}
+}
+
+var _ = Describe("WebSocket", func() {
+
+	dial := func(path string) *testWebSocket {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", PORT))
+		Expect(err).To(BeNil())
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", path)
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, nil)
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		Expect(response.Header.Get("Sec-WebSocket-Accept")).To(Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo="))
+		return &testWebSocket{conn: conn, reader: reader}
+	}
+
+	It("sends back each message from /ws/echo", func() {
+		conn := dial("/ws/echo")
+		defer conn.conn.Close()
+		conn.write(websocketText, "hello", true)
+		opcode, message, err := conn.read()
+		Expect(err).To(BeNil())
+		Expect(opcode).To(Equal(websocketText))
+		Expect(string(message)).To(Equal("hello"))
+	})
+
+	It("sends the scripted messages and then closes with the close code", func() {
+		conn := dial("/ws/script?message=one&message=two&closecode=1011")
+		defer conn.conn.Close()
+		messages, code, err := conn.readAll()
+		Expect(err).To(BeNil())
+		Expect(messages).To(Equal([]string{"one", "two"}))
+		Expect(code).To(Equal(1011))
+	})
+
+	It("waits the interval before each message", func() {
+		conn := dial("/ws/script?message=one&interval=200ms")
+		defer conn.conn.Close()
+		start := time.Now()
+		_, _, err := conn.read()
+		Expect(err).To(BeNil())
+		Expect(time.Since(start) >= 200*time.Millisecond).To(BeTrue())
+	})
+
+	It("drops messages", func() {
+		conn := dial("/ws/script?message=one&message=two&drop=1")
+		defer conn.conn.Close()
+		messages, code, _ := conn.readAll()
+		Expect(messages).To(BeEmpty())
+		Expect(code).To(Equal(WEBSOCKET_CLOSE_NORMAL))
+	})
+
+	It("disconnects after the messages", func() {
+		conn := dial("/ws/echo?after=1&end=disconnect")
+		defer conn.conn.Close()
+		conn.write(websocketText, "one", true)
+		conn.write(websocketText, "two", true)
+		messages, code, err := conn.readAll()
+		Expect(messages).To(Equal([]string{"one"}))
+		Expect(err).ToNot(BeNil())
+		Expect(code).To(Equal(0))
+	})
+
+	It("closes after the messages", func() {
+		conn := dial("/ws/echo?after=1&end=close&closecode=4000")
+		defer conn.conn.Close()
+		conn.write(websocketText, "one", true)
+		messages, code, _ := conn.readAll()
+		Expect(messages).To(Equal([]string{"one"}))
+		Expect(code).To(Equal(4000))
+	})
+
+	It("sends a malformed frame", func() {
+		conn := dial("/ws/script?message=one&after=1&end=malformed")
+		defer conn.conn.Close()
+		messages, code, err := conn.readAll()
+		Expect(messages).To(Equal([]string{"one"}))
+		Expect(err).To(MatchError("the frame sets the reserved bits"))
+		Expect(code).To(Equal(0))
+	})
+
+	It("closes the connection with a protocol error when a frame is not masked", func() {
+		conn := dial("/ws/echo")
+		defer conn.conn.Close()
+		conn.write(websocketText, "hello", false)
+		messages, code, err := conn.readAll()
+		Expect(err).To(BeNil())
+		Expect(messages).To(BeEmpty())
+		Expect(code).To(Equal(WEBSOCKET_CLOSE_ERROR))
+	})
+
+	It("answers pings unless told to ignore them", func() {
+		for _, ignore := range []bool{false, true} {
+			conn := dial(fmt.Sprintf("/ws/echo?ignorepings=%v", ignore))
+			conn.write(websocketPing, "ping", true)
+			conn.write(websocketText, "hello", true)
+			opcodes := []byte{}
+			for len(opcodes) == 0 || opcodes[len(opcodes)-1] != websocketText {
+				opcode, _, err := conn.read()
+				Expect(err).To(BeNil())
+				opcodes = append(opcodes, opcode)
+			}
+			if ignore {
+				Expect(opcodes).To(Equal([]byte{websocketText}))
+			} else {
+				Expect(opcodes).To(Equal([]byte{websocketPong, websocketText}))
+			}
+			conn.conn.Close()
+		}
+	})
+
+	It("requires an upgrade", func() {
+		response, err := http.Get(fmt.Sprintf("http://localhost:%d/ws/echo", PORT))
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusUpgradeRequired))
+	})
+
+	It("rejects invalid chaos", func() {
+		Expect(validateWebSocket(WebSocketArgs{End: "broken"})).ToNot(BeNil())
+		Expect(validateWebSocket(WebSocketArgs{Drop: 2})).ToNot(BeNil())
+		Expect(validateWebSocket(WebSocketArgs{CloseCode: 999})).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,370 @@
+// Package client controls a running enanos through its admin API, so that
+// Go tests can install routes, move scenarios on and assert on the requests
+// enanos received, e.g.
+//
+//	enanos := client.New("http://localhost:8002")
+//	err := enanos.Route("/orders").Methods("POST").FailWith(503).Times(3).Install()
+//	requests, err := enanos.WaitForRequests(ctx, client.RequestFilter{Path: "/orders"}, 4)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CONFIG_PATH    string = "/__admin/config"
+	REQUESTS_PATH  string = "/__admin/requests"
+	SCENARIOS_PATH string = "/__admin/scenarios"
+
+	// SCENARIO_PREFIX starts the names of the scenarios installed by Times.
+	SCENARIO_PREFIX string = "client:"
+
+	// POLL_INTERVAL is how often WaitForRequests asks for the requests.
+	POLL_INTERVAL time.Duration = 50 * time.Millisecond
+)
+
+// Error is a response from the admin API which was not a success.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (instance *Error) Error() string {
+	return fmt.Sprintf("enanos: %d %s", instance.Code, instance.Message)
+}
+
+// Client talks to the admin API at a URL such as http://localhost:8002.
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+	mutex      sync.Mutex
+	original   map[string]json.RawMessage
+	changed    map[string]bool
+}
+
+// routes are the routes and scenarios of the configuration, kept as JSON so
+// that fields this package does not know about survive.
+type routes struct {
+	Routes    []json.RawMessage `json:"routes"`
+	Scenarios []json.RawMessage `json:"scenarios"`
+}
+
+// Config returns the current configuration, with the same fields as the
+// configuration file.
+func (instance *Client) Config() (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	return config, instance.do("GET", CONFIG_PATH, nil, &config)
+}
+
+// Patch changes only the fields of the configuration which are given, e.g.
+// map[string]interface{}{"maxwait": "2s"}.
+func (instance *Client) Patch(fields interface{}) error {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	return instance.patch(fields)
+}
+
+// Chaos replaces the weighted outcomes of the /chaos endpoint.
+func (instance *Client) Chaos(outcomes ...Outcome) error {
+	return instance.Patch(map[string]interface{}{"chaos": outcomes})
+}
+
+// Route starts a route for the path pattern, which is installed by Install.
+func (instance *Client) Route(path string) *RouteBuilder {
+	return &RouteBuilder{client: instance, route: Route{Path: path}}
+}
+
+// Reset puts back every field of the configuration this client changed, such
+// as the routes, scenarios and chaos outcomes, moves the scenarios there were
+// back to their first state and forgets the recorded requests.
+func (instance *Client) Reset() error {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if instance.original != nil {
+		restored := map[string]json.RawMessage{}
+		for name := range instance.changed {
+			restored[name] = instance.original[name]
+			if restored[name] == nil {
+				//A field the configuration left out goes back to unset
+				restored[name] = json.RawMessage("null")
+			}
+		}
+		if err := instance.do("PATCH", CONFIG_PATH, restored, nil); err != nil {
+			return err
+		}
+		scenarios := []Scenario{}
+		json.Unmarshal(instance.original["scenarios"], &scenarios)
+		for _, scenario := range scenarios {
+			if err := instance.ResetScenario(scenario.Name); err != nil {
+				return err
+			}
+		}
+		instance.original = nil
+		instance.changed = nil
+	}
+	return instance.ResetRequests()
+}
+
+// Requests returns the recorded requests which match the filter, oldest first.
+func (instance *Client) Requests(filter RequestFilter) ([]Request, error) {
+	requests := []Request{}
+	return requests, instance.do("GET", REQUESTS_PATH+"?"+filter.Query().Encode(), nil, &requests)
+}
+
+// ResetRequests forgets the recorded requests.
+func (instance *Client) ResetRequests() error {
+	return instance.do("DELETE", REQUESTS_PATH, nil, nil)
+}
+
+// WaitForRequests waits until at least count recorded requests match the
+// filter and returns them, or returns an error once the context is done.
+func (instance *Client) WaitForRequests(ctx context.Context, filter RequestFilter, count int) ([]Request, error) {
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		requests, err := instance.Requests(filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(requests) >= count {
+			return requests, nil
+		}
+		select {
+		case <-ctx.Done():
+			return requests, fmt.Errorf("enanos: received %d of %d requests: %v", len(requests), count, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scenarios returns the state of every scenario.
+func (instance *Client) Scenarios() ([]ScenarioStatus, error) {
+	statuses := []ScenarioStatus{}
+	return statuses, instance.do("GET", SCENARIOS_PATH, nil, &statuses)
+}
+
+// Scenario returns the state of the scenario.
+func (instance *Client) Scenario(name string) (ScenarioStatus, error) {
+	status := ScenarioStatus{}
+	return status, instance.do("GET", scenarioPath(name), nil, &status)
+}
+
+// MoveScenario moves the scenario to the state.
+func (instance *Client) MoveScenario(name string, state string) error {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if err := instance.snapshot(); err != nil {
+		return err
+	}
+	return instance.do("PUT", scenarioPath(name), ScenarioStatus{State: state}, nil)
+}
+
+// ResetScenario moves the scenario back to its first state.
+func (instance *Client) ResetScenario(name string) error {
+	return instance.do("DELETE", scenarioPath(name), nil, nil)
+}
+
+// install adds the route ahead of the rest, replacing any route with the same
+// path, methods, priority and match, along with the scenario.
+func (instance *Client) install(route Route, scenario *Scenario) error {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	current := routes{}
+	if err := instance.do("GET", CONFIG_PATH, nil, &current); err != nil {
+		return err
+	}
+	updated := routes{Routes: []json.RawMessage{}, Scenarios: []json.RawMessage{}}
+	removed := map[string]bool{}
+	data, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	updated.Routes = append(updated.Routes, data)
+	for _, data := range current.Routes {
+		installed := Route{}
+		json.Unmarshal(data, &installed)
+		if installed.key() != route.key() {
+			updated.Routes = append(updated.Routes, data)
+			continue
+		}
+		for _, behaviour := range installed.Behaviours {
+			if strings.HasPrefix(behaviour.Scenario, SCENARIO_PREFIX) {
+				removed[behaviour.Scenario] = true
+			}
+		}
+	}
+	if scenario != nil {
+		removed[scenario.Name] = true
+	}
+	for _, data := range current.Scenarios {
+		installed := Scenario{}
+		json.Unmarshal(data, &installed)
+		if !removed[installed.Name] {
+			updated.Scenarios = append(updated.Scenarios, data)
+		}
+	}
+	if scenario != nil {
+		data, err := json.Marshal(scenario)
+		if err != nil {
+			return err
+		}
+		updated.Scenarios = append(updated.Scenarios, data)
+	}
+	if err := instance.patch(updated); err != nil {
+		return err
+	}
+	if scenario != nil {
+		//The scenario keeps its state when it has not changed
+		return instance.ResetScenario(scenario.Name)
+	}
+	return nil
+}
+
+// snapshot keeps the configuration as it is before this client changes
+// anything.
+func (instance *Client) snapshot() error {
+	if instance.original != nil {
+		return nil
+	}
+	original := map[string]json.RawMessage{}
+	if err := instance.do("GET", CONFIG_PATH, nil, &original); err != nil {
+		return err
+	}
+	instance.original = original
+	instance.changed = map[string]bool{}
+	return nil
+}
+
+// patch changes the fields, first keeping the configuration as it was before
+// this client changed anything so that Reset can put it back.
+func (instance *Client) patch(fields interface{}) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	changed := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &changed); err != nil {
+		return err
+	}
+	if err := instance.snapshot(); err != nil {
+		return err
+	}
+	if err := instance.do("PATCH", CONFIG_PATH, changed, nil); err != nil {
+		return err
+	}
+	for name := range changed {
+		instance.changed[name] = true
+	}
+	return nil
+}
+
+func (instance *Client) do(method string, path string, body interface{}, result interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(instance.URL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	response, err := instance.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &Error{Code: response.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+func scenarioPath(name string) string {
+	return SCENARIOS_PATH + "/" + url.PathEscape(name)
+}
+
+// New creates a Client for the admin API at the URL.
+func New(adminURL string) *Client {
+	return &Client{
+		URL:        adminURL,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Request is a request recorded by enanos and how it responded.
+type Request struct {
+	ID       string      `json:"id"`
+	Time     time.Time   `json:"time"`
+	Remote   string      `json:"remote"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Query    string      `json:"query"`
+	Proto    string      `json:"proto"`
+	Headers  http.Header `json:"headers"`
+	Body     string      `json:"body"`
+	Endpoint string      `json:"endpoint"`
+	Fault    string      `json:"fault"`
+	Code     int         `json:"code"`
+	Bytes    int         `json:"bytes"`
+	Duration string      `json:"duration"`
+	Sleep    string      `json:"sleep"`
+	Pending  bool        `json:"pending"`
+}
+
+// RequestFilter selects recorded requests.  Empty fields match everything, a
+// Path ending in * matches any path with that prefix and a Header is Key or
+// Key:Value.
+type RequestFilter struct {
+	Method string
+	Path   string
+	Fault  string
+	Code   int
+	Header string
+	Since  time.Time
+}
+
+func (instance RequestFilter) Query() url.Values {
+	query := url.Values{}
+	for name, value := range map[string]string{"method": instance.Method, "path": instance.Path, "fault": instance.Fault, "header": instance.Header} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if instance.Code != 0 {
+		query.Set("code", strconv.Itoa(instance.Code))
+	}
+	if !instance.Since.IsZero() {
+		query.Set("since", instance.Since.Format(time.RFC3339Nano))
+	}
+	return query
+}
+
+// ScenarioStatus is the state a scenario is in.
+type ScenarioStatus struct {
+	Name     string    `json:"name,omitempty"`
+	State    string    `json:"state"`
+	Requests int       `json:"requests,omitempty"`
+	Since    time.Time `json:"since,omitempty"`
+}
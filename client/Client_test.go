@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeAdmin is an admin API which keeps the configuration, the recorded
+// requests and the calls made to the scenarios in memory.
+type fakeAdmin struct {
+	mutex    sync.Mutex
+	config   map[string]json.RawMessage
+	requests []Request
+	queries  []url.Values
+	calls    []string
+	failure  int
+}
+
+func (instance *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	if instance.failure != 0 {
+		http.Error(w, "behaviour specifies nothing", instance.failure)
+		return
+	}
+	switch {
+	case r.URL.Path == CONFIG_PATH && r.Method == "PATCH":
+		fields := map[string]json.RawMessage{}
+		json.NewDecoder(r.Body).Decode(&fields)
+		for name, value := range fields {
+			instance.config[name] = value
+		}
+		json.NewEncoder(w).Encode(instance.config)
+	case r.URL.Path == CONFIG_PATH:
+		json.NewEncoder(w).Encode(instance.config)
+	case r.URL.Path == REQUESTS_PATH && r.Method == "DELETE":
+		instance.requests = nil
+		instance.calls = append(instance.calls, "DELETE requests")
+	case r.URL.Path == REQUESTS_PATH:
+		instance.queries = append(instance.queries, r.URL.Query())
+		json.NewEncoder(w).Encode(append([]Request{}, instance.requests...))
+	case strings.HasPrefix(r.URL.Path, SCENARIOS_PATH+"/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), SCENARIOS_PATH+"/"))
+		instance.calls = append(instance.calls, r.Method+" "+name)
+		json.NewEncoder(w).Encode(ScenarioStatus{Name: name, State: STATE_FAILING})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// routes returns the routes of the configuration.
+func (instance *fakeAdmin) routes() []Route {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	routes := []Route{}
+	json.Unmarshal(instance.config["routes"], &routes)
+	return routes
+}
+
+var _ = Describe("Client", func() {
+
+	var admin *fakeAdmin
+	var server *httptest.Server
+	var enanos *Client
+
+	BeforeEach(func() {
+		admin = &fakeAdmin{config: map[string]json.RawMessage{
+			"maxwait":   json.RawMessage(`"2s"`),
+			"routes":    json.RawMessage(`[{"path":"/health","behaviours":[{"status":204}],"future":true}]`),
+			"scenarios": json.RawMessage(`[]`),
+		}}
+		server = httptest.NewServer(admin)
+		enanos = New(server.URL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("installs a route which fails a number of times ahead of the routes there were", func() {
+		Expect(enanos.Route("/orders").Methods("POST").FailWith(503).Times(3).Install()).To(BeNil())
+		routes := admin.routes()
+		Expect(routes).To(HaveLen(2))
+		Expect(routes[0]).To(Equal(Route{Path: "/orders", Methods: []string{"POST"}, Behaviours: []Behaviour{{Scenario: "client:POST /orders"}}}))
+		Expect(routes[1].Path).To(Equal("/health"))
+		scenarios := []Scenario{}
+		json.Unmarshal(admin.config["scenarios"], &scenarios)
+		Expect(scenarios).To(Equal([]Scenario{{
+			Name: "client:POST /orders",
+			States: []ScenarioState{
+				{Name: STATE_FAILING, Requests: 3, Behaviours: []Behaviour{{Status: 503}}},
+				{Name: STATE_PASSED, Behaviours: []Behaviour{{Fault: "success"}}},
+			},
+		}}))
+		Expect(admin.calls).To(Equal([]string{"DELETE client:POST /orders"}))
+		Expect(string(admin.config["routes"])).To(ContainSubstring(`"future":true`))
+	})
+
+	It("replaces a route with the same path, methods, priority and match", func() {
+		Expect(enanos.Route("/orders").FailWith(503).Times(1).Install()).To(BeNil())
+		Expect(enanos.Route("/orders").Status(500).Install()).To(BeNil())
+		Expect(admin.routes()).To(HaveLen(2))
+		Expect(admin.routes()[0].Behaviours).To(Equal([]Behaviour{{Status: 500}}))
+		Expect(string(admin.config["scenarios"])).To(Equal("[]"))
+		Expect(enanos.Route("/orders").WhenHeader("X-Tenant", "acme").Status(418).Install()).To(BeNil())
+		Expect(enanos.Route("/orders").WhenHeader("X-Tenant", "other").Status(418).Install()).To(BeNil())
+		Expect(enanos.Route("/orders").Priority(5).Status(429).Install()).To(BeNil())
+		Expect(admin.routes()).To(HaveLen(5))
+		Expect(enanos.Route("/orders").WhenHeader("X-Tenant", "acme").Status(401).Install()).To(BeNil())
+		routes := admin.routes()
+		Expect(routes).To(HaveLen(5))
+		Expect(routes[0].Behaviours).To(Equal([]Behaviour{{Status: 401}}))
+	})
+
+	It("sends every field of a behaviour", func() {
+		failures := 0
+		Expect(enanos.Route("/orders").Behaviour(Behaviour{Retry: &Retry{Failures: &failures, Key: "ip"}}).Behaviour(Behaviour{
+			Stream: &Stream{Format: "sse", Events: 3},
+		}).Behaviour(Behaviour{
+			RateLimit: &RateLimit{Limit: 10, Window: "1s"},
+		}).Behaviour(Behaviour{
+			Throttle: &Throttle{Rate: "1KB"},
+		}).Install()).To(BeNil())
+		Expect(string(admin.config["routes"])).To(ContainSubstring(`"behaviours":[{"retry":{"failures":0,"key":"ip"}},{"stream":{"format":"sse","events":3}},{"ratelimit":{"limit":10,"window":"1s"}},{"throttle":{"rate":"1KB"}}]`))
+		Expect(*admin.routes()[0].Behaviours[0].Retry.Failures).To(Equal(0))
+	})
+
+	It("waits for and filters the recorded requests", func() {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			admin.mutex.Lock()
+			admin.requests = []Request{{Method: "GET", Path: "/health"}, {Method: "DELETE", Path: "/health"}}
+			admin.mutex.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		requests, err := enanos.WaitForRequests(ctx, RequestFilter{Path: "/health", Method: "DELETE", Code: 204}, 2)
+		Expect(err).To(BeNil())
+		Expect(requests).To(HaveLen(2))
+		Expect(admin.queries[0]).To(Equal(url.Values{"path": {"/health"}, "method": {"DELETE"}, "code": {"204"}}))
+	})
+
+	It("gives up waiting once the context is done", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := enanos.WaitForRequests(ctx, RequestFilter{Path: "/orders"}, 1)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("resets the routes it installed and the recorded requests", func() {
+		Expect(enanos.Route("/health").Status(500).Install()).To(BeNil())
+		Expect(enanos.Route("/orders").Status(500).Install()).To(BeNil())
+		Expect(admin.routes()).To(HaveLen(2))
+		Expect(enanos.Reset()).To(BeNil())
+		Expect(string(admin.config["routes"])).To(Equal(`[{"path":"/health","behaviours":[{"status":204}],"future":true}]`))
+		Expect(admin.calls).To(Equal([]string{"DELETE requests"}))
+	})
+
+	It("puts back every field it changed", func() {
+		admin.config["scenarios"] = json.RawMessage(`[{"name":"checkout","states":[{"name":"up","behaviours":[{"status":200}]}]}]`)
+		Expect(enanos.Chaos(Outcome{Weight: 1, Behaviour: Behaviour{Fault: "hang"}})).To(BeNil())
+		Expect(enanos.Patch(map[string]interface{}{"maxwait": "5s"})).To(BeNil())
+		Expect(enanos.MoveScenario("checkout", "down")).To(BeNil())
+		Expect(string(admin.config["maxwait"])).To(Equal(`"5s"`))
+		Expect(enanos.Reset()).To(BeNil())
+		Expect(string(admin.config["maxwait"])).To(Equal(`"2s"`))
+		Expect(string(admin.config["chaos"])).To(Equal("null"))
+		Expect(admin.calls).To(Equal([]string{"PUT checkout", "DELETE checkout", "DELETE requests"}))
+	})
+
+	It("returns the errors of the admin API", func() {
+		admin.failure = http.StatusBadRequest
+		err := enanos.Route("/orders").Fault("nothing").Install()
+		Expect(err).To(Equal(&Error{Code: http.StatusBadRequest, Message: "behaviour specifies nothing"}))
+		Expect(enanos.MoveScenario("missing", STATE_FAILING)).ToNot(BeNil())
+		Expect(enanos.Route("/orders").Install()).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,299 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// The states of the scenarios installed by Times.
+	STATE_FAILING string = "failing"
+	STATE_PASSED  string = "passed"
+)
+
+// Route is a route as it is written in the configuration file.
+type Route struct {
+	Path       string      `json:"path"`
+	Methods    []string    `json:"methods,omitempty"`
+	Behaviours []Behaviour `json:"behaviours"`
+	Mix        []Outcome   `json:"mix,omitempty"`
+	Priority   int         `json:"priority,omitempty"`
+	Match      *Match      `json:"match,omitempty"`
+}
+
+// key identifies the route by what decides the requests it matches: its
+// path, methods, priority and match.
+func (instance Route) key() string {
+	key := strings.ToUpper(strings.Join(instance.Methods, ",")) + " " + instance.Path
+	if instance.Priority != 0 {
+		key += fmt.Sprintf(" priority %d", instance.Priority)
+	}
+	if instance.Match != nil {
+		match, _ := json.Marshal(instance.Match)
+		key += " " + string(match)
+	}
+	return key
+}
+
+// Behaviour is a behaviour as it is written in the configuration file.
+type Behaviour struct {
+	Status    int        `json:"status,omitempty"`
+	Delay     string     `json:"delay,omitempty"`
+	Size      string     `json:"size,omitempty"`
+	Headers   []string   `json:"headers,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	Fault     string     `json:"fault,omitempty"`
+	Proxy     bool       `json:"proxy,omitempty"`
+	Truncate  string     `json:"truncate,omitempty"`
+	Throttle  *Throttle  `json:"throttle,omitempty"`
+	Scenario  string     `json:"scenario,omitempty"`
+	Retry     *Retry     `json:"retry,omitempty"`
+	RateLimit *RateLimit `json:"ratelimit,omitempty"`
+	Stream    *Stream    `json:"stream,omitempty"`
+	Corrupt   string     `json:"corrupt,omitempty"`
+	Replay    bool       `json:"replay,omitempty"`
+	Template  bool       `json:"template,omitempty"`
+}
+
+// Throttle slows the response body down.
+type Throttle struct {
+	Rate   string `json:"rate,omitempty"`
+	Chunk  string `json:"chunk,omitempty"`
+	Delay  string `json:"delay,omitempty"`
+	Jitter string `json:"jitter,omitempty"`
+}
+
+// Retry fails the first attempts of each client.  Failures is a pointer so
+// that 0, which lets every attempt through, can be told apart from unset.
+type Retry struct {
+	Failures *int   `json:"failures,omitempty"`
+	Key      string `json:"key,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+	Failure  string `json:"failure,omitempty"`
+}
+
+// RateLimit rejects the requests over the limit in each window.
+type RateLimit struct {
+	Algorithm string `json:"algorithm,omitempty"`
+	Limit     int    `json:"limit"`
+	Window    string `json:"window,omitempty"`
+	Burst     int    `json:"burst,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Status    int    `json:"status,omitempty"`
+}
+
+// Stream responds with a stream of events.
+type Stream struct {
+	Format    string  `json:"format,omitempty"`
+	Interval  string  `json:"interval,omitempty"`
+	Events    int     `json:"events,omitempty"`
+	Duplicate float64 `json:"duplicate,omitempty"`
+	After     int     `json:"after,omitempty"`
+	End       string  `json:"end,omitempty"`
+	Retry     string  `json:"retry,omitempty"`
+}
+
+// Outcome is a behaviour picked by weight from a mix.
+type Outcome struct {
+	Weight int `json:"weight"`
+	Behaviour
+}
+
+// Match is the conditions a request has to meet, on top of the path and
+// methods, for a route to match it.
+type Match struct {
+	PathRegex string               `json:"pathregex,omitempty"`
+	Query     map[string]Condition `json:"query,omitempty"`
+	Headers   map[string]Condition `json:"headers,omitempty"`
+	Cookies   map[string]Condition `json:"cookies,omitempty"`
+	Body      map[string]Condition `json:"body,omitempty"`
+	Clients   []string             `json:"clients,omitempty"`
+}
+
+// Condition is a condition on a value of the request.
+type Condition struct {
+	Equals   string `json:"equals,omitempty"`
+	Contains string `json:"contains,omitempty"`
+	Matches  string `json:"matches,omitempty"`
+	Present  bool   `json:"present,omitempty"`
+	Absent   bool   `json:"absent,omitempty"`
+}
+
+// condition has the fields of Condition without its unmarshalling
+type condition Condition
+
+// UnmarshalJSON reads the plain string enanos writes for a condition which
+// only has equals.
+func (instance *Condition) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &instance.Equals); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, (*condition)(instance))
+}
+
+// Scenario is a scenario as it is written in the configuration file.
+type Scenario struct {
+	Name   string          `json:"name"`
+	Loop   bool            `json:"loop,omitempty"`
+	States []ScenarioState `json:"states"`
+}
+
+type ScenarioState struct {
+	Name       string      `json:"name"`
+	Requests   int         `json:"requests,omitempty"`
+	Duration   string      `json:"duration,omitempty"`
+	Next       string      `json:"next,omitempty"`
+	Behaviours []Behaviour `json:"behaviours"`
+	Mix        []Outcome   `json:"mix,omitempty"`
+}
+
+// RouteBuilder builds a route, e.g.
+// client.Route("/orders").Methods("POST").FailWith(503).Times(3).Install().
+type RouteBuilder struct {
+	client *Client
+	route  Route
+	times  int
+}
+
+// Methods limits the route to the methods.
+func (instance *RouteBuilder) Methods(methods ...string) *RouteBuilder {
+	instance.route.Methods = append(instance.route.Methods, methods...)
+	return instance
+}
+
+// Priority orders the route ahead of the routes with a lower priority.
+func (instance *RouteBuilder) Priority(priority int) *RouteBuilder {
+	instance.route.Priority = priority
+	return instance
+}
+
+// WhenHeader only matches requests with the header equal to the value.
+func (instance *RouteBuilder) WhenHeader(name string, value string) *RouteBuilder {
+	instance.match().Headers = withCondition(instance.match().Headers, name, value)
+	return instance
+}
+
+// WhenQuery only matches requests with the query parameter equal to the value.
+func (instance *RouteBuilder) WhenQuery(name string, value string) *RouteBuilder {
+	instance.match().Query = withCondition(instance.match().Query, name, value)
+	return instance
+}
+
+// WhenCookie only matches requests with the cookie equal to the value.
+func (instance *RouteBuilder) WhenCookie(name string, value string) *RouteBuilder {
+	instance.match().Cookies = withCondition(instance.match().Cookies, name, value)
+	return instance
+}
+
+// WhenBody only matches requests whose JSON body has the field at the dot
+// separated path equal to the value.
+func (instance *RouteBuilder) WhenBody(path string, value string) *RouteBuilder {
+	instance.match().Body = withCondition(instance.match().Body, path, value)
+	return instance
+}
+
+// Delay waits for the duration before responding.
+func (instance *RouteBuilder) Delay(delay time.Duration) *RouteBuilder {
+	return instance.Behaviour(Behaviour{Delay: delay.String()})
+}
+
+// Status responds with the status code.
+func (instance *RouteBuilder) Status(code int) *RouteBuilder {
+	return instance.Behaviour(Behaviour{Status: code})
+}
+
+// FailWith responds with the status code, which reads better than Status for
+// codes which are failures.
+func (instance *RouteBuilder) FailWith(code int) *RouteBuilder {
+	return instance.Status(code)
+}
+
+// Fault responds with the named fault, e.g. connection_reset or hang.
+func (instance *RouteBuilder) Fault(fault string) *RouteBuilder {
+	return instance.Behaviour(Behaviour{Fault: fault})
+}
+
+// Content responds with the content.
+func (instance *RouteBuilder) Content(content string) *RouteBuilder {
+	return instance.Behaviour(Behaviour{Content: content})
+}
+
+// ContentTemplate responds with the content rendered as a template.
+func (instance *RouteBuilder) ContentTemplate(content string) *RouteBuilder {
+	return instance.Behaviour(Behaviour{Content: content, Template: true})
+}
+
+// Header adds the header to the response.
+func (instance *RouteBuilder) Header(name string, value string) *RouteBuilder {
+	return instance.Behaviour(Behaviour{Headers: []string{name + ":" + value}})
+}
+
+// HeaderTemplate adds the header, with the value rendered as a template, to
+// the response.
+func (instance *RouteBuilder) HeaderTemplate(name string, value string) *RouteBuilder {
+	return instance.Behaviour(Behaviour{Headers: []string{name + ":" + value}, Template: true})
+}
+
+// Behaviour adds a behaviour the builder has no method for.
+func (instance *RouteBuilder) Behaviour(behaviour Behaviour) *RouteBuilder {
+	instance.route.Behaviours = append(instance.route.Behaviours, behaviour)
+	return instance
+}
+
+// Mix picks one of the outcomes by weight for each request.
+func (instance *RouteBuilder) Mix(outcomes ...Outcome) *RouteBuilder {
+	instance.route.Mix = append(instance.route.Mix, outcomes...)
+	return instance
+}
+
+// Times applies the behaviours to the first count requests only and responds
+// with success after them.
+func (instance *RouteBuilder) Times(count int) *RouteBuilder {
+	instance.times = count
+	return instance
+}
+
+// Route returns the route and the scenario, if any, which Install installs.
+func (instance *RouteBuilder) Route() (Route, *Scenario) {
+	route := instance.route
+	if instance.times <= 0 {
+		return route, nil
+	}
+	scenario := &Scenario{
+		Name: SCENARIO_PREFIX + strings.TrimSpace(route.key()),
+		States: []ScenarioState{
+			{Name: STATE_FAILING, Requests: instance.times, Behaviours: route.Behaviours, Mix: route.Mix},
+			{Name: STATE_PASSED, Behaviours: []Behaviour{{Fault: "success"}}},
+		},
+	}
+	route.Behaviours = []Behaviour{{Scenario: scenario.Name}}
+	route.Mix = nil
+	return route, scenario
+}
+
+// Install adds the route ahead of the configured routes, replacing any route
+// with the same path, methods, priority and match.
+func (instance *RouteBuilder) Install() error {
+	route, scenario := instance.Route()
+	if len(route.Behaviours) == 0 && len(route.Mix) == 0 {
+		return fmt.Errorf("enanos: the route %s has no behaviours", route.Path)
+	}
+	return instance.client.install(route, scenario)
+}
+
+func (instance *RouteBuilder) match() *Match {
+	if instance.route.Match == nil {
+		instance.route.Match = &Match{}
+	}
+	return instance.route.Match
+}
+
+func withCondition(conditions map[string]Condition, name string, value string) map[string]Condition {
+	if conditions == nil {
+		conditions = map[string]Condition{}
+	}
+	conditions[name] = Condition{Equals: value}
+	return conditions
+}
@@ -5,8 +5,6 @@ import (
 	"net/http"
 	"sync"
 	"time"
-
-	"github.com/reaandrew/goSimpleHttp"
 )
 
 const (
@@ -24,24 +22,46 @@ type Server interface {
 	Stop()
 }
 
+// endpoints maps the paths of the built in endpoints to their handlers.
+func endpoints(handlerFactory HttpHandler) map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/success":           handlerFactory.Success,
+		"/server_error":      handlerFactory.Server_Error,
+		"/content_size":      handlerFactory.Content_Size,
+		"/wait":              handlerFactory.Wait,
+		"/redirect":          handlerFactory.Redirect,
+		"/client_error":      handlerFactory.Client_Error,
+		"/defined":           handlerFactory.Defined,
+		"/chaos":             handlerFactory.Chaos,
+		"/connection_reset":  handlerFactory.Connection_Reset,
+		"/partial_headers":   handlerFactory.Partial_Headers,
+		"/partial_body":      handlerFactory.Partial_Body,
+		"/hang":              handlerFactory.Hang,
+		"/no_content_length": handlerFactory.No_Content_Length,
+		"/slow_body":         handlerFactory.Slow_Body,
+		"/fail_first":        handlerFactory.Fail_First,
+		"/ws/echo":           handlerFactory.WebSocket_Echo,
+		"/ws/script":         handlerFactory.WebSocket_Script,
+		"/stream":            handlerFactory.Stream,
+	}
+}
+
 type JitterServer struct {
-	Config                Configuration
-	ResponseBodyGenerator ResponseBodyGenerator
-	ResponseCodeGenerator ResponseCodeGenerator
-	Snoozer               Snoozer
-	Server                *goSimpleHttp.SimpleHttpServer
+	Config    Configuration
+	Profile   *LiveProfile
+	Observers []RequestObserver
+	Metrics   *Metrics
+	Server    *HTTPServer
 }
 
 func (instance *JitterServer) Start() {
 	config := instance.Config
-	instance.Server = goSimpleHttp.NewSimpleHttpServer(config.port+1, config.host)
+	instance.Server = NewHTTPServer(config.port+1, config.host)
+	instance.Server.HTTP2 = NewHTTP2Server(instance.Profile)
 	if config.jitterTime == time.Duration(0) {
 		return
 	}
-	var handlerFactory HttpHandler = NewDefultHttpHandler(instance.ResponseBodyGenerator, instance.ResponseCodeGenerator, instance.Snoozer, instance.Config)
-	if config.verbose {
-		handlerFactory = &VerboseHttpHandler{handlerFactory}
-	}
+	var handlerFactory HttpHandler = NewDefultHttpHandler(instance.Profile)
 	ticker := time.NewTicker(config.jitterTime)
 	stopped := false
 	go func() {
@@ -51,82 +71,65 @@ func (instance *JitterServer) Start() {
 				if stopped {
 					fmt.Println("Starting server")
 					instance.Server.Start()
+					instance.Metrics.SetGauge(METRIC_JITTER_UP, 1)
 					stopped = false
 				} else {
 					fmt.Println("Stopping server")
 					instance.Server.Stop()
+					instance.Metrics.SetGauge(METRIC_JITTER_UP, 0)
 					stopped = true
 				}
 			}
 		}
 	}()
-	urlToHandlers := map[string]goSimpleHttp.HttpHandler{
-		"/success":      handlerFactory.Success,
-		"/server_error": handlerFactory.Server_Error,
-		"/content_size": handlerFactory.Content_Size,
-		"/wait":         handlerFactory.Wait,
-		"/redirect":     handlerFactory.Redirect,
-		"/client_error": handlerFactory.Client_Error,
-		"/defined":      handlerFactory.Defined,
-	}
+	urlToHandlers := endpoints(handlerFactory)
 
+	router := NewRouter(instance.Profile, instance.Observers...)
 	for key, value := range urlToHandlers {
-		instance.Server.Get(key, value)
-		instance.Server.Post(key, value)
-		instance.Server.Put(key, value)
-		instance.Server.Delete(key, value)
+		router.HandleFunc(key, value)
 	}
+	instance.Server.Handle("/", router)
 
 	instance.Server.Start()
+	instance.Metrics.SetGauge(METRIC_JITTER_UP, 1)
 }
 func (instance *JitterServer) Stop() {
 	instance.Server.Stop()
 }
 
 type HarnessServer struct {
-	Config                Configuration
-	ResponseBodyGenerator ResponseBodyGenerator
-	ResponseCodeGenerator ResponseCodeGenerator
-	Snoozer               Snoozer
-	Server                *goSimpleHttp.SimpleHttpServer
+	Config    Configuration
+	Profile   *LiveProfile
+	Observers []RequestObserver
+	Metrics   *Metrics
+	Server    *HTTPServer
 }
 
 func (instance *HarnessServer) Start() {
 	config := instance.Config
-	var shouldStop bool = true
-	var handlerFactory HttpHandler = NewDefultHttpHandler(instance.ResponseBodyGenerator, instance.ResponseCodeGenerator, instance.Snoozer, instance.Config)
-	if config.verbose {
-		handlerFactory = &VerboseHttpHandler{handlerFactory}
-	}
-	instance.Server = goSimpleHttp.NewSimpleHttpServer(config.port, config.host)
-	instance.Server.OnStopped(func() {
-		time.Sleep(config.deadTime)
-		instance.Server.Start()
-		shouldStop = true
-	})
-
-	urlToHandlers := map[string]goSimpleHttp.HttpHandler{
-		"/success":      handlerFactory.Success,
-		"/server_error": handlerFactory.Server_Error,
-		"/content_size": handlerFactory.Content_Size,
-		"/wait":         handlerFactory.Wait,
-		"/redirect":     handlerFactory.Redirect,
-		"/client_error": handlerFactory.Client_Error,
-		"/defined":      handlerFactory.Defined,
-		"/dead_or_alive": func(w http.ResponseWriter, t *http.Request) {
-			shouldStop = false
-			instance.Server.Stop()
-		},
+	var handlerFactory HttpHandler = NewDefultHttpHandler(instance.Profile)
+	instance.Server = NewHTTPServer(config.port, config.host)
+	instance.Server.HTTP2 = NewHTTP2Server(instance.Profile)
+
+	urlToHandlers := endpoints(handlerFactory)
+	urlToHandlers["/dead_or_alive"] = func(w http.ResponseWriter, t *http.Request) {
+		instance.Server.Stop()
+		instance.Metrics.SetGauge(METRIC_ALIVE, 0)
+		go func() {
+			time.Sleep(instance.Profile.Current().Config.deadTime)
+			instance.Server.Start()
+			instance.Metrics.SetGauge(METRIC_ALIVE, 1)
+		}()
 	}
 
+	router := NewRouter(instance.Profile, instance.Observers...)
 	for key, value := range urlToHandlers {
-		instance.Server.Get(key, value)
-		instance.Server.Post(key, value)
-		instance.Server.Put(key, value)
-		instance.Server.Delete(key, value)
+		router.HandleFunc(key, value)
 	}
+	instance.Server.Handle("/", router)
 
 	instance.Server.Start()
+	instance.Metrics.SetGauge(METRIC_ALIVE, 1)
 }
 
 func (instance *HarnessServer) Stop() {
@@ -135,6 +138,7 @@ func (instance *HarnessServer) Stop() {
 
 type EnanosServer struct {
 	Servers    []Server
+	Profile    *LiveProfile
 	WaitHandle sync.WaitGroup
 }
 
@@ -149,6 +153,11 @@ func (instance *EnanosServer) Stop() {
 	for _, server := range instance.Servers {
 		server.Stop()
 	}
+	if instance.Profile != nil {
+		if err := instance.Profile.Current().Config.fixtures.Flush(); err != nil {
+			fmt.Println(fmt.Sprintf("Cannot save the fixtures: %v", err))
+		}
+	}
 	instance.WaitHandle.Done()
 }
 
@@ -160,25 +169,81 @@ type ServerFactory struct {
 	WaitHandle            sync.WaitGroup
 }
 
-func (instance *ServerFactory) CreateServer() Server {
-	jitterServer := &JitterServer{
+func (instance *ServerFactory) CreateServer() (Server, error) {
+	profile := NewLiveProfile(Profile{
 		Config:                instance.Config,
 		ResponseBodyGenerator: instance.ResponseBodyGenerator,
 		ResponseCodeGenerator: instance.ResponseCodeGenerator,
 		Snoozer:               instance.Snoozer,
+		Chaos:                 createChaos(instance.Config),
+	})
+
+	recorder := NewRequestRecorder(instance.Config.history)
+	metrics := NewMetrics()
+	observers := []RequestObserver{recorder, metrics}
+	accessLogArgs := instance.Config.accessLog
+	if accessLogArgs.Path == "" && instance.Config.verbose {
+		accessLogArgs.Path = ACCESS_LOG_STDOUT
+	}
+	accessLog, err := NewAccessLog(accessLogArgs)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open the access log: %v", err)
+	}
+	if accessLog != nil {
+		observers = append(observers, accessLog)
+	}
+
+	jitterServer := &JitterServer{
+		Config:    instance.Config,
+		Profile:   profile,
+		Observers: observers,
+		Metrics:   metrics,
 	}
 
 	harnessServer := &HarnessServer{
-		Config:                instance.Config,
-		ResponseBodyGenerator: instance.ResponseBodyGenerator,
-		ResponseCodeGenerator: instance.ResponseCodeGenerator,
-		Snoozer:               instance.Snoozer,
+		Config:    instance.Config,
+		Profile:   profile,
+		Observers: observers,
+		Metrics:   metrics,
 	}
 
 	servers := []Server{jitterServer, harnessServer}
 
+	var authority *CertificateAuthority
+	if instance.Config.tls.Port != 0 {
+		authority, err = NewCertificateAuthority()
+		if err != nil {
+			return nil, fmt.Errorf("Cannot create the TLS certificate authority: %v", err)
+		}
+		servers = append(servers, &TLSServer{
+			Config:    instance.Config,
+			Profile:   profile,
+			Observers: observers,
+			Authority: authority,
+		})
+	}
+
+	if instance.Config.grpc.Port != 0 {
+		servers = append(servers, &GRPCServer{
+			Config:    instance.Config,
+			Profile:   profile,
+			Observers: observers,
+		})
+	}
+
+	adminServer := &AdminServer{
+		Config:    instance.Config,
+		Profile:   profile,
+		Recorder:  recorder,
+		Metrics:   metrics,
+		Authority: authority,
+	}
+
+	servers = append(servers, adminServer)
+
 	return &EnanosServer{
 		Servers:    servers,
+		Profile:    profile,
 		WaitHandle: instance.WaitHandle,
-	}
+	}, nil
 }
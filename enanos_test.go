@@ -55,7 +55,7 @@ func TestMain(m *testing.M) {
             ResponseCodeGenerator: responseCodeGenerator,
             Snoozer : snoozer,
         }
-        server := serverFactory.CreateServer()
+        server, _ := serverFactory.CreateServer()
         server.Start()
 	}()
 	os.Exit(m.Run())
@@ -193,6 +193,44 @@ var _ = Describe("Enanos Server:", func() {
 		}
 	})
 
+	Describe("Connection faults :", func() {
+		It("connection_reset resets the connection", func() {
+			_, err := SendHelloWorldByHttpMethod("GET", url("/connection_reset"))
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("partial_headers closes the connection part way through the headers", func() {
+			_, err := SendHelloWorldByHttpMethod("GET", url("/partial_headers"))
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("partial_body closes the connection part way through the body", func() {
+			resp, err := SendHelloWorldByHttpMethod("GET", url("/partial_body"))
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+			Expect(resp.ContentLength).To(Equal(int64(len(testContent))))
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(BeNil())
+			Expect(string(body)).To(Equal(testContent[:len(testContent)/2]))
+		})
+
+		It("hang never responds", func() {
+			client := &http.Client{Timeout: 50 * time.Millisecond}
+			_, err := client.Get(url("/hang"))
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("no_content_length sends the body but never finishes it", func() {
+			client := &http.Client{Timeout: 50 * time.Millisecond}
+			resp, err := client.Get(url("/no_content_length"))
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+			Expect(resp.ContentLength).To(Equal(int64(-1)))
+			_, err = ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
 	Describe("Doc :", func() {
 		It("GET kills the web server and returns after a set time period", func() {})
 	})
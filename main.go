@@ -2,42 +2,124 @@ package main
 
 import (
 	"fmt"
-	"github.com/dustin/go-humanize"
 	"gopkg.in/alecthomas/kingpin.v1"
 	"os"
+	"os/signal"
 	"sync"
-	"time"
+	"syscall"
 )
 
 const (
-	ENV_ENANOS_PORT         string = "ENANOS_PORT"
-	ENV_ENANOS_VERBOSE      string = "ENANOS_VERBOSE"
-	ENV_ENANOS_HOST         string = "ENANOS_HOST"
-	ENV_ENANOS_MIN_SLEEP    string = "ENANOS_MIN_SLEEP"
-	ENV_ENANOS_MAX_SLEEP    string = "ENANOS_MAX_SLEEP"
-	ENV_ENANOS_RANDOM_SLEEP string = "ENANOS_RANDOM_SLEEP"
-	ENV_ENANOS_MIN_SIZE     string = "ENANOS_MIN_SIZE"
-	ENV_ENANOS_MAX_SIZE     string = "ENANOS_MAX_SIZE"
-	ENV_ENANOS_RANDOM_SIZE  string = "ENANOS_RANDOM_SIZE"
-	ENV_ENANOS_DEAD_TIME    string = "ENANOS_DEAD_TIME"
-	ENV_ENANOS_JITTER_TIME  string = "ENANOS_JITTER_TIME"
+	ENV_ENANOS_PORT            string = "ENANOS_PORT"
+	ENV_ENANOS_VERBOSE         string = "ENANOS_VERBOSE"
+	ENV_ENANOS_HOST            string = "ENANOS_HOST"
+	ENV_ENANOS_MIN_SLEEP       string = "ENANOS_MIN_SLEEP"
+	ENV_ENANOS_MAX_SLEEP       string = "ENANOS_MAX_SLEEP"
+	ENV_ENANOS_RANDOM_SLEEP    string = "ENANOS_RANDOM_SLEEP"
+	ENV_ENANOS_MIN_SIZE        string = "ENANOS_MIN_SIZE"
+	ENV_ENANOS_MAX_SIZE        string = "ENANOS_MAX_SIZE"
+	ENV_ENANOS_RANDOM_SIZE     string = "ENANOS_RANDOM_SIZE"
+	ENV_ENANOS_DEAD_TIME       string = "ENANOS_DEAD_TIME"
+	ENV_ENANOS_JITTER_TIME     string = "ENANOS_JITTER_TIME"
+	ENV_ENANOS_ADMIN_PORT      string = "ENANOS_ADMIN_PORT"
+	ENV_ENANOS_UPSTREAM        string = "ENANOS_UPSTREAM"
+	ENV_ENANOS_PROXY_TIMEOUT   string = "ENANOS_PROXY_TIMEOUT"
+	ENV_ENANOS_THROTTLE_RATE   string = "ENANOS_THROTTLE_RATE"
+	ENV_ENANOS_THROTTLE_CHUNK  string = "ENANOS_THROTTLE_CHUNK"
+	ENV_ENANOS_THROTTLE_DELAY  string = "ENANOS_THROTTLE_DELAY"
+	ENV_ENANOS_THROTTLE_JITTER string = "ENANOS_THROTTLE_JITTER"
+	ENV_ENANOS_LATENCY         string = "ENANOS_LATENCY"
+	ENV_ENANOS_LATENCY_MEAN    string = "ENANOS_LATENCY_MEAN"
+	ENV_ENANOS_LATENCY_STDDEV  string = "ENANOS_LATENCY_STDDEV"
+	ENV_ENANOS_LATENCY_SCALE   string = "ENANOS_LATENCY_SCALE"
+	ENV_ENANOS_LATENCY_SHAPE   string = "ENANOS_LATENCY_SHAPE"
+	ENV_ENANOS_OVERRIDE_DELAY  string = "ENANOS_OVERRIDE_DELAY"
+	ENV_ENANOS_OVERRIDE_SIZE   string = "ENANOS_OVERRIDE_SIZE"
+	ENV_ENANOS_HISTORY         string = "ENANOS_HISTORY"
+	ENV_ENANOS_ACCESS_LOG      string = "ENANOS_ACCESS_LOG"
+	ENV_ENANOS_ACCESS_FORMAT   string = "ENANOS_ACCESS_LOG_FORMAT"
+	ENV_ENANOS_RETRY_FAILURES  string = "ENANOS_RETRY_FAILURES"
+	ENV_ENANOS_RETRY_KEY       string = "ENANOS_RETRY_KEY"
+	ENV_ENANOS_TLS_PORT        string = "ENANOS_TLS_PORT"
+	ENV_ENANOS_TLS_FAULT       string = "ENANOS_TLS_FAULT"
+	ENV_ENANOS_HTTP2_FAULT     string = "ENANOS_HTTP2_FAULT"
+	ENV_ENANOS_GRPC_PORT       string = "ENANOS_GRPC_PORT"
 )
 
 var (
-	verbose     = kingpin.Flag("verbose", "Enable verbose mode.").Short('v').OverrideDefaultFromEnvar(ENV_ENANOS_VERBOSE).Bool()
-	port        = kingpin.Flag("port", "the port to host the server on").Default("8000").Short('p').OverrideDefaultFromEnvar(ENV_ENANOS_PORT).Int()
-	host        = kingpin.Flag("host", "this host for enanos to bind to").Default("0.0.0.0").OverrideDefaultFromEnvar(ENV_ENANOS_HOST).String()
-	minSleep    = kingpin.Flag("min-sleep", "the minimum sleep time for the wait endpoint e.g. 5ms, 5s, 5m etc...").Default("1s").OverrideDefaultFromEnvar(ENV_ENANOS_MIN_SLEEP).String()
-	maxSleep    = kingpin.Flag("max-sleep", "the maximum sleep time for the wait endpoint e.g. 5ms, 5s, 5m etc...").Default("60s").OverrideDefaultFromEnvar(ENV_ENANOS_MAX_SLEEP).String()
-	randomSleep = kingpin.Flag("random-sleep", "whether to sleep a random time between min and max or just the max").Default("false").OverrideDefaultFromEnvar(ENV_ENANOS_RANDOM_SLEEP).Bool()
-	minSize     = kingpin.Flag("min-size", "the minimum size of response body for the content_size endpoint e.g. 5B, 5KB, 5MB etc...").Default("10KB").OverrideDefaultFromEnvar(ENV_ENANOS_MIN_SIZE).String()
-	maxSize     = kingpin.Flag("max-size", "the maximum size of response body for the content_size endpoint e.g. 5B, 5KB, 5MB etc...").Default("100KB").OverrideDefaultFromEnvar(ENV_ENANOS_MAX_SIZE).String()
-	randomSize  = kingpin.Flag("random-size", "whether to return a random sized payload between min and max or just max").Default("false").OverrideDefaultFromEnvar(ENV_ENANOS_RANDOM_SIZE).Bool()
-	deadTime    = kingpin.Flag("dead-time", "the time which the server should remain dead before coming back online").Default("5s").OverrideDefaultFromEnvar(ENV_ENANOS_DEAD_TIME).String()
-	content     = kingpin.Flag("content", "the content to return for OK responses").Default("hello world").String()
-	headers     = kingpin.Flag("header", "response headers to be returned. Key:Value").Short('H').Strings()
-	jitterTime  = kingpin.Flag("jitter-time", "the interval at which the server should goup and down").Short('j').Default("0s").OverrideDefaultFromEnvar(ENV_ENANOS_JITTER_TIME).String()
-	config      = kingpin.Flag("config", "config file used to configure enanos.  Supported providers include file.").Default("empty").Short('c').String()
+	verbose            = kingpin.Flag("verbose", "Enable verbose mode.").Short('v').OverrideDefaultFromEnvar(ENV_ENANOS_VERBOSE).Bool()
+	port               = kingpin.Flag("port", "the port to host the server on").Default("8000").Short('p').OverrideDefaultFromEnvar(ENV_ENANOS_PORT).Int()
+	host               = kingpin.Flag("host", "this host for enanos to bind to").Default("0.0.0.0").OverrideDefaultFromEnvar(ENV_ENANOS_HOST).String()
+	minSleep           = kingpin.Flag("min-sleep", "the minimum sleep time for the wait endpoint, by default 1s or, for the normal, lognormal, exponential, pareto and percentiles distributions, 0 e.g. 5ms, 5s, 5m etc...").OverrideDefaultFromEnvar(ENV_ENANOS_MIN_SLEEP).String()
+	maxSleep           = kingpin.Flag("max-sleep", "the maximum sleep time for the wait endpoint e.g. 5ms, 5s, 5m etc...").Default("60s").OverrideDefaultFromEnvar(ENV_ENANOS_MAX_SLEEP).String()
+	randomSleep        = kingpin.Flag("random-sleep", "whether to sleep a random time between min and max or just the max").Default("false").OverrideDefaultFromEnvar(ENV_ENANOS_RANDOM_SLEEP).Bool()
+	minSize            = kingpin.Flag("min-size", "the minimum size of response body for the content_size endpoint e.g. 5B, 5KB, 5MB etc...").Default("10KB").OverrideDefaultFromEnvar(ENV_ENANOS_MIN_SIZE).String()
+	maxSize            = kingpin.Flag("max-size", "the maximum size of response body for the content_size endpoint e.g. 5B, 5KB, 5MB etc...").Default("100KB").OverrideDefaultFromEnvar(ENV_ENANOS_MAX_SIZE).String()
+	randomSize         = kingpin.Flag("random-size", "whether to return a random sized payload between min and max or just max").Default("false").OverrideDefaultFromEnvar(ENV_ENANOS_RANDOM_SIZE).Bool()
+	deadTime           = kingpin.Flag("dead-time", "the time which the server should remain dead before coming back online").Default("5s").OverrideDefaultFromEnvar(ENV_ENANOS_DEAD_TIME).String()
+	content            = kingpin.Flag("content", "the content to return for OK responses").Default("hello world").String()
+	headers            = kingpin.Flag("header", "response headers to be returned. Key:Value").Short('H').Strings()
+	templates          = kingpin.Flag("templates", "whether the content and response headers are Go templates, see Templates").Bool()
+	jitterTime         = kingpin.Flag("jitter-time", "the interval at which the server should goup and down").Short('j').Default("0s").OverrideDefaultFromEnvar(ENV_ENANOS_JITTER_TIME).String()
+	adminPort          = kingpin.Flag("admin-port", "the port to host the admin API on, 0 disables it").Default("8002").OverrideDefaultFromEnvar(ENV_ENANOS_ADMIN_PORT).Int()
+	upstream           = kingpin.Flag("upstream", "the URL of a real service to forward requests to which do not match a route or endpoint e.g. http://localhost:9000").OverrideDefaultFromEnvar(ENV_ENANOS_UPSTREAM).String()
+	proxyTimeout       = kingpin.Flag("proxy-timeout", "how long to wait for the upstream to send its whole response before responding with a 502 e.g. 5s, 30s").Default("30s").OverrideDefaultFromEnvar(ENV_ENANOS_PROXY_TIMEOUT).String()
+	throttleRate       = kingpin.Flag("throttle-rate", "the maximum bytes per second for the slow_body endpoint e.g. 5B, 5KB, 5MB etc...").Default("1KB").OverrideDefaultFromEnvar(ENV_ENANOS_THROTTLE_RATE).String()
+	throttleChunk      = kingpin.Flag("throttle-chunk", "the size of each chunk written by the slow_body endpoint e.g. 5B, 5KB, 5MB etc...").Default("128B").OverrideDefaultFromEnvar(ENV_ENANOS_THROTTLE_CHUNK).String()
+	throttleDelay      = kingpin.Flag("throttle-delay", "an additional delay between each chunk written by the slow_body endpoint e.g. 5ms, 5s, 5m etc...").Default("0s").OverrideDefaultFromEnvar(ENV_ENANOS_THROTTLE_DELAY).String()
+	throttleJitter     = kingpin.Flag("throttle-jitter", "the maximum random delay added between each chunk written by the slow_body endpoint e.g. 5ms, 5s, 5m etc...").Default("0s").OverrideDefaultFromEnvar(ENV_ENANOS_THROTTLE_JITTER).String()
+	latency            = kingpin.Flag("latency", "the distribution of sleep times for the wait endpoint, one of uniform, max, normal, lognormal, exponential, pareto or percentiles").OverrideDefaultFromEnvar(ENV_ENANOS_LATENCY).String()
+	latencyMean        = kingpin.Flag("latency-mean", "the mean sleep time for the normal, lognormal and exponential distributions e.g. 5ms, 5s, 5m etc...").OverrideDefaultFromEnvar(ENV_ENANOS_LATENCY_MEAN).String()
+	latencyStdDev      = kingpin.Flag("latency-stddev", "the standard deviation of the sleep time for the normal and lognormal distributions e.g. 5ms, 5s, 5m etc...").OverrideDefaultFromEnvar(ENV_ENANOS_LATENCY_STDDEV).String()
+	latencyScale       = kingpin.Flag("latency-scale", "the minimum sleep time for the pareto distribution e.g. 5ms, 5s, 5m etc...").OverrideDefaultFromEnvar(ENV_ENANOS_LATENCY_SCALE).String()
+	latencyShape       = kingpin.Flag("latency-shape", "the shape of the pareto distribution, smaller values give a longer tail e.g. 1.5").Default("0").OverrideDefaultFromEnvar(ENV_ENANOS_LATENCY_SHAPE).Float()
+	latencyPercentiles = kingpin.Flag("latency-percentile", "a sleep time for a percentile of the percentiles distribution. p50:100ms").Strings()
+	overrideDelay      = kingpin.Flag("max-override-delay", "the maximum delay a request can ask for with the delay override e.g. 5ms, 5s, 5m etc...").Default("60s").OverrideDefaultFromEnvar(ENV_ENANOS_OVERRIDE_DELAY).String()
+	overrideSize       = kingpin.Flag("max-override-size", "the maximum body size a request can ask for with the size override e.g. 5B, 5KB, 5MB etc...").Default("10MB").OverrideDefaultFromEnvar(ENV_ENANOS_OVERRIDE_SIZE).String()
+	history            = kingpin.Flag("history", "the number of recent requests to keep for the admin API, 0 disables recording").Default("1000").OverrideDefaultFromEnvar(ENV_ENANOS_HISTORY).Int()
+	accessLog          = kingpin.Flag("access-log", "the file to write a line to for each request, or stdout").OverrideDefaultFromEnvar(ENV_ENANOS_ACCESS_LOG).String()
+	accessLogFormat    = kingpin.Flag("access-log-format", "the format of the access log, one of text, json, common or combined").Default("text").OverrideDefaultFromEnvar(ENV_ENANOS_ACCESS_FORMAT).String()
+	accessLogMaxSize   = kingpin.Flag("access-log-max-size", "the size at which the access log file is rotated, 0 never rotates it e.g. 5KB, 5MB etc...").Default("100MB").String()
+	accessLogBackups   = kingpin.Flag("access-log-backups", "the number of rotated access log files to keep").Default("5").Int()
+	retryFailures      = kingpin.Flag("retry-failures", "the number of attempts the fail_first endpoint fails for each client before it succeeds").Default("3").OverrideDefaultFromEnvar(ENV_ENANOS_RETRY_FAILURES).Int()
+	retryKey           = kingpin.Flag("retry-key", "how the fail_first endpoint tells clients apart, one of ip, header:<name> or query:<name>").Default("ip").OverrideDefaultFromEnvar(ENV_ENANOS_RETRY_KEY).String()
+	retryTTL           = kingpin.Flag("retry-ttl", "how long the fail_first endpoint remembers a client's attempts after the last one e.g. 5ms, 5s, 5m etc...").Default("1m").String()
+	retryFailure       = kingpin.Flag("retry-failure", "the endpoint the fail_first endpoint responds like until it succeeds e.g. server_error, client_error, connection_reset").Default("server_error").String()
+	tlsPort            = kingpin.Flag("tls-port", "the port to host the HTTPS listener on, 0 disables it").Default("0").OverrideDefaultFromEnvar(ENV_ENANOS_TLS_PORT).Int()
+	tlsCert            = kingpin.Flag("tls-cert", "the PEM certificate for the HTTPS listener, by default one is issued by a generated CA").String()
+	tlsKey             = kingpin.Flag("tls-key", "the PEM key for the HTTPS listener certificate").String()
+	tlsClientCA        = kingpin.Flag("tls-client-ca", "the PEM CA certificates which client certificates are verified against, by default the generated CA").String()
+	tlsClientAuth      = kingpin.Flag("tls-client-auth", "whether the HTTPS listener asks for a client certificate, one of none, request or require").Default("none").String()
+	tlsFault           = kingpin.Flag("tls-fault", "the fault injected into every TLS handshake, one of none, expired, wrong_hostname, untrusted, unsupported_version, handshake_stall or handshake_close").Default("none").OverrideDefaultFromEnvar(ENV_ENANOS_TLS_FAULT).String()
+	http2Fault         = kingpin.Flag("http2-fault", "the fault injected into every HTTP/2 connection, one of none, rst_stream, goaway, tiny_window or settings_delay").Default("none").OverrideDefaultFromEnvar(ENV_ENANOS_HTTP2_FAULT).String()
+	http2ErrorCode     = kingpin.Flag("http2-error-code", "the error code sent by the rst_stream and goaway faults e.g. REFUSED_STREAM, ENHANCE_YOUR_CALM or 0x7").String()
+	http2Window        = kingpin.Flag("http2-window", "the stream flow-control window in bytes advertised by the tiny_window fault").Default("16").Int()
+	http2Delay         = kingpin.Flag("http2-settings-delay", "how long the settings_delay fault holds back each SETTINGS frame e.g. 5ms, 5s, 5m etc...").Default("1s").String()
+	grpcPort           = kingpin.Flag("grpc-port", "the port to host the gRPC listener on, 0 disables it").Default("0").OverrideDefaultFromEnvar(ENV_ENANOS_GRPC_PORT).Int()
+	grpcDescriptors    = kingpin.Flag("grpc-descriptor", "a descriptor set file whose services are also served over gRPC, can be repeated").Strings()
+	grpcFault          = kingpin.Flag("grpc-fault", "the fault of the methods of the descriptor set services, one of success, server_error, wait, defined, content_size or interrupt").Default("success").String()
+	grpcCode           = kingpin.Flag("grpc-code", "the gRPC status code returned by the defined fault e.g. UNAVAILABLE or 14").String()
+	grpcMessages       = kingpin.Flag("grpc-messages", "the number of messages sent by the interrupt fault before the stream is reset").Default("1").Int()
+	wsMessages         = kingpin.Flag("ws-message", "a message sent by the /ws/script endpoint, can be repeated, by default the content").Strings()
+	wsInterval         = kingpin.Flag("ws-interval", "the delay before each WebSocket message is sent e.g. 5ms, 5s, 5m etc...").Default("0s").String()
+	wsDrop             = kingpin.Flag("ws-drop", "the share of WebSocket messages which are never sent, between 0 and 1").Default("0").Float()
+	wsAfter            = kingpin.Flag("ws-after", "the number of WebSocket messages sent before <wsEnd>").Default("0").Int()
+	wsEnd              = kingpin.Flag("ws-end", "how a WebSocket connection ends after <wsAfter> messages, one of none, close, disconnect or malformed").Default("none").String()
+	wsCloseCode        = kingpin.Flag("ws-close-code", "the close code the WebSocket endpoints close with").Default("1000").Int()
+	wsIgnorePings      = kingpin.Flag("ws-ignore-pings", "whether the WebSocket endpoints stop answering pings").Bool()
+	streamFormat       = kingpin.Flag("stream-format", "the format of the /stream endpoint, one of sse or ndjson").Default("sse").String()
+	streamInterval     = kingpin.Flag("stream-interval", "the delay before each event of the /stream endpoint e.g. 5ms, 5s, 5m etc...").Default("1s").String()
+	streamEvents       = kingpin.Flag("stream-events", "the number of events the /stream endpoint writes before finishing, 0 never finishes").Default("0").Int()
+	streamDuplicate    = kingpin.Flag("stream-duplicate", "the share of events which repeat the ID of the event before, between 0 and 1").Default("0").Float()
+	streamAfter        = kingpin.Flag("stream-after", "the number of events written before <streamEnd>").Default("0").Int()
+	streamEnd          = kingpin.Flag("stream-end", "how the /stream endpoint ends after <streamAfter> events, one of none, stall, truncate or disconnect").Default("none").String()
+	streamRetry        = kingpin.Flag("stream-retry", "the reconnection time sent to SSE clients e.g. 5ms, 5s, 5m etc...").String()
+	bodyFormat         = kingpin.Flag("body-format", "the format of the content_size body, one of text, json, xml, html, csv or protobuf, by default from the Content-Type header").String()
+	bodyCorrupt        = kingpin.Flag("body-corrupt", "how the content_size body is corrupted, one of none, truncated, wrong_encoding, mismatched_brackets, bom or invalid_utf8").Default("none").String()
+	fixtures           = kingpin.Flag("fixtures", "the file of fixtures which are recorded from the upstream or replayed in its place").String()
+	fixturesMode       = kingpin.Flag("fixtures-mode", "whether the fixtures are recorded or replayed, one of record or replay").String()
+	openAPI            = kingpin.Flag("openapi", "an OpenAPI 3 spec, in JSON or YAML, whose operations are served with example responses").String()
+	config             = kingpin.Flag("config", "config file used to configure enanos.  Supported providers include file.").Default("empty").Short('c').String()
 )
 
 func main() {
@@ -49,13 +131,136 @@ func main() {
 	
 	/success		- will return a 200 response code
 	/server_error		- will return a random 5XX response code 
-	/content_size		- will return a 200 response code but a response body with a size between <minSize> and <maxSize>.  The content returned will be random or a mangled version of the content which has been configured to return i.e. it cannot guarantee to meet any content-types configured in that it will be malformed.
-	/wait			- will return a 200 response code but only after a random sleep between <minSleep> and <maxSleep>
+	/content_size		- will return a 200 response code but a response body with a size between <minSize> and <maxSize>, which is valid JSON, XML, HTML, CSV or protobuf when the Content-Type header or <bodyFormat> asks for it and is corrupted by <bodyCorrupt>.
+	/wait			- will return a 200 response code but only after a random sleep between <minSleep> and <maxSleep>, or drawn from the configured latency distribution
 	/redirect		- will return a random 3XX response code.  If the response code is one which redirects then Bashful will return its own location to invite an infinite redirect loop
 	/client_error		- will return a random 4XX response code
 	/dead_or_alive	- will kill the server and only bring it back online after configured amount of time (ms) has passed
 
 	/defined?code=<code>	- will return the specified http status code
+	/connection_reset	- will close the connection with a TCP reset without responding
+	/partial_headers	- will close the connection part way through writing the response headers
+	/partial_body		- will declare the full Content-Length but close the connection after half of the body
+	/hang			- will accept the request but never respond
+	/no_content_length	- will respond without a Content-Length and then keep the connection open
+	/slow_body		- will return a 200 response code and a body like content_size, but written in chunks at no more than <throttleRate> bytes per second
+	/chaos			- will pick one of the configured weighted outcomes for each request, by default 90% success, 5% server_error and 5% wait
+	/fail_first		- will respond like <retryFailure> for the first <retryFailures> attempts from each client, told apart by <retryKey>, and then return a 200 response code.  A client's attempts are forgotten <retryTTL> after its last one.  The query parameters failures, key, ttl and failure override the configuration and the X-Enanos-Attempt response header counts the attempts
+	/ws/echo		- will upgrade to a WebSocket and send back each message, with the WebSocket chaos
+	/ws/script		- will upgrade to a WebSocket and send each <wsMessage>, with the WebSocket chaos, and then close it
+	/stream			- will write an SSE or NDJSON event every <streamInterval>, carrying on from the Last-Event-ID of a reconnecting client, with the stream chaos
+
+	Overrides
+	=========
+
+	Every endpoint accepts the query parameters delay, size, code, header, body and corrupt, e.g. /success?delay=250ms&size=2MB&code=503&header=X-Foo:bar&body=json&corrupt=bom, which add a delay before responding, replace the body with one of the given size, replace the response code, add a response header, rewrite the body in a format and corrupt it.  The same overrides can be sent as the X-Enanos-Delay, X-Enanos-Size, X-Enanos-Code, X-Enanos-Header, X-Enanos-Body and X-Enanos-Corrupt request headers, which are also accepted by routes and in proxy mode.  A delay or size above <maxOverrideDelay> or <maxOverrideSize> is rejected with a 400 response code.
+
+	HTTPS
+	=====
+
+	When <tlsPort> is set the endpoints and routes are also served over HTTPS, with a certificate issued by a CA generated when enanos starts unless <tlsCert> and <tlsKey> are given.  With <tlsClientAuth> request or require, client certificates are verified against <tlsClientCA> or the generated CA.  The <tlsFault> is injected into every handshake:
+
+	expired			- presents a certificate which expired yesterday
+	wrong_hostname		- presents a certificate for wrong.host.invalid
+	untrusted		- presents a certificate issued by a different CA
+	unsupported_version	- only accepts TLS 1.0
+	handshake_stall		- reads the ClientHello but never replies
+	handshake_close		- reads the ClientHello and then resets the connection
+
+	HTTP/2
+	======
+
+	Every listener also serves HTTP/2, negotiated over TLS or as h2c with prior knowledge.  The <http2Fault> is injected into every HTTP/2 connection:
+
+	rst_stream		- resets each stream with <http2ErrorCode>, by default INTERNAL_ERROR, in place of the response
+	goaway			- sends a GOAWAY with <http2ErrorCode>, by default NO_ERROR, after the response headers and closes the connection
+	tiny_window		- advertises flow-control windows of <http2Window> bytes for request bodies
+	settings_delay		- holds back each SETTINGS frame for <http2SettingsDelay>
+
+	WebSockets
+	==========
+
+	The /ws endpoints wait <wsInterval> before sending each message, drop a <wsDrop> share of them and answer pings unless <wsIgnorePings>.  After <wsAfter> messages the connection ends with <wsEnd>:
+
+	none			- the connection carries on
+	close			- closes with <wsCloseCode>
+	disconnect		- drops the connection without closing it
+	malformed		- sends a frame with the reserved bits and a reserved opcode
+
+	The query parameters message, interval, drop, after, end, closecode and ignorepings override the configuration, e.g. /ws/echo?after=3&end=close&closecode=1011.
+
+	Streams
+	=======
+
+	The /stream endpoint writes <streamEvents> events, or never finishes when it is 0, repeating the ID of the event before for a <streamDuplicate> share of them.  After <streamAfter> events the stream ends with <streamEnd>:
+
+	none			- the stream carries on
+	stall			- stops writing but keeps the connection open
+	truncate		- writes half of the next event and then drops the connection
+	disconnect		- drops the connection
+
+	The query parameters format, interval, events, duplicate, after, end and retry override the configuration, e.g. /stream?format=ndjson&interval=100ms&after=5&end=truncate.
+
+	gRPC
+	====
+
+	When <grpcPort> is set the generic enanos.Enanos service is served over h2c on it, along with the services of each <grpcDescriptor>, which have the <grpcFault>.  The reflection service describes them all.  The methods of enanos.Enanos are:
+
+	Success			- returns a message with the configured content
+	ServerError		- returns a random gRPC status code for the 5XX codes
+	Wait			- returns a message after the same sleep as /wait
+	Defined			- returns the status code sent as the x-enanos-grpc-code metadata, by default <grpcCode>
+	ContentSize		- returns a message with a body the same size as /content_size
+	Interrupt		- sends <grpcMessages> messages and then resets the stream
+
+	Templates
+	=========
+
+	With --templates the content and the response headers are Go templates, rendered for each request, e.g. --templates --content '{"id":"{{.Param "id"}}","correlation":"{{.Header "X-Correlation-Id"}}"}'.  The content and headers behaviours of routes are templates when they set template: true.  A template can use:
+
+	.Method, .Path, .Host	- the method, path and host of the request
+	.Param "name"		- a {name} segment of the route path
+	.Query "name"		- a query parameter
+	.Header "name"		- a request header
+	.Body			- the request body, up to its first 1MB
+	.JSON "a.b.0"		- a field of the JSON request body
+	now			- the time, e.g. {{now.Unix}} or {{now.Format "2006-01-02"}}
+	uuid			- a random UUID
+	counter "name"		- the next value of a counter starting at 1
+	randomInt 1 6		- a random number between two numbers
+	randomString 8		- a random string of letters and digits
+	randomChoice "a" "b"	- one of the arguments at random
+
+	Fixtures
+	========
+
+	With <fixturesMode> record each response fetched from the upstream, by proxy mode or a proxy behaviour, is saved to <fixtures>.  With <fixturesMode> replay the saved responses are served in place of the upstream for requests which do not match a route, and a route with a replay behaviour responds with the fixture and then applies the rest of its behaviours on top, e.g. behaviours: [{replay: true}, {truncate: 50%}].  A fixture is matched by the method, path and query of the request, or by the method and path when none has the same query.
+
+	OpenAPI
+	=======
+
+	When <openapi> is set each operation of the spec is served as a route which responds with the first documented success, its example, the first of its examples or a value generated from its schema.  Routes from the configuration file are matched first.  In the configuration file the response and the faults of each operation can be set by its operationId, as METHOD /path or as * for every operation:
+
+	openapi:
+	  spec: orders.yaml
+	  operations:
+	    - operation: getOrder
+	      behaviours: [{delay: 250ms}]
+	      mix: [{weight: 90, fault: none}, {weight: 10, fault: server_error}]
+	    - operation: POST /orders
+	      response: "409"
+
+	Admin API
+	=========
+
+	The admin API is hosted on <adminPort> and allows the behaviour to be changed without restarting enanos.
+
+	/__admin/config		- GET returns the current configuration as JSON, PUT replaces it and PATCH updates only the supplied fields.  The port, host, verbose, jittertime, adminport, history, accesslog, the tls port, cert, key and clientca and the grpc port and descriptors cannot be changed at runtime.
+	/metrics		- returns the number and duration of requests by endpoint, method, code and fault, and whether the server is alive and the jitter server is up, in the Prometheus text format.
+	/__admin/scenarios	- GET returns the state of each scenario.  /__admin/scenarios/<name> returns the state of one for GET, moves it to the state in {"state":"<state>"} for PUT and back to its first state for DELETE.
+	/__admin/tls/ca.pem	- returns the certificate of the generated CA.
+	/__admin/tls/client.pem	- returns a new client certificate and key issued by the generated CA.
+	/__admin/requests	- GET returns the last <history> requests as JSON, filtered by the query parameters method, path, fault, code, header and since, and DELETE forgets them.
 
 	Configuration File
 	==================
@@ -74,6 +279,56 @@ func main() {
 	maxsize: 1MB
 	randomsize: true
 	headers: ["Age:1","Content-type:text/plain"]
+	routes:
+	  - path: /api/v1/orders/{id}
+	    methods: [GET]
+	    behaviours:
+	      - delay: 250ms
+	      - status: 503
+	      - headers: ["Retry-After:5"]
+	      - content: unavailable
+
+	chaos:
+	  - weight: 90
+	    status: 200
+	  - weight: 5
+	    status: 503
+	  - weight: 5
+	    delay: 2s
+
+	Each route matches a path pattern, where {name} matches a single path segment and a trailing * matches the rest of the path, and optionally a list of methods.  The behaviours are applied in order and each one sets one of status, delay, size, headers, content or fault, where a fault is the name of one of the endpoints above, dead to close the connection or none to leave the response as it is.  A route can also have a mix of weighted outcomes, in the same form as chaos, which is applied after its behaviours.  Routes are matched before the endpoints above, those with a higher priority first and otherwise in the order they are declared.  A path segment containing * or ? other than a trailing * is a glob for a single segment.
+
+	A route can also match on the rest of the request, where each condition is a value to equal or one or more of equals, contains, matches and present, or absent on its own:
+
+	    - path: /payments
+	      methods: [POST]
+	      priority: 10
+	      match:
+	        pathregex: ^/payments$
+	        query: {dryrun: {absent: true}}
+	        headers: {X-Tenant: acme}
+	        cookies: {session: {present: true}}
+	        body: {customer.tier: gold, amount: {matches: "^[0-9]{4,}$"}}
+	        clients: [10.0.0.0/8]
+	      behaviours: [{status: 503}]
+
+	A behaviour can also be a retry, which fails the first attempts for each client like the fail_first endpoint, e.g. retry: {failures: 2, key: "header:X-Client-Id", failure: server_error}.
+
+	A behaviour can also be a ratelimit, which rejects requests from each client beyond a limit with a 429 or 503 and the Retry-After, X-RateLimit-* and RateLimit-* headers, e.g. ratelimit: {algorithm: token_bucket, limit: 10, window: 1s, burst: 20, key: ip}.  The algorithm is one of token_bucket, fixed_window or sliding_window.
+
+	A behaviour can also be the name of a scenario, which applies the behaviours of the state the scenario is in.  Each state moves on to the next after a number of requests or a duration, whichever comes first, or when the admin API moves it on:
+
+	scenarios:
+	  - name: outage
+	    states:
+	      - name: healthy
+	        requests: 100
+	        behaviours: [{fault: success}]
+	      - name: down
+	        duration: 30s
+	        behaviours: [{status: 503}]
+	      - name: recovered
+	        behaviours: [{fault: success}]
 
 	To use a configuration file the (config|c) command line arg should be supplied referencing a YAML file which exists	
 	`
@@ -83,6 +338,7 @@ func main() {
 	commandLineArgs.Content = *content
 	commandLineArgs.DeadTime = *deadTime
 	commandLineArgs.Headers = *headers
+	commandLineArgs.Templates = *templates
 	commandLineArgs.Host = *host
 	commandLineArgs.MaxSize = *maxSize
 	commandLineArgs.MaxWait = *maxSleep
@@ -93,56 +349,125 @@ func main() {
 	commandLineArgs.RandomWait = *randomSleep
 	commandLineArgs.Verbose = *verbose
 	commandLineArgs.JitterTime = *jitterTime
+	commandLineArgs.AdminPort = *adminPort
+	commandLineArgs.Upstream = *upstream
+	commandLineArgs.Proxy = ProxyArgs{Timeout: *proxyTimeout}
+	commandLineArgs.Throttle = ThrottleArgs{
+		Rate:   *throttleRate,
+		Chunk:  *throttleChunk,
+		Delay:  *throttleDelay,
+		Jitter: *throttleJitter,
+	}
+	commandLineArgs.Latency = LatencyArgs{
+		Distribution: *latency,
+		Mean:         *latencyMean,
+		StdDev:       *latencyStdDev,
+		Scale:        *latencyScale,
+		Shape:        *latencyShape,
+		Percentiles:  *latencyPercentiles,
+	}
+	commandLineArgs.History = *history
+	commandLineArgs.AccessLog = AccessLogArgs{
+		Path:       *accessLog,
+		Format:     *accessLogFormat,
+		MaxSize:    *accessLogMaxSize,
+		MaxBackups: *accessLogBackups,
+	}
+	commandLineArgs.Retry = RetryArgs{
+		Failures: retryFailures,
+		Key:      *retryKey,
+		TTL:      *retryTTL,
+		Failure:  *retryFailure,
+	}
+	commandLineArgs.TLS = TLSArgs{
+		Port:       *tlsPort,
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		ClientCA:   *tlsClientCA,
+		ClientAuth: *tlsClientAuth,
+		Fault:      *tlsFault,
+	}
+	commandLineArgs.HTTP2 = HTTP2Args{
+		Fault:     *http2Fault,
+		ErrorCode: *http2ErrorCode,
+		Window:    *http2Window,
+		Delay:     *http2Delay,
+	}
+	commandLineArgs.GRPC = GRPCArgs{
+		Port:        *grpcPort,
+		Descriptors: *grpcDescriptors,
+		Fault:       *grpcFault,
+		Code:        *grpcCode,
+		Messages:    *grpcMessages,
+	}
+	commandLineArgs.WebSocket = WebSocketArgs{
+		Messages:    *wsMessages,
+		Interval:    *wsInterval,
+		Drop:        *wsDrop,
+		After:       *wsAfter,
+		End:         *wsEnd,
+		CloseCode:   *wsCloseCode,
+		IgnorePings: *wsIgnorePings,
+	}
+	commandLineArgs.Stream = StreamArgs{
+		Format:    *streamFormat,
+		Interval:  *streamInterval,
+		Events:    *streamEvents,
+		Duplicate: *streamDuplicate,
+		After:     *streamAfter,
+		End:       *streamEnd,
+		Retry:     *streamRetry,
+	}
+	commandLineArgs.Body = BodyArgs{
+		Format:  *bodyFormat,
+		Corrupt: *bodyCorrupt,
+	}
+	commandLineArgs.Fixtures = FixtureArgs{
+		Path: *fixtures,
+		Mode: *fixturesMode,
+	}
+	commandLineArgs.OpenAPI = OpenAPIArgs{
+		Spec: *openAPI,
+	}
+	commandLineArgs.Overrides = OverrideArgs{
+		MaxDelay: *overrideDelay,
+		MaxSize:  *overrideSize,
+	}
+	if *config != "empty" {
+		commandLineArgs.Config = *config
+	}
 
-	var snoozer Snoozer = createSnoozer()
-	var responseBodyGenerator ResponseBodyGenerator = createResponseBodyGenerator()
-	var responseCodeGenerator ResponseCodeGenerator = NewRandomResponseCodeGenerator(responseCodes_300, responseCodes_400, responseCodes_500)
 	var argsReader = NewArgsConfigurationReader(&commandLineArgs)
 	var config = argsReader.Read()
+	if err := argsReader.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var profile = NewProfile(config)
 
 	fmt.Println(fmt.Sprintf("Enanos Server listening on port %d", *port))
 	var wg sync.WaitGroup
 	wg.Add(1)
 	serverFactory := ServerFactory{
 		Config:                config,
-		ResponseBodyGenerator: responseBodyGenerator,
-		ResponseCodeGenerator: responseCodeGenerator,
-		Snoozer:               snoozer,
+		ResponseBodyGenerator: profile.ResponseBodyGenerator,
+		ResponseCodeGenerator: profile.ResponseCodeGenerator,
+		Snoozer:               profile.Snoozer,
 		WaitHandle:            wg,
 	}
-	server := serverFactory.CreateServer()
-	server.Start()
-	wg.Wait()
-}
-
-func createSnoozer() Snoozer {
-	minSleepValue, minSleepErr := time.ParseDuration(*minSleep)
-	maxSleepValue, maxSleepErr := time.ParseDuration(*maxSleep)
-
-	if minSleepErr != nil || maxSleepErr != nil {
-		fmt.Errorf("Invalid duration specified for min or max sleep")
-		os.Exit(1)
-	}
-
-	if *randomSleep {
-		return NewRandomSnoozer(minSleepValue, maxSleepValue)
-	} else {
-		return NewMaxSnoozer(maxSleepValue)
-	}
-}
-
-func createResponseBodyGenerator() ResponseBodyGenerator {
-	minSizeValue, minSizeErr := humanize.ParseBytes(*minSize)
-	maxSizeValue, maxSizeErr := humanize.ParseBytes(*maxSize)
-
-	if minSizeErr != nil || maxSizeErr != nil {
-		fmt.Errorf("Invalid size specified for min or max size")
+	server, err := serverFactory.CreateServer()
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
-
-	if *randomSize {
-		return NewRandomResponseBodyGenerator(int(minSizeValue), int(maxSizeValue))
-	} else {
-		return NewMaxResponseBodyGenerator(int(maxSizeValue))
-	}
+	server.Start()
+	//Stopping writes the recorded fixtures
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Stop()
+		os.Exit(0)
+	}()
+	wg.Wait()
 }